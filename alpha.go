@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+)
+
+// alphaMask returns the alpha channel of img as a grayscale image, along with
+// whether img had any transparency at all.
+func alphaMask(img image.Image) (*image.Gray, bool) {
+	bounds := img.Bounds()
+	mask := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	hasAlpha := false
+
+	for y := range bounds.Dy() {
+		for x := range bounds.Dx() {
+			_, _, _, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			if a != 0xffff {
+				hasAlpha = true
+			}
+			mask.SetGray(x, y, color.Gray{Y: uint8(a >> 8)})
+		}
+	}
+
+	return mask, hasAlpha
+}
+
+// opaqueBounds returns the smallest rectangle containing every pixel of img
+// that is not fully transparent, which is empty when there are none.
+func opaqueBounds(img image.Image) image.Rectangle {
+	bounds := img.Bounds()
+	content := image.Rectangle{}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				content = content.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	return content
+}
+
+// crop copies the rect part of img into a new image anchored at the origin.
+func crop(img image.Image, rect image.Rectangle) *image.NRGBA {
+	destImg := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(destImg, destImg.Bounds(), img, rect.Min, draw.Src)
+
+	return destImg
+}
+
+// extractAlpha writes the alpha channel of inputFile to maskFile as a
+// grayscale PNG. Opaque inputs produce an all-white mask.
+func extractAlpha(inputFile string, maskFile string, config *Config) error {
+	srcImg, err := readImage(inputFile, config)
+	if err != nil {
+		return err
+	}
+
+	mask, hasAlpha := alphaMask(srcImg)
+	if !hasAlpha {
+		log.Printf("note: %s has no transparency, the mask is fully white", config.displayName(inputFile))
+	}
+
+	return writeOutput(maskFile, config, func(w io.Writer) error {
+		return encodePNG(w, mask, config)
+	})
+}
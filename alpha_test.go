@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrimTransparent(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+
+	tests := []struct {
+		name    string
+		content image.Rectangle
+		alpha   uint8
+	}{
+		{"opaque center", image.Rect(12, 8, 28, 22), 0xff},
+		{"off-center", image.Rect(0, 5, 7, 30), 0xff},
+		{"single faint pixel", image.Rect(20, 15, 21, 16), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+			for y := tt.content.Min.Y; y < tt.content.Max.Y; y++ {
+				for x := tt.content.Min.X; x < tt.content.Max.X; x++ {
+					src.SetNRGBA(x, y, color.NRGBA{R: red.R, A: tt.alpha})
+				}
+			}
+
+			dir := t.TempDir()
+			inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), src)
+			config := testConfig()
+			config.trimTransparent, config.premultiply = true, false
+			outputFile := filepath.Join(dir, "out.png")
+			if err := convertImage(inputFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			out := readTestImage(t, outputFile)
+			if got, want := out.Bounds().Size(), tt.content.Size(); got != want {
+				t.Fatalf("trimmed to %v, want %v", got, want)
+			}
+			want := color.NRGBA{R: red.R, A: tt.alpha}
+			for _, p := range []image.Point{{0, 0}, out.Bounds().Max.Sub(image.Pt(1, 1))} {
+				if got := color.NRGBAModel.Convert(out.At(p.X, p.Y)); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
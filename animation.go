@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"slices"
+)
+
+// readFrames returns the frames of inputFile: every frame of an animated
+// WebP with its own duration, or the image itself shown for --delay.
+func readFrames(inputFile string, config *Config) ([]animFrame, error) {
+	if inputFormat(inputFile, config) == "webp" {
+		return readWebPFrames(inputFile, config.frameDelay)
+	}
+
+	img, err := readImage(inputFile, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return []animFrame{{img: img, delay: config.frameDelay}}, nil
+}
+
+// convertAnimation renders the frames of all inputs, in order, into an
+// animated GIF. Frames smaller than the largest one are centered on a canvas
+// of that size, filled with the background color.
+func convertAnimation(inputFiles []string, outputFile string, config *Config) error {
+	var frames []image.Image
+	var delays []int
+	var canvas image.Rectangle
+	for _, inputFile := range inputFiles {
+		srcFrames, err := readFrames(inputFile, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inputFile, err)
+		}
+
+		meta := readMetadata(inputFile, config)
+		for _, srcFrame := range srcFrames {
+			if err := warnFlatten(srcFrame.img, inputFile, config); err != nil {
+				return err
+			}
+
+			frame, err := renderImage(toSRGB(srcFrame.img, meta), meta, config.background, config)
+			if err != nil {
+				return fmt.Errorf("%s: %w", inputFile, err)
+			}
+			frames = append(frames, frame)
+			delays = append(delays, srcFrame.delay)
+
+			size := frame.Bounds().Size()
+			canvas.Max.X = max(canvas.Max.X, size.X)
+			canvas.Max.Y = max(canvas.Max.Y, size.Y)
+		}
+	}
+
+	anim := &gif.GIF{
+		Image:     make([]*image.Paletted, len(frames)),
+		Delay:     make([]int, len(frames)),
+		LoopCount: config.loopCount,
+	}
+
+	bg := image.NewUniform(backgroundColor(config.background))
+	for i, frame := range frames {
+		if frame.Bounds().Size() != canvas.Size() {
+			padded := image.NewRGBA(canvas)
+			draw.Draw(padded, canvas, bg, image.Point{}, draw.Src)
+
+			offset := canvas.Size().Sub(frame.Bounds().Size()).Div(2)
+			draw.Draw(padded, frame.Bounds().Sub(frame.Bounds().Min).Add(offset), frame, frame.Bounds().Min, draw.Over)
+			frames[i] = padded
+		}
+	}
+
+	pal := config.palette
+	switch {
+	case pal == nil && (config.gifGlobalPalette || config.gifOptimize || config.quantize != ""):
+		pal = globalPalette(frames, config)
+	case pal == nil:
+		pal = palette.Plan9
+	}
+
+	// Frames whose palette matches the global color table don't repeat it
+	// as a local one.
+	if config.palette != nil || config.gifGlobalPalette || config.gifOptimize || config.quantize != "" {
+		anim.Config = image.Config{ColorModel: pal, Width: canvas.Dx(), Height: canvas.Dy()}
+	}
+
+	transparent, replaced := -1, -1
+	if config.gifTransparent != nil {
+		pal, transparent, replaced = gifTransparentIndex(pal, config.gifTransparent)
+		// Frames are cleared for the next one, or the transparent pixels
+		// would show the previous frame instead of the page.
+		anim.Disposal = make([]byte, len(frames))
+	}
+
+	if config.gifBackground != nil {
+		var background int
+		pal, background = gifBackgroundIndex(pal, config.gifBackground)
+		anim.Config = image.Config{ColorModel: pal, Width: canvas.Dx(), Height: canvas.Dy()}
+		anim.BackgroundIndex = uint8(background)
+	} else if anim.Config.ColorModel != nil {
+		anim.Config.ColorModel = pal
+	}
+
+	for i, frame := range frames {
+		anim.Image[i] = quantize(frame, pal, config.dither)
+		if transparent >= 0 {
+			maskGIFTransparent(anim.Image[i], frame, config.gifTransparent, transparent, replaced)
+			anim.Disposal[i] = gif.DisposalBackground
+		}
+		// GIF delays are in hundredths of a second.
+		anim.Delay[i] = delays[i] / 10
+	}
+
+	if config.gifOptimize {
+		optimizeFrames(anim)
+	}
+
+	return writeOutput(outputFile, config, func(w io.Writer) error {
+		return gif.EncodeAll(w, anim)
+	})
+}
+
+// gifTransparentIndex returns pal with an entry to make key transparent
+// through and the index of that entry. The GIF encoder treats the first fully
+// transparent entry as the transparent index, so an existing one is reused;
+// otherwise one is appended while there is room. A full palette gives up the
+// entry closest to key, and replaced is the index that pixels which used it
+// for other colors move to; it is -1 when no entry was given up.
+func gifTransparentIndex(pal color.Palette, key color.Color) (color.Palette, int, int) {
+	for i, c := range pal {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return pal, i, -1
+		}
+	}
+
+	if len(pal) < maxPaletteColors {
+		return append(slices.Clip(pal), color.NRGBA{}), len(pal), -1
+	}
+
+	transparent := pal.Index(key)
+	rest := slices.Delete(slices.Clone(pal), transparent, transparent+1)
+	replaced := rest.Index(pal[transparent])
+	if replaced >= transparent {
+		replaced++
+	}
+
+	out := slices.Clone(pal)
+	out[transparent] = color.NRGBA{}
+
+	return out, transparent, replaced
+}
+
+// gifBackgroundIndex returns pal and the index of c in it, appending c when
+// it isn't already there and there is room, or else falling back to the
+// closest entry.
+func gifBackgroundIndex(pal color.Palette, c color.Color) (color.Palette, int) {
+	index := pal.Index(c)
+	r1, g1, b1, a1 := pal[index].RGBA()
+	r2, g2, b2, a2 := c.RGBA()
+	if (r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2) || len(pal) >= maxPaletteColors {
+		return pal, index
+	}
+
+	return append(slices.Clip(pal), c), len(pal)
+}
+
+// maskGIFTransparent sets the pixels of p whose source pixel in src has
+// exactly the color of key to the transparent index, and moves pixels off
+// that index to replaced when gifTransparentIndex had to give it up.
+func maskGIFTransparent(p *image.Paletted, src image.Image, key color.Color, transparent int, replaced int) {
+	k := color.NRGBAModel.Convert(key).(color.NRGBA)
+	bounds := src.Bounds()
+
+	for y := range p.Rect.Dy() {
+		for x := range p.Rect.Dx() {
+			i := p.PixOffset(x, y)
+			c := color.NRGBAModel.Convert(src.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			switch {
+			case c.A != 0 && c.R == k.R && c.G == k.G && c.B == k.B:
+				p.Pix[i] = uint8(transparent)
+			case replaced >= 0 && int(p.Pix[i]) == transparent:
+				p.Pix[i] = uint8(replaced)
+			}
+		}
+	}
+}
+
+// globalPalette computes one palette for all frames with the --quantize
+// method, median cut unless another one is chosen. One entry is left free so
+// optimizeFrames has a transparent index to use.
+func globalPalette(frames []image.Image, config *Config) color.Palette {
+	return adaptivePalette(frames, maxPaletteColors-1, config)
+}
+
+// optimizeFrames shrinks every frame after the first to the rectangle that
+// differs from the frame before it. Frames are kept on screen for the next
+// one to draw over, and when the palette has room for a transparent index,
+// pixels inside the rectangle that didn't change are made transparent so
+// they compress better. A frame identical to the previous one becomes a
+// single unchanged pixel, since GIF frames can't be empty.
+//
+// The frames must all be quantized to the same palette.
+func optimizeFrames(anim *gif.GIF) {
+	anim.Disposal = make([]byte, len(anim.Image))
+	for i := range anim.Disposal {
+		anim.Disposal[i] = gif.DisposalNone
+	}
+
+	pal := anim.Image[0].Palette
+	transparent := -1
+	if len(pal) < maxPaletteColors {
+		transparent = len(pal)
+		pal = append(slices.Clip(pal), color.NRGBA{})
+
+		anim.Config.ColorModel = pal
+		anim.Image[0].Palette = pal
+	}
+
+	for i := len(anim.Image) - 1; i > 0; i-- {
+		prev, cur := anim.Image[i-1], anim.Image[i]
+
+		changed := image.Rectangle{}
+		for y := cur.Rect.Min.Y; y < cur.Rect.Max.Y; y++ {
+			for x := cur.Rect.Min.X; x < cur.Rect.Max.X; x++ {
+				if cur.ColorIndexAt(x, y) != prev.ColorIndexAt(x, y) {
+					changed = changed.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		if changed.Empty() {
+			changed = image.Rect(0, 0, 1, 1)
+		}
+
+		frame := image.NewPaletted(changed, pal)
+		for y := changed.Min.Y; y < changed.Max.Y; y++ {
+			for x := changed.Min.X; x < changed.Max.X; x++ {
+				index := cur.ColorIndexAt(x, y)
+				if transparent >= 0 && index == prev.ColorIndexAt(x, y) {
+					index = uint8(transparent)
+				}
+				frame.SetColorIndex(x, y, index)
+			}
+		}
+		anim.Image[i] = frame
+	}
+}
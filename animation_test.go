@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testFrames returns n solid frames of different colors.
+func testFrames(n int) []image.Image {
+	frames := make([]image.Image, n)
+	for i := range frames {
+		frame := image.NewRGBA(image.Rect(0, 0, 16, 12))
+		c := color.RGBA{R: uint8(60 * i), G: 100, B: uint8(255 - 60*i), A: 255}
+		for p := 0; p < len(frame.Pix); p += 4 {
+			frame.Pix[p], frame.Pix[p+1], frame.Pix[p+2], frame.Pix[p+3] = c.R, c.G, c.B, c.A
+		}
+		frames[i] = frame
+	}
+
+	return frames
+}
+
+// animate writes frames as PNG files, converts them into an animated GIF
+// with config and decodes the result.
+func animate(t *testing.T, config *Config, frames []image.Image) *gif.GIF {
+	t.Helper()
+
+	dir := t.TempDir()
+	var inputFiles []string
+	for i, frame := range frames {
+		inputFiles = append(inputFiles, writeTestImage(t, filepath.Join(dir, fmt.Sprintf("%d.png", i)), frame))
+	}
+
+	outputFile := filepath.Join(dir, "out.gif")
+	if err := convertAnimation(inputFiles, outputFile, config); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return anim
+}
+
+func TestAnimationDelayAndLoop(t *testing.T) {
+	tests := []struct {
+		delay, loop int
+		wantDelay   int
+	}{
+		{delay: 100, loop: 0, wantDelay: 10},
+		{delay: 250, loop: 3, wantDelay: 25},
+		{delay: 40, loop: -1, wantDelay: 4},
+		{delay: 0, loop: 1, wantDelay: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("delay %d loop %d", tt.delay, tt.loop), func(t *testing.T) {
+			config := testConfig()
+			config.frameDelay, config.loopCount = tt.delay, tt.loop
+
+			anim := animate(t, config, testFrames(3))
+			if len(anim.Image) != 3 {
+				t.Fatalf("%d frames, want 3", len(anim.Image))
+			}
+			for i, delay := range anim.Delay {
+				if delay != tt.wantDelay {
+					t.Errorf("frame %d delay = %d hundredths, want %d", i, delay, tt.wantDelay)
+				}
+			}
+			if anim.LoopCount != tt.loop {
+				t.Errorf("loop count = %d, want %d", anim.LoopCount, tt.loop)
+			}
+		})
+	}
+}
+
+// composite plays anim back, returning what is on screen after each frame.
+func composite(anim *gif.GIF) []*image.NRGBA {
+	canvas := image.NewNRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+	screens := make([]*image.NRGBA, len(anim.Image))
+	for i, frame := range anim.Image {
+		draw.Draw(canvas, frame.Rect, frame, frame.Rect.Min, draw.Over)
+		screens[i] = toNRGBA(canvas)
+		if anim.Disposal[i] == gif.DisposalBackground {
+			draw.Draw(canvas, frame.Rect, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+
+	return screens
+}
+
+func TestGIFOptimizeKeepsFrames(t *testing.T) {
+	solid := testFrames(3)
+	// The second frame changes a small patch of the first.
+	patched := toNRGBA(solid[0])
+	draw.Draw(patched, image.Rect(4, 3, 9, 7), image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name   string
+		frames []image.Image
+	}{
+		{"all different", solid},
+		{"patch", []image.Image{solid[0], patched, solid[1]}},
+		{"duplicates", []image.Image{solid[0], solid[0], solid[0], solid[2]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.frameDelay, config.gifGlobalPalette = 70, true
+			plain := animate(t, config, tt.frames)
+			config.gifOptimize = true
+			optimized := animate(t, config, tt.frames)
+
+			if len(optimized.Image) != len(tt.frames) {
+				t.Fatalf("%d frames, want %d", len(optimized.Image), len(tt.frames))
+			}
+			for i, delay := range optimized.Delay {
+				if delay != 7 {
+					t.Errorf("frame %d delay = %d hundredths, want 7", i, delay)
+				}
+			}
+
+			want, got := composite(plain), composite(optimized)
+			for i := range want {
+				if d := meanDifference(got[i], want[i]); d != 0 {
+					t.Errorf("frame %d plays back %.2f off the unoptimized frame", i, d)
+				}
+			}
+		})
+	}
+}
+
+// grayPalette returns n distinct opaque grays.
+func grayPalette(n int) color.Palette {
+	pal := make(color.Palette, n)
+	for i := range pal {
+		pal[i] = color.Gray{Y: uint8(i)}
+	}
+
+	return pal
+}
+
+func TestGIFTransparentIndex(t *testing.T) {
+	withTransparent := color.Palette{color.Black, color.NRGBA{}, color.White}
+
+	tests := []struct {
+		name            string
+		pal             color.Palette
+		key             color.Color
+		wantLen         int
+		wantTransparent int
+		wantReplaced    int
+	}{
+		{"reuses a transparent entry", withTransparent, color.White, 3, 1, -1},
+		{"appends while there is room", grayPalette(4), color.Gray{Y: 2}, 5, 4, -1},
+		// Gray 100 gives up its entry; its pixels move to gray 99.
+		{"gives up the closest entry", grayPalette(maxPaletteColors), color.Gray{Y: 100}, maxPaletteColors, 100, 99},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pal, transparent, replaced := gifTransparentIndex(tt.pal, tt.key)
+			if len(pal) != tt.wantLen || transparent != tt.wantTransparent || replaced != tt.wantReplaced {
+				t.Fatalf("gifTransparentIndex = %d colors, %d, %d, want %d colors, %d, %d",
+					len(pal), transparent, replaced, tt.wantLen, tt.wantTransparent, tt.wantReplaced)
+			}
+			if _, _, _, a := pal[transparent].RGBA(); a != 0 {
+				t.Errorf("entry %d = %v, want transparent", transparent, pal[transparent])
+			}
+			for i, c := range tt.pal {
+				if i != transparent && pal[i] != c {
+					t.Errorf("entry %d = %v, want %v kept", i, pal[i], c)
+				}
+			}
+		})
+	}
+}
+
+func TestGIFBackgroundIndex(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+
+	tests := []struct {
+		name      string
+		pal       color.Palette
+		c         color.Color
+		wantLen   int
+		wantIndex int
+	}{
+		{"already there", grayPalette(8), color.Gray{Y: 5}, 8, 5},
+		{"appended", grayPalette(8), red, 9, 8},
+		{"closest in a full palette", grayPalette(maxPaletteColors), color.RGBA{R: 100, G: 100, B: 110, A: 255}, maxPaletteColors, 103},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pal, index := gifBackgroundIndex(tt.pal, tt.c)
+			if len(pal) != tt.wantLen || index != tt.wantIndex {
+				t.Fatalf("gifBackgroundIndex = %d colors, %d, want %d colors, %d", len(pal), index, tt.wantLen, tt.wantIndex)
+			}
+		})
+	}
+}
+
+func TestGIFTransparentAndBackground(t *testing.T) {
+	frames := testFrames(2)
+	key := frames[1].At(0, 0)
+	bg := color.RGBA{R: 10, G: 20, B: 30, A: 255}
+
+	config := testConfig()
+	config.gifGlobalPalette, config.gifTransparent, config.gifBackground = true, key, bg
+	anim := animate(t, config, frames)
+
+	pal, ok := anim.Config.ColorModel.(color.Palette)
+	if !ok {
+		t.Fatalf("no global color table, got %T", anim.Config.ColorModel)
+	}
+	if got := color.RGBAModel.Convert(pal[anim.BackgroundIndex]); got != bg {
+		t.Errorf("background index %d = %v, want %v", anim.BackgroundIndex, got, bg)
+	}
+
+	for i, frame := range anim.Image {
+		_, _, _, a := frame.At(0, 0).RGBA()
+		if wantTransparent := i == 1; (a == 0) != wantTransparent {
+			t.Errorf("frame %d alpha = %d, want transparent %v", i, a, wantTransparent)
+		}
+		if anim.Disposal[i] != gif.DisposalBackground {
+			t.Errorf("frame %d disposal = %d, want DisposalBackground", i, anim.Disposal[i])
+		}
+	}
+}
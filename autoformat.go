@@ -0,0 +1,69 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+var formatExtensions = map[string]string{
+	"png":  ".png",
+	"jpeg": ".jpg",
+	"tiff": ".tiff",
+}
+
+// chooseFormat picks the output format for --auto-format. Images with any
+// transparency, or with at most maxColors distinct colors, are treated as
+// graphics and kept lossless as PNG. Everything else is assumed to be
+// photographic and encoded as JPEG.
+func chooseFormat(img image.Image, maxColors int) string {
+	bounds := img.Bounds()
+	colors := make(map[color.NRGBA]struct{}, maxColors+1)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 255 {
+				return "png"
+			}
+
+			if len(colors) <= maxColors {
+				colors[c] = struct{}{}
+			}
+		}
+	}
+
+	if len(colors) <= maxColors {
+		return "png"
+	}
+
+	return "jpeg"
+}
+
+// convertAutoFormat converts inputFile into the format chosen by chooseFormat,
+// naming the output outputBase plus the format's extension. It returns the
+// path that was written.
+func convertAutoFormat(inputFile string, outputBase string, config *Config) (string, error) {
+	srcImg, err := readImage(inputFile, config)
+	if err != nil {
+		return "", err
+	}
+
+	format := chooseFormat(srcImg, config.autoFormatColors)
+	outputFile := outputBase + formatExtensions[format]
+
+	bg := pngBackground(config)
+	if format == "jpeg" {
+		bg = config.background
+	}
+
+	meta := readMetadata(inputFile, config)
+	if format == "jpeg" {
+		srcImg = toSRGB(srcImg, meta)
+	}
+	destImg, err := renderImage(srcImg, meta, bg, config)
+	if err != nil {
+		return "", err
+	}
+
+	return outputFile, writeImage(outputFile, destImg, meta, config)
+}
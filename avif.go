@@ -0,0 +1,29 @@
+//go:build avif
+
+package main
+
+import (
+	"image"
+	"io"
+
+	"github.com/gen2brain/avif"
+)
+
+// avifSupported reports whether this binary was built with the AVIF encoder.
+const avifSupported = true
+
+func init() {
+	RegisterEncoder("avif", encodeAVIF)
+}
+
+func encodeAVIF(w io.Writer, img image.Image, config *Config) error {
+	return avif.Encode(w, img, avifOptions(config))
+}
+
+func avifOptions(config *Config) avif.Options {
+	return avif.Options{
+		Quality:      config.qualityFor("avif"),
+		QualityAlpha: config.qualityFor("avif"),
+		Speed:        avif.DefaultSpeed,
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !avif
+
+package main
+
+// avifSupported reports whether this binary was built with the AVIF encoder.
+// The encoder pulls in a WebAssembly runtime, so it is only compiled in with
+// the avif build tag.
+const avifSupported = false
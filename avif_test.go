@@ -0,0 +1,13 @@
+//go:build avif
+
+package main
+
+import "testing"
+
+func TestAVIFOptionsAutoQuality(t *testing.T) {
+	config := &Config{quality: 0, autoQuality: true, avifQuality: -1}
+	options := avifOptions(config)
+	if options.Quality == 0 || options.QualityAlpha == 0 {
+		t.Errorf("avif quality under --quality auto = %d (alpha %d), want %d", options.Quality, options.QualityAlpha, defaultQualities["avif"])
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// Background produces the image drawn behind the converted image.
+type Background interface {
+	// Image returns the source used to fill a canvas with the given bounds.
+	Image(canvas image.Rectangle) image.Image
+}
+
+type solidBackground struct {
+	color color.Color
+}
+
+func (b solidBackground) Image(canvas image.Rectangle) image.Image {
+	return image.NewUniform(b.color)
+}
+
+var gradientDirections = []string{"vertical", "horizontal", "diagonal"}
+
+type gradientBackground struct {
+	from      color.Color
+	to        color.Color
+	direction string
+}
+
+func (b gradientBackground) Image(canvas image.Rectangle) image.Image {
+	return &gradient{
+		from:      color.NRGBA64Model.Convert(b.from).(color.NRGBA64),
+		to:        color.NRGBA64Model.Convert(b.to).(color.NRGBA64),
+		direction: b.direction,
+		rect:      canvas,
+	}
+}
+
+// gradient is an image that linearly interpolates between two colors across
+// its bounds.
+type gradient struct {
+	from      color.NRGBA64
+	to        color.NRGBA64
+	direction string
+	rect      image.Rectangle
+}
+
+func (g *gradient) ColorModel() color.Model {
+	return color.NRGBA64Model
+}
+
+func (g *gradient) Bounds() image.Rectangle {
+	return g.rect
+}
+
+func (g *gradient) At(x, y int) color.Color {
+	fx := float64(x-g.rect.Min.X) / float64(max(1, g.rect.Dx()-1))
+	fy := float64(y-g.rect.Min.Y) / float64(max(1, g.rect.Dy()-1))
+
+	var t float64
+	switch g.direction {
+	case "horizontal":
+		t = fx
+	case "diagonal":
+		t = (fx + fy) / 2
+	default:
+		t = fy
+	}
+
+	t = min(1, max(0, t))
+	lerp := func(a, b uint16) uint16 {
+		return uint16(float64(a) + (float64(b)-float64(a))*t + 0.5)
+	}
+
+	return color.NRGBA64{
+		R: lerp(g.from.R, g.to.R),
+		G: lerp(g.from.G, g.to.G),
+		B: lerp(g.from.B, g.to.B),
+		A: lerp(g.from.A, g.to.A),
+	}
+}
+
+// checkerboardBackground is the gray and white pattern commonly used to show
+// transparency. It is baked into the pixels, so it is only useful for
+// previews.
+type checkerboardBackground struct {
+	size int
+}
+
+var (
+	checkerLight = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	checkerDark  = color.RGBA{R: 204, G: 204, B: 204, A: 255}
+)
+
+func (b checkerboardBackground) Image(canvas image.Rectangle) image.Image {
+	return &checkerboard{size: b.size, rect: canvas}
+}
+
+type checkerboard struct {
+	size int
+	rect image.Rectangle
+}
+
+func (c *checkerboard) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (c *checkerboard) Bounds() image.Rectangle {
+	return c.rect
+}
+
+func (c *checkerboard) At(x, y int) color.Color {
+	if ((x-c.rect.Min.X)/c.size+(y-c.rect.Min.Y)/c.size)%2 == 0 {
+		return checkerLight
+	}
+
+	return checkerDark
+}
+
+// imageBackground fills the canvas with a picture scaled to cover it.
+type imageBackground struct {
+	img image.Image
+}
+
+func (b imageBackground) Image(canvas image.Rectangle) image.Image {
+	covered := coverImage(b.img, Size{width: canvas.Dx(), height: canvas.Dy()})
+	return translatedImage{Image: covered, offset: canvas.Min}
+}
+
+// blurBackground fills the canvas with a heavily blurred copy of the image
+// being padded, scaled to cover it. The pad stage binds src to that image.
+type blurBackground struct {
+	src image.Image
+}
+
+// blurBackdropSide is the size the backdrop is blurred at before being
+// scaled up to the canvas, which makes a heavy blur cheap and smooth.
+const blurBackdropSide = 48
+
+func (b blurBackground) Image(canvas image.Rectangle) image.Image {
+	if b.src == nil || b.src.Bounds().Empty() {
+		return image.NewUniform(color.White)
+	}
+
+	scale := float64(blurBackdropSide) / float64(max(canvas.Dx(), canvas.Dy()))
+	small := toNRGBA(coverImage(b.src, Size{
+		width:  max(1, int(float64(canvas.Dx())*scale+0.5)),
+		height: max(1, int(float64(canvas.Dy())*scale+0.5)),
+	}))
+	blurNRGBA(small, 3)
+
+	backdrop := resizeImage(small, Size{width: canvas.Dx(), height: canvas.Dy()})
+	return translatedImage{Image: backdrop, offset: canvas.Min.Sub(backdrop.Bounds().Min)}
+}
+
+// blurNRGBA blurs img in place with a separable Gaussian blur. Unlike
+// gaussianBlur, pixels beyond the edges repeat the edge, so the borders
+// don't fade out.
+func blurNRGBA(img *image.NRGBA, sigma float64) {
+	kernel := gaussianKernel(sigma)
+	radius := len(kernel) / 2
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	tmp := make([]float64, len(img.Pix))
+
+	for y := range height {
+		for x := range width {
+			var sum [4]float64
+			for k, weight := range kernel {
+				i := img.PixOffset(img.Rect.Min.X+min(width-1, max(0, x+k-radius)), img.Rect.Min.Y+y)
+				for c := range sum {
+					sum[c] += weight * float64(img.Pix[i+c])
+				}
+			}
+			copy(tmp[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y):], sum[:])
+		}
+	}
+
+	for y := range height {
+		for x := range width {
+			var sum [4]float64
+			for k, weight := range kernel {
+				i := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+min(height-1, max(0, y+k-radius)))
+				for c := range sum {
+					sum[c] += weight * tmp[i+c]
+				}
+			}
+			i := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			for c := range sum {
+				img.Pix[i+c] = uint8(min(255, sum[c]+0.5))
+			}
+		}
+	}
+}
+
+// translatedImage shifts an image so its top-left corner sits at offset.
+type translatedImage struct {
+	image.Image
+	offset image.Point
+}
+
+func (t translatedImage) Bounds() image.Rectangle {
+	return t.Image.Bounds().Add(t.offset)
+}
+
+func (t translatedImage) At(x, y int) color.Color {
+	return t.Image.At(x-t.offset.X, y-t.offset.Y)
+}
+
+// parseBackground parses a --background value, which is a color, a gradient
+// in the form "gradient:FROM-TO", "checkerboard" or "blur".
+func parseBackground(bgStr string, direction string, checkerSize int) (Background, error) {
+	if strings.EqualFold(bgStr, "checkerboard") {
+		return checkerboardBackground{size: checkerSize}, nil
+	}
+
+	if strings.EqualFold(bgStr, "blur") {
+		return blurBackground{}, nil
+	}
+
+	spec, ok := strings.CutPrefix(strings.ToLower(bgStr), "gradient:")
+	if !ok {
+		c, err := parseBackgroundColor(bgStr)
+		if err != nil {
+			return nil, err
+		}
+
+		return solidBackground{color: c}, nil
+	}
+
+	fromStr, toStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid gradient %q: expected gradient:FROM-TO", bgStr)
+	}
+
+	from, err := parseBackgroundColor(fromStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse gradient start color: %w", err)
+	}
+
+	to, err := parseBackgroundColor(toStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse gradient end color: %w", err)
+	}
+
+	if !validGradientDirection(direction) {
+		return nil, fmt.Errorf("invalid gradient direction %q: expected one of %v", direction, gradientDirections)
+	}
+
+	return gradientBackground{from: from, to: to, direction: direction}, nil
+}
+
+func validGradientDirection(direction string) bool {
+	for _, d := range gradientDirections {
+		if direction == d {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backgroundColor returns a single color representative of bg, used where a
+// flat color is required such as flattening alpha.
+func backgroundColor(bg Background) color.Color {
+	switch b := bg.(type) {
+	case solidBackground:
+		return b.color
+	case gradientBackground:
+		return b.from
+	case checkerboardBackground:
+		return checkerLight
+	case imageBackground:
+		return color.White
+	default:
+		return color.White
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestPNGBackgrounds(t *testing.T) {
+	// A transparent 8x8 source, padded by 8 on every side.
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	src.SetNRGBA(4, 4, color.NRGBA{R: 255, A: 255})
+
+	green := color.NRGBA{G: 255, A: 255}
+	light := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	dark := color.NRGBA{R: 204, G: 204, B: 204, A: 255}
+
+	tests := []struct {
+		name    string
+		bg      string
+		top     color.Color
+		matte   string
+		corner  color.NRGBA
+		padding color.NRGBA
+		inside  color.NRGBA
+		topEdge color.NRGBA
+	}{
+		{name: "solid stays transparent", bg: "white"},
+		{name: "checkerboard", bg: "checkerboard", corner: light, padding: dark, inside: light, topEdge: dark},
+		{name: "checkerboard with a top edge", bg: "checkerboard", top: green, corner: green, padding: dark, inside: light, topEdge: green},
+		{name: "solid with a top edge", bg: "white", top: green, corner: green, topEdge: green},
+		// Row 12 of the 24 rows is 12/23 of the way down the gradient.
+		{
+			name:    "gradient",
+			bg:      "gradient:ff0000-0000ff",
+			corner:  color.NRGBA{R: 255, A: 255},
+			padding: color.NRGBA{R: 122, B: 133, A: 255},
+			inside:  color.NRGBA{R: 122, B: 133, A: 255},
+			topEdge: color.NRGBA{R: 255, A: 255},
+		},
+		{name: "matte wins", bg: "checkerboard", matte: "00ff00", corner: green, padding: green, inside: green, topEdge: green},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), src)
+
+			config := testConfig()
+			var err error
+			if config.background, err = parseBackground(tt.bg, "vertical", 8); err != nil {
+				t.Fatal(err)
+			}
+			if tt.matte != "" {
+				if config.matte, err = parseBackground(tt.matte, "vertical", 8); err != nil {
+					t.Fatal(err)
+				}
+			}
+			config.padding = Padding{Top: 8, Right: 8, Bottom: 8, Left: 8}
+			config.edgeColors.top = tt.top
+			outputFile := filepath.Join(dir, "out.png")
+			if err := convertImage(inputFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			out := readTestImage(t, outputFile)
+			for _, p := range []struct {
+				name string
+				at   image.Point
+				want color.NRGBA
+			}{
+				{"corner", image.Pt(0, 0), tt.corner},
+				{"left padding", image.Pt(0, 12), tt.padding},
+				{"transparent source pixel", image.Pt(8, 12), tt.inside},
+				{"top padding", image.Pt(8, 0), tt.topEdge},
+				{"opaque source pixel", image.Pt(12, 12), color.NRGBA{R: 255, A: 255}},
+			} {
+				if got := color.NRGBAModel.Convert(out.At(p.at.X, p.at.Y)); got != p.want {
+					t.Errorf("%s at %v = %v, want %v", p.name, p.at, got, p.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// batchJob is one conversion of a batch run.
+type batchJob struct {
+	inputFile  string
+	outputFile string
+}
+
+// batchResult is the outcome of a batchJob, reported in completion order.
+type batchResult struct {
+	job batchJob
+	// written is the path actually written, which differs from
+	// job.outputFile with --auto-format.
+	written string
+	savings string
+	err     error
+	// inputBytes and outputBytes are the file sizes, once converted.
+	inputBytes  int64
+	outputBytes int64
+}
+
+// batchFile is the --json report of one converted file.
+type batchFile struct {
+	Input       string `json:"input"`
+	Output      string `json:"output"`
+	InputBytes  int64  `json:"inputBytes"`
+	OutputBytes int64  `json:"outputBytes"`
+	// Kept is set when --only-if-smaller left the original in place.
+	Kept bool `json:"kept,omitempty"`
+}
+
+// batchSummary totals a batch run. Failed files count towards Files only.
+type batchSummary struct {
+	Files       int         `json:"files"`
+	Failed      int         `json:"failed"`
+	InputBytes  int64       `json:"inputBytes"`
+	OutputBytes int64       `json:"outputBytes"`
+	Converted   []batchFile `json:"converted"`
+}
+
+func (s *batchSummary) add(result batchResult) {
+	s.InputBytes += result.inputBytes
+	s.OutputBytes += result.outputBytes
+	s.Converted = append(s.Converted, batchFile{
+		Input:       result.job.inputFile,
+		Output:      result.written,
+		InputBytes:  result.inputBytes,
+		OutputBytes: result.outputBytes,
+		Kept:        errors.Is(result.err, errNotSmaller),
+	})
+}
+
+// print writes the totals of s as text, or as JSON together with every
+// converted file.
+func (s *batchSummary) print(asJSON bool) error {
+	saved := s.InputBytes - s.OutputBytes
+	var percent, average float64
+	if s.InputBytes > 0 {
+		percent = 100 * float64(saved) / float64(s.InputBytes)
+	}
+	if len(s.Converted) > 0 {
+		average = float64(saved) / float64(len(s.Converted))
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			*batchSummary
+			SavedBytes   int64   `json:"savedBytes"`
+			SavedPercent float64 `json:"savedPercent"`
+			AverageSaved float64 `json:"averageSavedBytes"`
+		}{s, saved, percent, average})
+	}
+
+	fmt.Printf("Processed %d of %d files: %d bytes in, %d bytes out\n", len(s.Converted), s.Files, s.InputBytes, s.OutputBytes)
+	fmt.Printf("Saved %d bytes (%.1f%%), %.0f bytes per file on average\n", saved, percent, average)
+	return nil
+}
+
+// readInputList reads one input path per line. Lines are taken verbatim,
+// apart from a trailing carriage return, so names with spaces or glob
+// characters need no quoting; blank lines are skipped.
+func readInputList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var inputFiles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		inputFiles = append(inputFiles, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(inputFiles) == 0 {
+		return nil, fmt.Errorf("input list %s: no input files", path)
+	}
+
+	return inputFiles, nil
+}
+
+// batchOutputFile names the output of inputFile in outDir: its base name,
+// slugified if asked, with the extension of the --out-format, or its own
+// extension otherwise. With --auto-format the extension is left for
+// convertAutoFormat to add.
+func batchOutputFile(inputFile string, outDir string, autoFormat bool, slug bool, config *Config) string {
+	base := filepath.Base(inputFile)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	if slug {
+		stem = slugify(stem)
+		ext = strings.ToLower(ext)
+	}
+
+	switch {
+	case autoFormat:
+		ext = ""
+	case config.outFormat != "":
+		ext = "." + config.outFormat
+		if formatExt, ok := formatExtensions[config.outFormat]; ok {
+			ext = formatExt
+		}
+	}
+
+	return filepath.Join(outDir, stem+ext)
+}
+
+// decodeMemoryFactor is how many full-size 8-bit RGBA copies of an image a
+// conversion is assumed to hold at once: the decoded source, a converted
+// copy for the filters and the output canvas.
+const decodeMemoryFactor = 3
+
+// estimateMemory guesses the bytes converting inputFile takes from the
+// dimensions in its header: width x height x 4 bytes per pixel x
+// decodeMemoryFactor. Padding and other growth are not accounted for. ok is
+// false when the header can't be read.
+func estimateMemory(inputFile string, config *Config) (int64, bool) {
+	var cfg image.Config
+	if inputFormat(inputFile, config) == "raw" {
+		cfg = image.Config{Width: config.raw.width, Height: config.raw.height}
+	} else {
+		f, err := os.Open(inputFile)
+		if err != nil {
+			return 0, false
+		}
+		defer f.Close()
+
+		cfg, _, err = image.DecodeConfig(bufio.NewReader(f))
+		if err != nil {
+			return 0, false
+		}
+	}
+
+	return int64(cfg.Width) * int64(cfg.Height) * 4 * decodeMemoryFactor, true
+}
+
+// memoryBudget is a weighted semaphore over bytes of memory. A job larger
+// than the whole budget is let through alone rather than never. Waiters are
+// served in arrival order, so a large job isn't starved by small ones that
+// keep fitting in before it; the price is that small jobs behind a large
+// one wait too, even when they would fit.
+type memoryBudget struct {
+	total int64
+	free  int64
+	// next is the ticket handed to the next caller of acquire, and serving
+	// the ticket whose turn it is.
+	next    uint64
+	serving uint64
+	mu      sync.Mutex
+	cond    *sync.Cond
+}
+
+func newMemoryBudget(total int64) *memoryBudget {
+	b := &memoryBudget{total: total, free: total}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until it is the oldest waiter and n bytes are free, and
+// takes them. It returns the amount taken, which must be passed to release.
+func (b *memoryBudget) acquire(n int64) int64 {
+	n = min(n, b.total)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ticket := b.next
+	b.next++
+	for ticket != b.serving || b.free < n {
+		b.cond.Wait()
+	}
+	b.free -= n
+	b.serving++
+	// The next waiter may fit in what is left.
+	b.cond.Broadcast()
+
+	return n
+}
+
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	b.free += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// runBatch converts every job, up to workers at a time, each with its own
+// copy of config. With a positive maxMemory, jobs also wait until their
+// estimateMemory fits in that many bytes next to the ones running; files
+// whose size can't be estimated are assumed to take all of it. Results are
+// printed by the calling goroutine as they come in, so lines from different
+// files never interleave, and a failed file doesn't stop the others; with
+// asJSON only failures are logged. A single worker converts the jobs in
+// order on the calling goroutine, so warnings and results come out the same
+// on every run. It returns the totals of the run.
+func runBatch(jobs []batchJob, workers int, autoFormat bool, onlyIfSmaller bool, maxMemory int64, asJSON bool, config *Config) *batchSummary {
+	var budget *memoryBudget
+	if maxMemory > 0 {
+		budget = newMemoryBudget(maxMemory)
+	}
+
+	summary := &batchSummary{Files: len(jobs), Converted: []batchFile{}}
+	if workers <= 1 {
+		for _, job := range jobs {
+			summary.report(runJob(job, budget, autoFormat, onlyIfSmaller, maxMemory, config), asJSON)
+		}
+		return summary
+	}
+
+	queue := make(chan batchJob)
+	results := make(chan batchResult)
+
+	var wg sync.WaitGroup
+	for range min(workers, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				results <- runJob(job, budget, autoFormat, onlyIfSmaller, maxMemory, config)
+			}
+		}()
+	}
+
+	go func() {
+		for _, job := range jobs {
+			queue <- job
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		summary.report(result, asJSON)
+	}
+
+	return summary
+}
+
+// runJob converts a single batch job, first taking its share of budget when
+// there is one.
+func runJob(job batchJob, budget *memoryBudget, autoFormat bool, onlyIfSmaller bool, maxMemory int64, config *Config) batchResult {
+	var reserved int64
+	if budget != nil {
+		estimate, ok := estimateMemory(job.inputFile, config)
+		if !ok {
+			estimate = maxMemory
+		}
+		reserved = budget.acquire(estimate)
+	}
+
+	// The input is measured first, as it may be replaced in place.
+	var inputBytes int64
+	if info, err := os.Stat(job.inputFile); err == nil {
+		inputBytes = info.Size()
+	}
+
+	jobConfig := *config
+	written, err := convertFile(job.inputFile, job.outputFile, autoFormat, onlyIfSmaller, &jobConfig)
+
+	if budget != nil {
+		budget.release(reserved)
+	}
+
+	result := batchResult{job: job, written: written, err: err, inputBytes: inputBytes}
+	switch {
+	case errors.Is(err, errNotSmaller):
+		result.written, result.outputBytes = job.inputFile, inputBytes
+	case err == nil:
+		result.savings = savingsReport(written, &jobConfig)
+		if info, err := os.Stat(written); err == nil {
+			result.outputBytes = info.Size()
+		}
+	}
+
+	return result
+}
+
+// report prints the outcome of result and adds it to the totals.
+func (s *batchSummary) report(result batchResult, asJSON bool) {
+	if result.err != nil && !errors.Is(result.err, errNotSmaller) {
+		log.Printf("%s: %v", result.job.inputFile, result.err)
+		s.Failed++
+		return
+	}
+	s.add(result)
+
+	switch {
+	case asJSON:
+	case result.err != nil:
+		fmt.Printf("Kept original: %s: %v\n", result.job.inputFile, result.err)
+	case result.savings != "":
+		fmt.Println("Image converted:", result.written, "-", result.savings)
+	default:
+		fmt.Println("Image converted:", result.written)
+	}
+}
+
+// slugify turns name into a web-safe file name: letters are lowercased and
+// Latin ones lose their diacritics, runs of spaces and other separators
+// become a single dash, and anything that isn't a letter, digit, dot,
+// underscore or dash is dropped. Letters of other scripts are kept as they
+// are, so that a name in Cyrillic or Japanese doesn't end up empty.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	latin := false
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.In(r, unicode.Mn, unicode.Mc):
+			// A combining mark split off its letter by NFD. Outside Latin it
+			// can be part of the letter, like the dakuten of a kana.
+			if !latin {
+				b.WriteRune(r)
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			latin = unicode.Is(unicode.Latin, r)
+			b.WriteRune(unicode.ToLower(r))
+		case r == '-' || unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r):
+			dash = true
+		}
+	}
+
+	if b.Len() == 0 {
+		return "image"
+	}
+
+	return norm.NFC.String(b.String())
+}
+
+// numberDuplicates gives every job whose output file an earlier job already
+// writes a -2, -3 and so on suffix, since slugify maps names like "My
+// Photo.jpg" and "my-photo.jpg" to the same one. With --auto-format the
+// output files have no extension yet.
+func numberDuplicates(jobs []batchJob, autoFormat bool) {
+	seen := map[string]bool{}
+	for i := range jobs {
+		outputFile := jobs[i].outputFile
+		ext := filepath.Ext(outputFile)
+		if autoFormat {
+			ext = ""
+		}
+		stem := strings.TrimSuffix(outputFile, ext)
+
+		for n := 2; seen[outputFile]; n++ {
+			outputFile = stem + "-" + strconv.Itoa(n) + ext
+		}
+		seen[outputFile] = true
+		jobs[i].outputFile = outputFile
+	}
+}
+
+// dirJobs plans the conversion of every image directly inside inDir, or
+// anywhere below it when recursive, into the same relative directory under
+// outDir, creating those directories. Files that aren't recognized as an
+// image are skipped; it returns how many were. An outDir inside inDir is not
+// descended into, so earlier output is never converted again.
+func dirJobs(inDir string, outDir string, recursive bool, autoFormat bool, slug bool, config *Config) ([]batchJob, int, error) {
+	absOut, err := filepath.Abs(outDir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var jobs []batchJob
+	skipped := 0
+	err = filepath.WalkDir(inDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if path == inDir {
+				return nil
+			}
+			if abs, err := filepath.Abs(path); !recursive || err == nil && abs == absOut {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		if config.inFormat == "" && sniffFile(path) == "unknown" {
+			skipped++
+			return nil
+		}
+
+		rel, err := filepath.Rel(inDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		jobDir := filepath.Join(outDir, rel)
+		if err := os.MkdirAll(jobDir, 0o755); err != nil {
+			return err
+		}
+
+		jobs = append(jobs, batchJob{inputFile: path, outputFile: batchOutputFile(path, jobDir, autoFormat, slug, config)})
+		return nil
+	})
+	if slug {
+		numberDuplicates(jobs, autoFormat)
+	}
+
+	return jobs, skipped, err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math/rand/v2"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "holiday photo", want: "holiday-photo"},
+		{in: "  lots   of\tspace  ", want: "lots-of-space"},
+		{in: "IMG_0042", want: "img_0042"},
+		{in: "CamelCase Name", want: "camelcase-name"},
+		{in: "Café Crème", want: "cafe-creme"},
+		{in: "Ærøskøbing", want: "ærøskøbing"},
+		{in: "Фото Москва", want: "фото-москва"},
+		{in: "Αθήνα", want: "αθήνα"},
+		{in: "Йошкар-Ола", want: "йошкар-ола"},
+		{in: "東京タワー", want: "東京タワー"},
+		{in: "がぎぐ", want: "がぎぐ"},
+		{in: "서울 사진", want: "서울-사진"},
+		{in: "a (1) [final]!", want: "a-1-final"},
+		{in: "v1.2_Final--copy", want: "v1.2_final-copy"},
+		{in: "★☆", want: "image"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := slugify(tt.in); got != tt.want {
+				t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlugifyNumbersDuplicates(t *testing.T) {
+	config := testConfig()
+	var jobs []batchJob
+	for _, name := range []string{"My Photo.JPG", "my-photo.jpg", "MY  PHOTO.jpg", "other.jpg"} {
+		jobs = append(jobs, batchJob{inputFile: name, outputFile: batchOutputFile(name, "out", false, true, config)})
+	}
+	numberDuplicates(jobs, false)
+
+	want := []string{"my-photo.jpg", "my-photo-2.jpg", "my-photo-3.jpg", "other.jpg"}
+	for i, job := range jobs {
+		if job.outputFile != filepath.Join("out", want[i]) {
+			t.Errorf("output of %q = %q, want %q", job.inputFile, job.outputFile, want[i])
+		}
+	}
+}
+
+func TestMemoryBudgetUnderLoad(t *testing.T) {
+	const total = 100
+	budget := newMemoryBudget(total)
+
+	var mu sync.Mutex
+	inUse, peak := int64(0), int64(0)
+
+	var wg sync.WaitGroup
+	for worker := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(worker), 0))
+			for range 20 {
+				// Some jobs ask for more than the whole budget.
+				n := budget.acquire(1 + rng.Int64N(total*3/2))
+
+				mu.Lock()
+				inUse += n
+				peak = max(peak, inUse)
+				mu.Unlock()
+
+				time.Sleep(time.Duration(rng.IntN(50)) * time.Microsecond)
+
+				mu.Lock()
+				inUse -= n
+				mu.Unlock()
+				budget.release(n)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > total {
+		t.Errorf("%d bytes were taken at once, the budget is %d", peak, total)
+	}
+	if budget.free != total {
+		t.Errorf("%d bytes free after every job released, want %d", budget.free, total)
+	}
+}
+
+func TestMemoryBudgetServesInOrder(t *testing.T) {
+	budget := newMemoryBudget(100)
+	held := budget.acquire(60)
+
+	// waiting returns once n goroutines have taken a ticket.
+	waiting := func(n uint64) {
+		for {
+			budget.mu.Lock()
+			queued := budget.next - budget.serving
+			budget.mu.Unlock()
+			if queued >= n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	order := make(chan int64, 2)
+	go func() { order <- budget.acquire(80) }()
+	waiting(1)
+	go func() { order <- budget.acquire(10) }()
+	waiting(2)
+
+	// The small job fits next to the held one but must wait its turn.
+	select {
+	case n := <-order:
+		t.Fatalf("the %d byte job went ahead of the 80 byte one queued before it", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	budget.release(held)
+	if first, second := <-order, <-order; first != 80 || second != 10 {
+		t.Errorf("jobs were served in the order %d, %d, want 80, 10", first, second)
+	}
+}
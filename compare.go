@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+type comparison struct {
+	differing int
+	total     int
+	meanError float64
+}
+
+func (c comparison) percent() float64 {
+	return 100 * float64(c.differing) / float64(c.total)
+}
+
+// compareImages compares a and b pixel by pixel. A pixel differs when any of
+// its channels differs by more than threshold (0 to 255). The returned image
+// shows a dimmed grayscale copy of a with differing pixels in red.
+func compareImages(a image.Image, b image.Image, threshold int) (*image.RGBA, comparison, error) {
+	boundsA, boundsB := a.Bounds(), b.Bounds()
+	if boundsA.Dx() != boundsB.Dx() || boundsA.Dy() != boundsB.Dy() {
+		return nil, comparison{}, fmt.Errorf(
+			"images have different dimensions: %dx%d and %dx%d (use --compare-resize to match them)",
+			boundsA.Dx(), boundsA.Dy(), boundsB.Dx(), boundsB.Dy(),
+		)
+	}
+
+	diffImg := image.NewRGBA(image.Rect(0, 0, boundsA.Dx(), boundsA.Dy()))
+	result := comparison{total: boundsA.Dx() * boundsA.Dy()}
+	var errorSum float64
+
+	for y := range boundsA.Dy() {
+		for x := range boundsA.Dx() {
+			ca := color.NRGBAModel.Convert(a.At(boundsA.Min.X+x, boundsA.Min.Y+y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(b.At(boundsB.Min.X+x, boundsB.Min.Y+y)).(color.NRGBA)
+
+			dr := absDiff(ca.R, cb.R)
+			dg := absDiff(ca.G, cb.G)
+			db := absDiff(ca.B, cb.B)
+			da := absDiff(ca.A, cb.A)
+			errorSum += float64(dr+dg+db+da) / 4
+
+			if max(dr, dg, db, da) > threshold {
+				result.differing++
+				diffImg.SetRGBA(x, y, color.RGBA{R: 255, A: 255})
+				continue
+			}
+
+			gray := uint8((299*int(ca.R) + 587*int(ca.G) + 114*int(ca.B)) / 1000 / 3)
+			diffImg.SetRGBA(x, y, color.RGBA{R: gray, G: gray, B: gray, A: 255})
+		}
+	}
+
+	result.meanError = errorSum / float64(result.total)
+
+	return diffImg, result, nil
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+
+	return int(b - a)
+}
+
+// compareFiles writes a visualization of the differences between fileA and
+// fileB to diffFile, resizing fileB to fileA's dimensions first when
+// resizeToMatch is set.
+func compareFiles(fileA string, fileB string, diffFile string, resizeToMatch bool, config *Config) (comparison, error) {
+	imgA, err := readImage(fileA, config)
+	if err != nil {
+		return comparison{}, fmt.Errorf("%s: %w", fileA, err)
+	}
+
+	imgB, err := readImage(fileB, config)
+	if err != nil {
+		return comparison{}, fmt.Errorf("%s: %w", fileB, err)
+	}
+
+	if resizeToMatch {
+		boundsA := imgA.Bounds()
+		imgB = resizeImage(imgB, Size{width: boundsA.Dx(), height: boundsA.Dy()})
+	}
+
+	diffImg, result, err := compareImages(imgA, imgB, config.compareThreshold)
+	if err != nil {
+		return comparison{}, err
+	}
+
+	return result, writeImage(diffFile, diffImg, nil, config)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"path/filepath"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// contactLabelHeight is the space reserved below each thumbnail for its
+// filename when labels are enabled.
+const contactLabelHeight = 16
+
+// createContactSheet arranges thumbnails of inputFiles, in order, into a grid
+// of cols x rows cells separated by gap pixels of background.
+func createContactSheet(inputFiles []string, outputFile string, grid Size, config *Config) error {
+	if len(inputFiles) > grid.width*grid.height {
+		return fmt.Errorf(
+			"%d images do not fit in a %dx%d contact sheet",
+			len(inputFiles), grid.width, grid.height,
+		)
+	}
+
+	cell := config.contactCell
+	cellHeight := cell.height
+	if config.contactLabels {
+		cellHeight += contactLabelHeight
+	}
+
+	gap := config.contactGap
+	rect := image.Rect(
+		0,
+		0,
+		grid.width*cell.width+(grid.width+1)*gap,
+		grid.height*cellHeight+(grid.height+1)*gap,
+	)
+
+	destImg := image.NewRGBA(rect)
+	draw.Draw(destImg, rect, config.background.Image(rect), image.Point{}, draw.Src)
+
+	for i, inputFile := range inputFiles {
+		srcImg, err := readImage(inputFile, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inputFile, err)
+		}
+
+		thumb := resizeImage(srcImg, fitSize(srcImg.Bounds(), cell))
+		thumbBounds := thumb.Bounds()
+
+		col, row := i%grid.width, i/grid.width
+		cellMin := image.Pt(gap+col*(cell.width+gap), gap+row*(cellHeight+gap))
+		offset := cellMin.Add(image.Pt(
+			(cell.width-thumbBounds.Dx())/2,
+			(cell.height-thumbBounds.Dy())/2,
+		))
+
+		draw.Draw(destImg, thumbBounds.Sub(thumbBounds.Min).Add(offset), thumb, thumbBounds.Min, draw.Over)
+
+		if config.contactLabels {
+			drawContactLabel(destImg, filepath.Base(inputFile), cellMin.Add(image.Pt(0, cell.height)), cell.width, config)
+		}
+	}
+
+	return writeImage(outputFile, destImg, nil, config)
+}
+
+// drawContactLabel centers name below a cell, truncating it to the cell
+// width.
+func drawContactLabel(destImg draw.Image, name string, min image.Point, width int, config *Config) {
+	drawer := &font.Drawer{
+		Dst:  destImg,
+		Src:  image.NewUniform(config.contactLabelColor),
+		Face: basicfont.Face7x13,
+	}
+
+	runes := []rune(name)
+	for len(runes) > 1 && drawer.MeasureString(string(runes)).Ceil() > width {
+		runes = runes[:len(runes)-1]
+	}
+
+	label := string(runes)
+	x := min.X + (width-drawer.MeasureString(label).Ceil())/2
+	drawer.Dot = fixed.P(x, min.Y+basicfont.Face7x13.Ascent+2)
+	drawer.DrawString(label)
+}
@@ -0,0 +1,105 @@
+// Package convert converts images between formats and pads them, reading
+// and writing streams rather than files, for programs that would otherwise
+// shell out to the image command. The command parses colors and padding,
+// pads and encodes with this package too; its other options, such as
+// resizing, filters and metadata, are not part of the API.
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// DefaultQuality is the JPEG quality used when Options.Quality is 0.
+const DefaultQuality = 90
+
+// ErrUnsupported is wrapped by errors about formats Convert cannot read or
+// write.
+var ErrUnsupported = errors.New("unsupported format")
+
+// Options controls a conversion.
+type Options struct {
+	// InFormat is the format of the input: png, jpeg, gif, tiff or webp.
+	// When empty it is detected from the content.
+	InFormat string
+	// OutFormat is the format to write: png, jpeg, gif or tiff.
+	OutFormat string
+	// Quality is the JPEG quality from 1 to 100, or 0 for DefaultQuality.
+	Quality int
+	// Padding is added around the image.
+	Padding Padding
+	// Background fills the padding and transparent areas of JPEG and GIF
+	// output, which have no alpha channel, or is white when nil. PNG and
+	// TIFF output leave the padding transparent.
+	Background color.Color
+}
+
+// Convert decodes an image from in, pads it and encodes it to out.
+func Convert(in io.Reader, out io.Writer, opts Options) error {
+	if opts.Quality < 0 || opts.Quality > 100 {
+		return fmt.Errorf("invalid quality %d: expected 1 to 100", opts.Quality)
+	}
+
+	if _, ok := encoders[opts.OutFormat]; !ok {
+		return fmt.Errorf("%w: cannot write %q", ErrUnsupported, opts.OutFormat)
+	}
+
+	img, format, err := image.Decode(in)
+	if errors.Is(err, image.ErrFormat) {
+		return fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.InFormat != "" && format != opts.InFormat {
+		return fmt.Errorf("input is %s, not %s", format, opts.InFormat)
+	}
+
+	var bg color.Color = color.Transparent
+	if opts.OutFormat == "jpeg" || opts.OutFormat == "gif" {
+		bg = opts.Background
+		if bg == nil {
+			bg = color.White
+		}
+	}
+
+	return Encode(out, Pad(img, opts.Padding, image.NewUniform(bg)), opts.OutFormat, opts.Quality)
+}
+
+// Encode writes img to w in format: png, jpeg, gif or tiff. quality only
+// applies to jpeg, from 1 to 100, or 0 for DefaultQuality.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	encode, ok := encoders[format]
+	if !ok {
+		return fmt.Errorf("%w: cannot write %q", ErrUnsupported, format)
+	}
+
+	return encode(w, img, quality)
+}
+
+var encoders = map[string]func(w io.Writer, img image.Image, quality int) error{
+	"png": func(w io.Writer, img image.Image, quality int) error {
+		return png.Encode(w, img)
+	},
+	"jpeg": func(w io.Writer, img image.Image, quality int) error {
+		if quality == 0 {
+			quality = DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	},
+	"gif": func(w io.Writer, img image.Image, quality int) error {
+		return gif.Encode(w, img, nil)
+	},
+	"tiff": func(w io.Writer, img image.Image, quality int) error {
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	},
+}
@@ -0,0 +1,48 @@
+package convert_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+
+	"github.com/arthvm/image/convert"
+)
+
+func ExampleConvert() {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	var in bytes.Buffer
+	if err := png.Encode(&in, src); err != nil {
+		log.Fatal(err)
+	}
+
+	padding, err := convert.ParsePadding("10,5")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	background, err := convert.ParseColor("black")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = convert.Convert(&in, &out, convert.Options{
+		OutFormat:  "jpeg",
+		Quality:    80,
+		Padding:    padding,
+		Background: background,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := jpeg.DecodeConfig(&out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%dx%d\n", cfg.Width, cfg.Height)
+	// Output: 50x50
+}
@@ -0,0 +1,26 @@
+package convert
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Canvas returns the bounds, at the origin, of the canvas an image with the
+// given bounds is padded onto, and the rectangle the image covers on it.
+func (p Padding) Canvas(bounds image.Rectangle) (canvas image.Rectangle, dest image.Rectangle) {
+	canvas = image.Rect(0, 0, bounds.Dx()+p.Left+p.Right, bounds.Dy()+p.Top+p.Bottom)
+	dest = bounds.Sub(bounds.Min).Add(image.Pt(p.Left, p.Top))
+
+	return canvas, dest
+}
+
+// Pad draws img over bg onto a new canvas grown by padding on each side.
+func Pad(img image.Image, padding Padding, bg image.Image) *image.RGBA {
+	rect, dest := padding.Canvas(img.Bounds())
+	canvas := image.NewRGBA(rect)
+
+	draw.Draw(canvas, rect, bg, image.Point{}, draw.Src)
+	draw.Draw(canvas, dest, img, img.Bounds().Min, draw.Over)
+
+	return canvas
+}
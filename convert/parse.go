@@ -0,0 +1,125 @@
+package convert
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Padding is the number of pixels added on each side of an image.
+type Padding struct {
+	Top    int
+	Right  int
+	Bottom int
+	Left   int
+}
+
+const paddingForms = "expected 1, 2 or 4 comma-separated integers (ALL, VERTICAL,HORIZONTAL or TOP,RIGHT,BOTTOM,LEFT)"
+
+// ParsePadding parses padding written like CSS: one value for every side,
+// VERTICAL,HORIZONTAL or TOP,RIGHT,BOTTOM,LEFT, none of them negative. An
+// empty string is no padding.
+func ParsePadding(paddingStr string) (Padding, error) {
+	if paddingStr == "" {
+		return Padding{}, nil
+	}
+
+	fields := strings.Split(paddingStr, ",")
+
+	var names []string
+	switch len(fields) {
+	case 1:
+		names = []string{"padding"}
+	case 2:
+		names = []string{"vertical (top and bottom) padding", "horizontal (right and left) padding"}
+	case 4:
+		names = []string{"top padding", "right padding", "bottom padding", "left padding"}
+	default:
+		return Padding{}, fmt.Errorf("invalid padding %q: got %d values, %s", paddingStr, len(fields), paddingForms)
+	}
+
+	values := make([]int, len(fields))
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return Padding{}, fmt.Errorf("invalid padding %q: %s is empty, %s", paddingStr, names[i], paddingForms)
+		}
+
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return Padding{}, fmt.Errorf("invalid padding %q: %s %q is not an integer", paddingStr, names[i], field)
+		}
+		if value < 0 {
+			return Padding{}, fmt.Errorf("invalid padding %q: %s %d is negative", paddingStr, names[i], value)
+		}
+
+		values[i] = value
+	}
+
+	switch len(values) {
+	case 1:
+		return Padding{Top: values[0], Right: values[0], Bottom: values[0], Left: values[0]}, nil
+	case 2:
+		return Padding{Top: values[0], Right: values[1], Bottom: values[0], Left: values[1]}, nil
+	default:
+		return Padding{Top: values[0], Right: values[1], Bottom: values[2], Left: values[3]}, nil
+	}
+}
+
+// ParseColor parses a color name, black, white, red, green or blue in any
+// case, or a hex color as accepted by ParseHexColor.
+func ParseColor(colorStr string) (color.Color, error) {
+	switch strings.ToLower(colorStr) {
+	case "black":
+		return color.Black, nil
+	case "white":
+		return color.White, nil
+	case "red":
+		return color.RGBA{R: 255, A: 255}, nil
+	case "green":
+		return color.RGBA{G: 255, A: 255}, nil
+	case "blue":
+		return color.RGBA{B: 255, A: 255}, nil
+	default:
+		return ParseHexColor(colorStr)
+	}
+}
+
+// ParseHexColor parses an RRGGBB color, optionally prefixed with #, along
+// with the RGB shorthand, where each digit is doubled, and RRGGBBAA with a
+// non-premultiplied alpha.
+func ParseHexColor(hexStr string) (color.Color, error) {
+	digits := strings.TrimPrefix(hexStr, "#")
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+
+	if len(digits) != 6 && len(digits) != 8 {
+		return nil, fmt.Errorf("invalid hex color %q: expected 6 hex digits, or 3 or 8", hexStr)
+	}
+
+	vals, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex color %q: %q is not a hex digit", hexStr, firstNonHex(digits))
+	}
+
+	if len(vals) == 4 {
+		return color.NRGBA{R: vals[0], G: vals[1], B: vals[2], A: vals[3]}, nil
+	}
+
+	return color.RGBA{R: vals[0], G: vals[1], B: vals[2], A: 255}, nil
+}
+
+// firstNonHex returns the first character of s that isn't a hex digit.
+func firstNonHex(s string) string {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !strings.ContainsRune("0123456789abcdefABCDEF", r)
+	})
+	if i < 0 {
+		return ""
+	}
+
+	return string([]rune(s[i:])[:1])
+}
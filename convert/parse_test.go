@@ -0,0 +1,119 @@
+package convert
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.Color
+		wantErr string
+	}{
+		{in: "#f80", want: color.RGBA{R: 0xff, G: 0x88, B: 0x00, A: 255}},
+		{in: "ff8800", want: color.RGBA{R: 0xff, G: 0x88, B: 0x00, A: 255}},
+		{in: "#FF8800", want: color.RGBA{R: 0xff, G: 0x88, B: 0x00, A: 255}},
+		{in: "#ff880080", want: color.NRGBA{R: 0xff, G: 0x88, B: 0x00, A: 0x80}},
+		{in: "", wantErr: "expected 6 hex digits"},
+		{in: "#ff88", wantErr: "expected 6 hex digits"},
+		{in: "#ff8800800", wantErr: "expected 6 hex digits"},
+		{in: "#ff88zz", wantErr: `"z" is not a hex digit`},
+		{in: "#g80", wantErr: `"g" is not a hex digit`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseHexColor(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseHexColor(%q) error = %v, want one containing %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseHexColor(%q) error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseHexColor(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.Color
+		wantErr string
+	}{
+		{in: "white", want: color.White},
+		{in: "Black", want: color.Black},
+		{in: "RED", want: color.RGBA{R: 255, A: 255}},
+		{in: "green", want: color.RGBA{G: 255, A: 255}},
+		{in: "blue", want: color.RGBA{B: 255, A: 255}},
+		{in: "#0000ff", want: color.RGBA{B: 255, A: 255}},
+		{in: "purple", wantErr: "invalid hex color"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseColor(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseColor(%q) error = %v, want one containing %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseColor(%q) error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseColor(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePadding(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Padding
+		wantErr string
+	}{
+		{in: "", want: Padding{}},
+		{in: "10", want: Padding{Top: 10, Right: 10, Bottom: 10, Left: 10}},
+		{in: "10, 20", want: Padding{Top: 10, Right: 20, Bottom: 10, Left: 20}},
+		{in: "1,2,3,4", want: Padding{Top: 1, Right: 2, Bottom: 3, Left: 4}},
+		{in: "10,,5,5", wantErr: "right padding is empty"},
+		{in: "1,2,3", wantErr: "got 3 values"},
+		{in: "10,", wantErr: "horizontal (right and left) padding is empty"},
+		{in: "1,2,3,4,", wantErr: "got 5 values"},
+		{in: "ten", wantErr: `padding "ten" is not an integer`},
+		{in: "-20", wantErr: "padding -20 is negative"},
+		{in: "5,-1", wantErr: "horizontal (right and left) padding -1 is negative"},
+		{in: "0,0,-5,0", wantErr: "bottom padding -5 is negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePadding(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParsePadding(%q) error = %v, want one containing %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParsePadding(%q) error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePadding(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
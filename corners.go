@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Corners holds a radius per corner, in pixels.
+type Corners struct {
+	topLeft     int
+	topRight    int
+	bottomRight int
+	bottomLeft  int
+}
+
+// parseRadius parses --radius as either one radius for every corner or four
+// comma-separated radii in TOP-LEFT,TOP-RIGHT,BOTTOM-RIGHT,BOTTOM-LEFT order.
+func parseRadius(radiusStr string) (Corners, error) {
+	if radiusStr == "" {
+		return Corners{}, nil
+	}
+
+	fields := strings.Split(radiusStr, ",")
+	if len(fields) != 1 && len(fields) != 4 {
+		return Corners{}, fmt.Errorf(
+			"invalid radius %q: got %d values, expected 1 or 4 comma-separated integers (ALL or TOP-LEFT,TOP-RIGHT,BOTTOM-RIGHT,BOTTOM-LEFT)",
+			radiusStr, len(fields),
+		)
+	}
+
+	names := []string{"top-left", "top-right", "bottom-right", "bottom-left"}
+	values := make([]int, len(fields))
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+
+		value, err := strconv.Atoi(field)
+		if err != nil || value < 0 {
+			name := "radius"
+			if len(fields) == 4 {
+				name = names[i] + " radius"
+			}
+			return Corners{}, fmt.Errorf("invalid radius %q: %s %q is not a non-negative integer", radiusStr, name, field)
+		}
+
+		values[i] = value
+	}
+
+	if len(values) == 1 {
+		return Corners{values[0], values[0], values[0], values[0]}, nil
+	}
+
+	return Corners{values[0], values[1], values[2], values[3]}, nil
+}
+
+// roundCorners returns img with its corners cut along circular arcs of the
+// given radii, anti-aliasing the edge by how much of each pixel the arc
+// covers. Radii are capped at half the shorter side.
+func roundCorners(img image.Image, radii Corners) *image.NRGBA {
+	destImg := toNRGBA(img)
+	width, height := destImg.Rect.Dx(), destImg.Rect.Dy()
+	limit := min(width, height) / 2
+
+	corners := []struct {
+		radius int
+		// right and bottom tell which edges the corner touches.
+		right, bottom bool
+	}{
+		{radii.topLeft, false, false},
+		{radii.topRight, true, false},
+		{radii.bottomRight, true, true},
+		{radii.bottomLeft, false, true},
+	}
+
+	for _, corner := range corners {
+		r := min(corner.radius, limit)
+		if r == 0 {
+			continue
+		}
+
+		for dy := range r {
+			for dx := range r {
+				// Distance from the arc's center to the pixel center.
+				dist := math.Hypot(float64(r-dx)-0.5, float64(r-dy)-0.5)
+				coverage := min(1, max(0, float64(r)-dist+0.5))
+				if coverage == 1 {
+					continue
+				}
+
+				x, y := dx, dy
+				if corner.right {
+					x = width - 1 - dx
+				}
+				if corner.bottom {
+					y = height - 1 - dy
+				}
+
+				i := destImg.PixOffset(x, y) + 3
+				destImg.Pix[i] = uint8(float64(destImg.Pix[i])*coverage + 0.5)
+			}
+		}
+	}
+
+	return destImg
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestParseRadius(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Corners
+		wantErr bool
+	}{
+		{in: "", want: Corners{}},
+		{in: "8", want: Corners{8, 8, 8, 8}},
+		{in: "1,2,3,4", want: Corners{topLeft: 1, topRight: 2, bottomRight: 3, bottomLeft: 4}},
+		{in: "0, 5, 0, 0", want: Corners{topRight: 5}},
+		{in: "1,2", wantErr: true},
+		{in: "1,2,-3,4", wantErr: true},
+		{in: "a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRadius(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRadius(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRadius(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundCornersIndependently(t *testing.T) {
+	const size = 40
+	points := []struct {
+		name string
+		x, y int
+	}{
+		{"top-left", 0, 0},
+		{"top-right", size - 1, 0},
+		{"bottom-right", size - 1, size - 1},
+		{"bottom-left", 0, size - 1},
+	}
+
+	tests := []struct {
+		radii Corners
+		cut   string
+	}{
+		{Corners{topLeft: 10}, "top-left"},
+		{Corners{topRight: 10}, "top-right"},
+		{Corners{bottomRight: 10}, "bottom-right"},
+		{Corners{bottomLeft: 10}, "bottom-left"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cut, func(t *testing.T) {
+			src := image.NewNRGBA(image.Rect(0, 0, size, size))
+			draw.Draw(src, src.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+
+			out := roundCorners(src, tt.radii)
+			for _, p := range points {
+				want := uint8(0xff)
+				if p.name == tt.cut {
+					want = 0
+				}
+				if got := out.NRGBAAt(p.x, p.y).A; got != want {
+					t.Errorf("alpha at the %s corner = %d, want %d", p.name, got, want)
+				}
+			}
+			if got := out.NRGBAAt(size/2, size/2).A; got != 0xff {
+				t.Errorf("alpha at the center = %d, want 255", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"slices"
+
+	"golang.org/x/image/tiff"
+)
+
+type decodeFunc func(r io.Reader) (image.Image, error)
+
+var decoders = map[string]decodeFunc{}
+
+func init() {
+	RegisterDecoder("png", png.Decode)
+	RegisterDecoder("jpeg", decodeJPEG)
+	RegisterDecoder("tiff", tiff.Decode)
+	RegisterDecoder("gif", gif.Decode)
+	RegisterDecoder("webp", decodeWebP)
+	// Only names the format; decoderFor substitutes the --raw-* layout.
+	RegisterDecoder("raw", rawLayout{}.decode)
+}
+
+var errHEICUnsupported = fmt.Errorf("%w input format: heic, rebuild with -tags heic", errUnsupported)
+
+// decoderFor returns the decoder registered for format, or for raw input
+// one reading the layout given by the --raw-* flags. JPEG input honors
+// --allow-partial.
+func decoderFor(format string, config *Config) (decodeFunc, error) {
+	switch format {
+	case "raw":
+		return config.raw.decode, nil
+	case "jpeg":
+		return jpegDecoder(config), nil
+	}
+
+	decode, ok := decoders[format]
+	if !ok {
+		if format == "heic" && !heicSupported {
+			return nil, errHEICUnsupported
+		}
+		return nil, fmt.Errorf("%w input format: %s", errUnsupported, format)
+	}
+
+	return decode, nil
+}
+
+func readImage(inputFile string, config *Config) (image.Image, error) {
+	decode, err := decoderFor(inputFormat(inputFile, config), config)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return decode(f)
+}
+
+// adobeCMYKSegment is an Adobe APP14 segment declaring transform 0, meaning
+// the components are stored untransformed as CMYK.
+var adobeCMYKSegment = []byte{
+	0xff, 0xee, 0x00, 0x0e,
+	'A', 'd', 'o', 'b', 'e',
+	0x00, 0x64, 0x00, 0x00, 0x00, 0x00,
+	0x00,
+}
+
+// decodeJPEG decodes a JPEG, including CMYK files image/jpeg rejects. The
+// decoder already undoes the inverted ink values Adobe tools write, but fails
+// on 4-component files without an Adobe APP14 marker. Those hold plain,
+// non-inverted CMYK, so they are decoded with a CMYK marker spliced in and
+// the inversion the decoder then applies is reversed. CMYK results are
+// converted to RGB when composited onto the canvas.
+func decodeJPEG(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+
+	var unsupported jpeg.UnsupportedError
+	if errors.As(err, &unsupported) && bytes.Contains([]byte(unsupported), []byte("APP14")) && len(data) > 2 {
+		patched := append(append(data[:2:2], adobeCMYKSegment...), data[2:]...)
+		img, err = jpeg.Decode(bytes.NewReader(patched))
+		if cmyk, ok := img.(*image.CMYK); ok {
+			for i := range cmyk.Pix {
+				cmyk.Pix[i] = 255 - cmyk.Pix[i]
+			}
+		}
+	}
+
+	return img, err
+}
+
+// jpegDecoder returns the registered JPEG decoder, which with
+// --allow-partial falls back to decodeTruncatedJPEG.
+func jpegDecoder(config *Config) decodeFunc {
+	decode := decoders["jpeg"]
+	if !config.allowPartial {
+		return decode
+	}
+
+	return func(r io.Reader) (image.Image, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+
+		img, err := decode(bytes.NewReader(data))
+		if err == nil {
+			return img, nil
+		}
+
+		partial, partialErr := decodeTruncatedJPEG(data)
+		if partialErr != nil {
+			return nil, err
+		}
+
+		return partial, warn(config, "keeping partial image: %v", err)
+	}
+}
+
+// maxPartialPixels bounds the size a truncated JPEG may declare for
+// --allow-partial to salvage it. The made-up data grows with the declared
+// size, which a few hundred crafted bytes could otherwise set to gigabytes.
+const maxPartialPixels = 1 << 26
+
+// decodeTruncatedJPEG salvages a JPEG whose image data is cut short.
+// image/jpeg returns no image at all in that case, so the missing data is
+// made up: zero bytes, decoding to flat gray noise, followed by an end of
+// image marker. The padding allows for every pixel the header declares,
+// more than the entropy coded blocks of zeros ever take.
+func decodeTruncatedJPEG(data []byte) (image.Image, error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > maxPartialPixels {
+		return nil, fmt.Errorf("cannot salvage a %dx%d JPEG, more than %d pixels", cfg.Width, cfg.Height, maxPartialPixels)
+	}
+
+	padded := append(slices.Clip(data), make([]byte, cfg.Width*cfg.Height/4+1024)...)
+	padded = append(padded, 0xff, 0xd9)
+
+	return decodeJPEG(bytes.NewReader(padded))
+}
+
+// sniffFormat names the format of an image from its first bytes, or returns
+// "unknown".
+func sniffFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, pngSignature):
+		return "png"
+	case bytes.HasPrefix(header, []byte{0xff, 0xd8, 0xff}):
+		return "jpeg"
+	case bytes.HasPrefix(header, []byte("GIF8")):
+		return "gif"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "tiff"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "webp"
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		return sniffHEIF(header)
+	}
+
+	return "unknown"
+}
+
+// sniffHEIF tells AVIF from HEIC by the brands of an ftyp box. Both often
+// have the generic mif1 or msf1 as major brand, so the compatible brands
+// are checked for avif or avis before falling back to heic.
+func sniffHEIF(header []byte) string {
+	brands := []string{string(header[8:12])}
+	end := min(len(header), int(binary.BigEndian.Uint32(header[:4])))
+	for i := 16; i+4 <= end; i += 4 {
+		brands = append(brands, string(header[i:i+4]))
+	}
+
+	format := "unknown"
+	for _, brand := range brands {
+		switch brand {
+		case "avif", "avis":
+			return "avif"
+		case "heic", "heix", "hevc", "hevx", "mif1", "msf1":
+			format = "heic"
+		}
+	}
+
+	return format
+}
+
+// sniffFile names the format of inputFile from its content, or returns
+// "unknown" when it can't be read or isn't recognized.
+func sniffFile(inputFile string) string {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return "unknown"
+	}
+	defer f.Close()
+
+	// Enough for the compatible brands of an ftyp box.
+	header := make([]byte, 64)
+	n, _ := io.ReadFull(f, header)
+
+	return sniffFormat(header[:n])
+}
+
+// warnMisnamed warns when the extension of inputFile names a different
+// format than its content, which is what it is decoded as.
+func warnMisnamed(inputFile string, config *Config) error {
+	if config.inFormat != "" {
+		return nil
+	}
+
+	sniffed, named := sniffFile(inputFile), detectFormat(inputFile)
+	if sniffed == "unknown" || named == "unknown" || sniffed == named {
+		return nil
+	}
+
+	return warn(config, "%s is named like %s but holds %s data, decoding it as %s", config.displayName(inputFile), named, sniffed, sniffed)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ftypBox returns an ftyp box with the given major and compatible brands.
+func ftypBox(major string, compatible ...string) []byte {
+	box := binary.BigEndian.AppendUint32(nil, uint32(16+4*len(compatible)))
+	box = append(box, "ftyp"+major+"\x00\x00\x00\x00"...)
+	for _, brand := range compatible {
+		box = append(box, brand...)
+	}
+
+	return box
+}
+
+func TestSniffHEIF(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"avif major", ftypBox("avif", "mif1", "miaf"), "avif"},
+		{"avif sequence", ftypBox("avis", "msf1"), "avif"},
+		{"avif behind mif1", ftypBox("mif1", "avif", "miaf", "MA1B"), "avif"},
+		{"heic major", ftypBox("heic", "mif1"), "heic"},
+		{"heic behind mif1", ftypBox("mif1", "heic"), "heic"},
+		{"bare mif1", ftypBox("mif1"), "heic"},
+		{"mp4", ftypBox("isom", "iso2", "mp41"), "unknown"},
+		{"brands past the box", append(ftypBox("mif1"), "avif"...), "heic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffFormat(tt.header); got != tt.want {
+				t.Errorf("sniffFormat = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// truncatedJPEG returns a JPEG whose entropy coded data stops halfway.
+func truncatedJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testJPEG(t, 64, 48), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	sos := bytes.Index(data, []byte{0xff, 0xda})
+	if sos < 0 {
+		t.Fatal("no SOS marker")
+	}
+
+	return data[:sos+(len(data)-sos)/2]
+}
+
+func TestAllowPartial(t *testing.T) {
+	inputFile := filepath.Join(t.TempDir(), "truncated.jpg")
+	if err := os.WriteFile(inputFile, truncatedJPEG(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig()
+	if _, err := readImage(inputFile, config); err == nil {
+		t.Fatal("a truncated JPEG decoded without --allow-partial")
+	}
+
+	config.allowPartial = true
+	img, err := readImage(inputFile, config)
+	if err != nil {
+		t.Fatalf("--allow-partial: %v", err)
+	}
+	if got := img.Bounds(); got != image.Rect(0, 0, 64, 48) {
+		t.Errorf("partial image bounds = %v, want the declared 64x48", got)
+	}
+
+	config.strict = true
+	if _, err := readImage(inputFile, config); err == nil {
+		t.Error("--strict kept a partial image instead of failing")
+	}
+}
+
+func TestAllowPartialDeclaredSizeLimit(t *testing.T) {
+	data := truncatedJPEG(t)
+
+	// Claim 65535x65535 pixels in the baseline frame header.
+	sof := bytes.Index(data, []byte{0xff, 0xc0})
+	if sof < 0 {
+		t.Fatal("no SOF0 marker")
+	}
+	binary.BigEndian.PutUint16(data[sof+5:], 0xffff)
+	binary.BigEndian.PutUint16(data[sof+7:], 0xffff)
+
+	if _, err := decodeTruncatedJPEG(data); err == nil || !strings.Contains(err.Error(), "cannot salvage") {
+		t.Errorf("decodeTruncatedJPEG of a 65535x65535 header = %v, want the size rejected", err)
+	}
+}
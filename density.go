@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseDensities parses a comma separated --densities value such as
+// "1x,2x,3x". Factors may be fractional, like 1.5x.
+func parseDensities(densitiesStr string) ([]float64, error) {
+	var densities []float64
+	for _, part := range strings.Split(densitiesStr, ",") {
+		part = strings.TrimSpace(strings.ToLower(part))
+
+		density, err := strconv.ParseFloat(strings.TrimSuffix(part, "x"), 64)
+		if err != nil || !strings.HasSuffix(part, "x") || density <= 0 || density > 16 {
+			return nil, fmt.Errorf("invalid density %q: expected a factor such as 2x, up to 16x", part)
+		}
+		if slices.Contains(densities, density) {
+			return nil, fmt.Errorf("invalid densities %q: %s is listed twice", densitiesStr, part)
+		}
+
+		densities = append(densities, density)
+	}
+
+	return densities, nil
+}
+
+// densityOutputName inserts the @2x style suffix of density before the
+// extension of outputFile. The 1x output keeps the plain name.
+func densityOutputName(outputFile string, density float64) string {
+	if density == 1 {
+		return outputFile
+	}
+
+	ext := filepath.Ext(outputFile)
+	return strings.TrimSuffix(outputFile, ext) + "@" + strconv.FormatFloat(density, 'f', -1, 64) + "x" + ext
+}
+
+// scaleSize multiplies both dimensions of size by factor. A missing
+// dimension of a partial size stays missing.
+func scaleSize(size Size, factor float64) Size {
+	scale := func(n int) int {
+		if n == 0 {
+			return 0
+		}
+		return max(1, int(float64(n)*factor+0.5))
+	}
+
+	return Size{width: scale(size.width), height: scale(size.height)}
+}
+
+// scaleGeometry multiplies the lengths config gives in output pixels by
+// factor: the padding, corner radii, shadow and caption size. A density then
+// renders as its 1x output scaled up, rather than with thinner padding.
+func scaleGeometry(config *Config, factor float64) {
+	scale := func(n int) int {
+		return int(math.Round(float64(n) * factor))
+	}
+
+	config.padding = Padding{
+		Top:    scale(config.padding.Top),
+		Right:  scale(config.padding.Right),
+		Bottom: scale(config.padding.Bottom),
+		Left:   scale(config.padding.Left),
+	}
+	config.radius = Corners{
+		topLeft:     scale(config.radius.topLeft),
+		topRight:    scale(config.radius.topRight),
+		bottomRight: scale(config.radius.bottomRight),
+		bottomLeft:  scale(config.radius.bottomLeft),
+	}
+	if config.shadow != nil {
+		shadow := *config.shadow
+		shadow.blur *= factor
+		shadow.offset = image.Pt(scale(shadow.offset.X), scale(shadow.offset.Y))
+		config.shadow = &shadow
+	}
+	config.textSize *= factor
+}
+
+// convertDensities renders inputFile once per density, named by
+// densityOutputName. The 1x size is the --resize box, or otherwise the
+// source divided by the highest density, so that one is rendered at native
+// resolution. Other pixel lengths are scaled by scaleGeometry. Rendering a density larger than the source fails unless
+// allowUpscale is set. It returns the files written.
+func convertDensities(inputFile string, outputFile string, densities []float64, allowUpscale bool, config *Config) ([]string, error) {
+	srcImg, err := readImage(inputFile, config)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := readMetadata(inputFile, config)
+	srcImg = toSRGB(srcImg, meta)
+	bounds := srcImg.Bounds()
+
+	// Without --resize, sizes are derived from the source rather than a
+	// rounded 1x size, so the highest density matches it exactly.
+	base, unit := config.resize, 1.0
+	if base.isZero() {
+		base, unit = Size{width: bounds.Dx(), height: bounds.Dy()}, slices.Max(densities)
+	}
+
+	bg := pngBackground(config)
+	if format := outputFormat(outputFile, config); format == "jpeg" || format == "gif" {
+		bg = config.background
+	}
+
+	// Every density is checked before anything is written.
+	configs := make([]Config, len(densities))
+	for i, density := range densities {
+		configs[i] = *config
+		configs[i].resize = scaleSize(base, density/unit)
+		scaleGeometry(&configs[i], density)
+
+		target := resizeTarget(bounds, &configs[i])
+		if !allowUpscale && (target.width > bounds.Dx() || target.height > bounds.Dy()) {
+			return nil, fmt.Errorf(
+				"%s is %dx%d, too small for %gx at %dx%d; pass --allow-upscale to enlarge it",
+				inputFile, bounds.Dx(), bounds.Dy(), density, target.width, target.height,
+			)
+		}
+	}
+
+	var written []string
+	for i, density := range densities {
+		densityConfig := &configs[i]
+		densityFile := densityOutputName(outputFile, density)
+		destImg, err := renderImage(srcImg, meta, bg, densityConfig)
+		if err != nil {
+			return written, fmt.Errorf("%s: %w", densityFile, err)
+		}
+
+		if err := writeImage(densityFile, destImg, meta, densityConfig); err != nil {
+			return written, err
+		}
+		written = append(written, densityFile)
+	}
+
+	return written, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+func TestDensitiesScalePadding(t *testing.T) {
+	dir := t.TempDir()
+	inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 80, 60))
+
+	config := testConfig()
+	config.padding = Padding{Top: 5, Right: 3, Bottom: 5, Left: 3}
+	config.radius = Corners{topLeft: 4}
+	outputFile := filepath.Join(dir, "out.png")
+	if _, err := convertDensities(inputFile, outputFile, []float64{1, 2}, false, config); err != nil {
+		t.Fatal(err)
+	}
+
+	base := readTestImage(t, outputFile)
+	double := readTestImage(t, densityOutputName(outputFile, 2))
+	if got, want := double.Bounds(), image.Rect(0, 0, 2*base.Bounds().Dx(), 2*base.Bounds().Dy()); got != want {
+		t.Fatalf("@2x output is %v, want twice the 1x %v", got, base.Bounds())
+	}
+
+	// The @2x output is transparent where the 1x output is, in the padding
+	// and the rounded corner alike. Antialiased edge pixels are skipped.
+	for y := range base.Bounds().Dy() {
+		for x := range base.Bounds().Dx() {
+			_, _, _, a := base.At(x, y).RGBA()
+			if a != 0 && a != 0xffff {
+				continue
+			}
+			for _, p := range []image.Point{{2 * x, 2 * y}, {2*x + 1, 2*y + 1}} {
+				_, _, _, a2 := double.At(p.X, p.Y).RGBA()
+				if (a == 0) != (a2 < 0x8000) {
+					t.Fatalf("1x pixel (%d, %d) has alpha %d, @2x pixel %v has %d", x, y, a, p, a2)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// maxDeskewAngle is the largest skew --deskew corrects, in degrees. Anything
+// steeper is more likely a misdetection than a crooked scan.
+const maxDeskewAngle = 15
+
+// deskewMaxSide bounds the resolution the skew is estimated at; larger images
+// are sampled with a stride.
+const deskewMaxSide = 1000
+
+// skewAngle estimates by how many degrees the lines of a scanned document
+// slope clockwise, using projection profiles: the dark pixels are projected
+// onto the vertical axis of the page turned by each candidate angle, and the
+// angle whose profile changes most sharply from row to row, which happens
+// when the projected rows line up with the text lines, wins. Candidates are searched coarsely over
+// ±maxDeskewAngle first, then refined around the best one.
+func skewAngle(img image.Image) float64 {
+	src := toNRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	step := max(1, (max(width, height)+deskewMaxSide-1)/deskewMaxSide)
+
+	// Dark pixels are those below the threshold Otsu's method picks to
+	// separate ink from paper.
+	cutoff := otsuThreshold(computeHistogram(src).luma)
+
+	var points [][2]float64
+	for y := 0; y < height; y += step {
+		for x := 0; x < width; x += step {
+			i := src.PixOffset(x, y)
+			if src.Pix[i+3] >= 128 && int(luma(src.Pix[i], src.Pix[i+1], src.Pix[i+2])) < cutoff {
+				points = append(points, [2]float64{float64(x / step), float64(y / step)})
+			}
+		}
+	}
+
+	if len(points) == 0 {
+		return 0
+	}
+
+	diagonal := int(math.Hypot(float64(width/step), float64(height/step))) + 1
+	profile := make([]int, 2*diagonal+1)
+
+	score := func(degrees float64) float64 {
+		clear(profile)
+		sin, cos := math.Sincos(degrees * math.Pi / 180)
+		for _, p := range points {
+			profile[int(math.Round(p[1]*cos-p[0]*sin))+diagonal]++
+		}
+
+		var sum float64
+		for i := 1; i < len(profile); i++ {
+			d := float64(profile[i] - profile[i-1])
+			sum += d * d
+		}
+		return sum
+	}
+
+	search := func(from, to, step float64) float64 {
+		best, bestScore := 0.0, -1.0
+		for degrees := from; degrees <= to+step/2; degrees += step {
+			if s := score(degrees); s > bestScore {
+				best, bestScore = degrees, s
+			}
+		}
+		return best
+	}
+
+	coarse := search(-maxDeskewAngle, maxDeskewAngle, 0.5)
+	fine := search(max(coarse-0.5, -maxDeskewAngle), min(coarse+0.5, maxDeskewAngle), 0.05)
+
+	// Round to the search resolution, also turning -0 into 0.
+	angle := math.Round(fine*100) / 100
+	if angle == 0 {
+		return 0
+	}
+
+	return angle
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/arthvm/image/convert"
+)
+
+type encodeFunc func(w io.Writer, img image.Image, config *Config) error
+
+var encoders = map[string]encodeFunc{}
+
+func init() {
+	RegisterEncoder("png", encodePNG)
+	RegisterEncoder("jpeg", encodeJPEG)
+	RegisterEncoder("tiff", encodeTIFF)
+	RegisterEncoder("gif", encodeGIF)
+	RegisterEncoder("raw", encodeRaw)
+}
+
+// encodePNG picks its compression level from the flags alone, so identical
+// pixels encode to identical bytes. The ancillary chunks withPNGChunks adds
+// are just as stable under --reproducible, which leaves out the conversion
+// time and the input's directory.
+func encodePNG(w io.Writer, img image.Image, config *Config) error {
+	enc := png.Encoder{CompressionLevel: pngCompression(config)}
+	if !config.interlace {
+		return enc.Encode(w, img)
+	}
+
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	interlaced, err := interlacePNG(buf.Bytes(), enc.CompressionLevel)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(interlaced)
+	return err
+}
+
+func encodeJPEG(w io.Writer, img image.Image, config *Config) error {
+	quality := config.qualityFor("jpeg")
+	if config.autoQuality {
+		quality = autoJPEGQuality(img)
+		if config.verbose {
+			log.Printf("auto quality: %d", quality)
+		}
+	}
+
+	return convert.Encode(w, img, "jpeg", quality)
+}
+
+// encodeGIF writes a single frame GIF, reduced to the --palette-file palette,
+// to one fitted by --quantize, or to the standard Plan 9 palette.
+func encodeGIF(w io.Writer, img image.Image, config *Config) error {
+	pal := config.palette
+	switch {
+	case pal == nil && config.quantize != "":
+		pal = adaptivePalette([]image.Image{img}, maxPaletteColors, config)
+	case pal == nil:
+		pal = palette.Plan9
+	}
+
+	paletted := quantize(img, pal, config.dither)
+	if config.gifTransparent != nil {
+		var transparent, replaced int
+		paletted.Palette, transparent, replaced = gifTransparentIndex(paletted.Palette, config.gifTransparent)
+		maskGIFTransparent(paletted, img, config.gifTransparent, transparent, replaced)
+	}
+
+	if config.gifBackground == nil {
+		return gif.Encode(w, paletted, nil)
+	}
+
+	// Only a global color table has a background index.
+	var background int
+	paletted.Palette, background = gifBackgroundIndex(paletted.Palette, config.gifBackground)
+	return gif.EncodeAll(w, &gif.GIF{
+		Image: []*image.Paletted{paletted},
+		Delay: []int{0},
+		Config: image.Config{
+			ColorModel: paletted.Palette,
+			Width:      paletted.Rect.Dx(),
+			Height:     paletted.Rect.Dy(),
+		},
+		BackgroundIndex: uint8(background),
+	})
+}
+
+func encodeTIFF(w io.Writer, img image.Image, config *Config) error {
+	return convert.Encode(w, img, "tiff", 0)
+}
+
+// writeImage encodes img in the output format and writes it to outputFile,
+// carrying over whatever source metadata the config asks to keep. meta may be
+// nil for synthesized images.
+func writeImage(outputFile string, img image.Image, meta *Metadata, config *Config) error {
+	format := outputFormat(outputFile, config)
+
+	encode, ok := encoders[format]
+	if !ok {
+		return fmt.Errorf("%w output format: %s", errUnsupported, format)
+	}
+
+	// The comment is wrapped first so EXIF stays right after SOI.
+	if format == "jpeg" && meta != nil && meta.source != "" {
+		encode = withJPEGSegment(encode, jpegSourceComment(meta, config))
+	}
+
+	if format == "jpeg" && meta != nil {
+		app1, err := exifSegment(meta, config)
+		if err != nil {
+			return err
+		}
+		if app1 != nil {
+			encode = withJPEGSegment(encode, app1)
+		}
+	}
+
+	// The pixels of PNG sources are passed through untouched, so their
+	// color chunks still apply.
+	if format == "png" && meta != nil && meta.pngColor != nil {
+		encode = withPNGChunks(encode, meta.pngColor.chunks)
+	}
+
+	if format == "png" && meta != nil && meta.source != "" {
+		encode = withPNGChunks(encode, pngSourceChunks(meta, config))
+	}
+
+	if config.fitBytes > 0 {
+		if format != "jpeg" {
+			return fmt.Errorf("--scale-to-fit-bytes needs JPEG output, not %s", format)
+		}
+		encode = withByteBudget(encode, config.fitBytes)
+	}
+
+	if config.maxOutputSize > 0 {
+		encode = withSizeLimit(encode, config.maxOutputSize)
+	}
+
+	if format == "png" && config.palette != nil {
+		img = quantize(img, config.palette, config.dither)
+	} else if format == "png" && config.quantize != "" {
+		img = quantize(img, adaptivePalette([]image.Image{img}, maxPaletteColors, config), config.dither)
+	}
+
+	if config.dataURI {
+		return writeDataURI(outputFile, format, img, encode, config)
+	}
+
+	return writeOutput(outputFile, config, func(w io.Writer) error {
+		return encode(w, img, config)
+	})
+}
+
+// dataURIWarnSize is the encoded size above which inlining an image as a data
+// URI usually costs more than it saves.
+const dataURIWarnSize = 32 * 1024
+
+var mimeTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"tiff": "image/tiff",
+	"avif": "image/avif",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// writeDataURI encodes img and writes it as a base64 data URI to outputFile,
+// or to stdout when outputFile is "-".
+func writeDataURI(outputFile string, format string, img image.Image, encode encodeFunc, config *Config) error {
+	var buf bytes.Buffer
+	if err := encode(&buf, img, config); err != nil {
+		return err
+	}
+
+	mimeType, ok := mimeTypes[format]
+	if !ok {
+		mimeType = "application/octet-stream"
+	}
+
+	uri := "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	if len(uri) > dataURIWarnSize {
+		if err := warn(config, "data URI is %d KB, inlining images this large is discouraged", len(uri)/1024); err != nil {
+			return err
+		}
+	}
+
+	if outputFile == "-" {
+		_, err := fmt.Fprintln(os.Stdout, uri)
+		return err
+	}
+
+	return writeOutput(outputFile, config, func(w io.Writer) error {
+		_, err := io.WriteString(w, uri)
+		return err
+	})
+}
+
+// withJPEGSegment wraps a JPEG encoder so that segment is inserted right
+// after the start-of-image marker.
+func withJPEGSegment(encode encodeFunc, segment []byte) encodeFunc {
+	return func(w io.Writer, img image.Image, config *Config) error {
+		var buf bytes.Buffer
+		if err := encode(&buf, img, config); err != nil {
+			return err
+		}
+
+		data := buf.Bytes()
+		if _, err := w.Write(data[:2]); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(segment); err != nil {
+			return err
+		}
+
+		_, err := w.Write(data[2:])
+		return err
+	}
+}
+
+// withSizeLimit wraps an encoder so that it fails instead of writing anything
+// when the encoded image exceeds limit bytes.
+func withSizeLimit(encode encodeFunc, limit int64) encodeFunc {
+	return func(w io.Writer, img image.Image, config *Config) error {
+		var buf bytes.Buffer
+		if err := encode(&buf, img, config); err != nil {
+			return err
+		}
+
+		if int64(buf.Len()) > limit {
+			return fmt.Errorf("encoded output is %d KB, above the %d KB limit", (buf.Len()+1023)/1024, limit/1024)
+		}
+
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+}
+
+// errNotSmaller is returned by writeOutput, without writing anything, when
+// --only-if-smaller is set and the output would not be smaller than the
+// original.
+var errNotSmaller = errors.New("output is not smaller than the original")
+
+// writeOutput runs write against a temporary file next to outputFile and
+// moves it into place once it succeeds, so readers never observe a
+// partially written image and a failed conversion leaves nothing behind.
+// Unless --in-place, the move refuses to replace a file that appeared at
+// outputFile while writing. Failures after the existence check are returned
+// as an *outputError.
+func writeOutput(outputFile string, config *Config, write func(w io.Writer) error) error {
+	if _, err := os.Lstat(outputFile); err == nil && !config.inPlace {
+		return &os.PathError{Op: "create", Path: outputFile, Err: os.ErrExist}
+	}
+
+	if config.onlyIfSmaller > 0 {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			return &outputError{err}
+		}
+
+		if int64(buf.Len()) >= config.onlyIfSmaller {
+			return fmt.Errorf("%w: %d bytes, the original has %d", errNotSmaller, buf.Len(), config.onlyIfSmaller)
+		}
+
+		write = func(w io.Writer) error {
+			_, err := w.Write(buf.Bytes())
+			return err
+		}
+	}
+
+	tmpDir := filepath.Dir(outputFile)
+	if config.tmpDir != "" {
+		tmpDir = config.tmpDir
+	}
+
+	tmpFile, err := createTemp(tmpDir, outputFile, config.fileMode)
+	if err != nil {
+		return &outputError{err}
+	}
+	defer removeTemp(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if err := write(tmpFile); err != nil {
+		return &outputError{err}
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return &outputError{err}
+	}
+
+	if err := renameOrCopy(tmpFile.Name(), outputFile, config); err != nil {
+		return &outputError{err}
+	}
+
+	if !config.modTime.IsZero() {
+		if err := os.Chtimes(outputFile, time.Time{}, config.modTime); err != nil {
+			return &outputError{err}
+		}
+	}
+
+	return nil
+}
+
+// createTemp creates a new temporary file for outputFile in dir. It is
+// created with mode rather than chmod-ed to it afterwards, so the umask
+// still applies. It is added to tempFiles until removeTemp.
+func createTemp(dir string, outputFile string, mode os.FileMode) (*os.File, error) {
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, "."+filepath.Base(outputFile)+"."+strconv.FormatUint(uint64(rand.Uint32()), 10)+".tmp")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, mode)
+		if errors.Is(err, os.ErrExist) && try < 100 {
+			continue
+		}
+
+		if err == nil {
+			tempFiles.Lock()
+			tempFiles.names[name] = true
+			tempFiles.Unlock()
+		}
+
+		return f, err
+	}
+}
+
+// removeTemp removes a file created by createTemp, if it was not moved.
+func removeTemp(name string) {
+	os.Remove(name)
+
+	tempFiles.Lock()
+	delete(tempFiles.names, name)
+	tempFiles.Unlock()
+}
+
+// linkFile is os.Link, replaced in tests by filesystems without hard links.
+var linkFile = os.Link
+
+// moveFile moves src to outputFile. With --in-place it replaces the
+// original; otherwise it links src into place, which fails rather than
+// clobbers a file someone else created there, and then removes src.
+// Filesystems without hard links, such as FAT or some network mounts, get
+// an empty file created exclusively at outputFile instead, which src is
+// renamed over.
+func moveFile(src string, outputFile string, config *Config) error {
+	if config.inPlace {
+		return os.Rename(src, outputFile)
+	}
+
+	err := linkFile(src, outputFile)
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP) {
+		return claimAndRename(src, outputFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// claimAndRename creates outputFile, failing if it exists, and renames src
+// over it.
+func claimAndRename(src string, outputFile string) error {
+	f, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	if err := os.Rename(src, outputFile); err != nil {
+		os.Remove(outputFile)
+		return err
+	}
+
+	return nil
+}
+
+// renameOrCopy moves the finished temporary file src to outputFile. A --tmpdir
+// on another filesystem cannot be moved across, so then src is copied to a
+// second temporary file next to outputFile, which is moved instead and keeps
+// the replacement atomic; src is left for the caller to remove.
+func renameOrCopy(src string, outputFile string, config *Config) error {
+	err := moveFile(src, outputFile, config)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if config.verbose {
+		log.Printf("%s is on another filesystem, copying the output", config.tmpDir)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createTemp(filepath.Dir(outputFile), outputFile, config.fileMode)
+	if err != nil {
+		return err
+	}
+	defer removeTemp(out.Name())
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return moveFile(out.Name(), outputFile, config)
+}
+
+func createPlaceholder(outputFile string, width int, height int, config *Config) error {
+	rect := image.Rect(0, 0, width, height)
+	destImg := image.NewRGBA(rect)
+
+	draw.Draw(destImg, rect, config.background.Image(rect), image.Point{}, draw.Src)
+
+	return writeImage(outputFile, destImg, nil, config)
+}
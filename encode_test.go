@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReproduciblePNG(t *testing.T) {
+	img := testJPEG(t, 48, 32)
+	var inputs []string
+	for _, dir := range []string{"a", "b"} {
+		dir = filepath.Join(t.TempDir(), dir)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, writeTestImage(t, filepath.Join(dir, "in.jpg"), img))
+	}
+
+	// encode converts inputFile with --embed-source, the one piece of
+	// metadata that depends on where the conversion runs.
+	encode := func(inputFile string, reproducible bool) []byte {
+		t.Helper()
+
+		config := testConfig()
+		config.embedSource, config.reproducible = true, reproducible
+		outputFile := filepath.Join(t.TempDir(), "out.png")
+		if err := convertImage(inputFile, outputFile, config); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return data
+	}
+
+	if first, second := encode(inputs[0], true), encode(inputs[0], true); !bytes.Equal(first, second) {
+		t.Error("encoding the same input twice gave different bytes")
+	}
+	if a, b := encode(inputs[0], true), encode(inputs[1], true); !bytes.Equal(a, b) {
+		t.Error("--reproducible output depends on the directory of the input")
+	}
+	if a, b := encode(inputs[0], false), encode(inputs[1], false); bytes.Equal(a, b) {
+		t.Error("without --reproducible, --embed-source lost the directory of the input")
+	}
+}
+
+func TestMoveFileWithoutHardLinks(t *testing.T) {
+	linkFile = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	t.Cleanup(func() { linkFile = os.Link })
+
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "out.png")
+	err := writeOutput(outputFile, testConfig(), func(w io.Writer) error {
+		_, err := io.WriteString(w, "new")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(outputFile); err != nil || string(data) != "new" {
+		t.Errorf("output = %q, %v, want the written bytes", data, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files left in the output directory, want only the output", len(entries))
+	}
+
+	// A file that appeared while writing is still never replaced.
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("newer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveFile(src, outputFile, testConfig()); !errors.Is(err, os.ErrExist) {
+		t.Errorf("moving onto an existing file = %v, want it to exist", err)
+	}
+	if data, _ := os.ReadFile(outputFile); string(data) != "new" {
+		t.Errorf("existing output changed to %q", data)
+	}
+}
+
+func TestInterruptMidEncode(t *testing.T) {
+	if dir := os.Getenv("IMAGE_TEST_INTERRUPT_DIR"); dir != "" {
+		handleInterrupts()
+
+		config := testConfig()
+		config.inPlace = true
+		writeOutput(filepath.Join(dir, "out.png"), config, func(w io.Writer) error {
+			io.WriteString(w, "partial")
+			select {}
+		})
+		return
+	}
+
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "out.png")
+	if err := os.WriteFile(outputFile, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInterruptMidEncode$")
+	cmd.Env = append(os.Environ(), "IMAGE_TEST_INTERRUPT_DIR="+dir)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the encode to be under way.
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) > 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			t.Fatal("no temporary file appeared")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); cmd.ProcessState.ExitCode() != exitInterrupted {
+		t.Errorf("interrupted conversion exited with %v, want code %d", err, exitInterrupted)
+	}
+
+	if data, err := os.ReadFile(outputFile); err != nil || string(data) != "original" {
+		t.Errorf("output after the interrupt = %q, %v, want it untouched", data, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "out.png" {
+			t.Errorf("interrupted conversion left %s behind", entry.Name())
+		}
+	}
+}
+
+func TestTmpDirOnAnotherFilesystem(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup returns the --tmpdir to use and restores anything changed.
+		setup func(t *testing.T) string
+		// mayShare is set when tmpDir might be on the output's filesystem
+		// after all, which leaves nothing to test.
+		mayShare bool
+	}{
+		{"injected EXDEV", func(t *testing.T) string {
+			tmpDir := t.TempDir()
+			linkFile = func(oldname, newname string) error {
+				if filepath.Dir(oldname) == tmpDir {
+					return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+				}
+				return os.Link(oldname, newname)
+			}
+			t.Cleanup(func() { linkFile = os.Link })
+			return tmpDir
+		}, false},
+		{"tmpfs", func(t *testing.T) string {
+			tmpDir, err := os.MkdirTemp("/dev/shm", "image-test-*")
+			if err != nil {
+				t.Skip("no /dev/shm:", err)
+			}
+			t.Cleanup(func() { os.RemoveAll(tmpDir) })
+			return tmpDir
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.tmpDir, config.verbose = tt.setup(t), true
+
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+			dir := t.TempDir()
+			outputFile := filepath.Join(dir, "out.png")
+			err := writeOutput(outputFile, config, func(w io.Writer) error {
+				_, err := io.WriteString(w, "copied")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(logged.String(), "copying the output") {
+				if tt.mayShare {
+					t.Skip(config.tmpDir, "is on the same filesystem as", dir)
+				}
+				t.Error("the output was not copied across filesystems")
+			}
+			if data, err := os.ReadFile(outputFile); err != nil || string(data) != "copied" {
+				t.Errorf("output = %q, %v, want the written bytes", data, err)
+			}
+			for _, d := range []string{dir, config.tmpDir} {
+				entries, err := os.ReadDir(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, entry := range entries {
+					if entry.Name() != "out.png" {
+						t.Errorf("%s left behind in %s", entry.Name(), d)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPreserveMtime(t *testing.T) {
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		output   string
+		preserve bool
+	}{
+		{"out.png", true},
+		{"out.jpg", true},
+		{"out.gif", true},
+		{"out.png", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s preserve=%v", tt.output, tt.preserve), func(t *testing.T) {
+			dir := t.TempDir()
+			inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 24, 16))
+			if err := os.Chtimes(inputFile, time.Time{}, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			config := testConfig()
+			config.preserveMtime = tt.preserve
+			outputFile, err := convertFile(inputFile, filepath.Join(dir, tt.output), false, false, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := os.Stat(outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.ModTime().Equal(mtime); got != tt.preserve {
+				t.Errorf("output mtime = %v, matching the input %v: %v, want %v", info.ModTime(), mtime, got, tt.preserve)
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagDateTimeOriginal = 0x9003
+
+	exifTagMake        = 0x010f
+	exifTagModel       = 0x0110
+	exifTagOrientation = 0x0112
+
+	exifTagThumbnailOffset = 0x0201
+	exifTagThumbnailLength = 0x0202
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// exifData is the TIFF structure stored in a JPEG APP1 "Exif" segment.
+type exifData struct {
+	raw   []byte
+	order byteOrder
+}
+
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// value holds the raw 4-byte value/offset field.
+	value []byte
+}
+
+var exifTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8,
+}
+
+func parseExif(raw []byte) (*exifData, error) {
+	if len(raw) < 8 {
+		return nil, errors.New("exif: header too short")
+	}
+
+	var order byteOrder
+	switch string(raw[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("exif: invalid byte order")
+	}
+
+	if order.Uint16(raw[2:4]) != 42 {
+		return nil, errors.New("exif: invalid TIFF magic")
+	}
+
+	return &exifData{raw: raw, order: order}, nil
+}
+
+// ifd parses the image file directory at offset and returns its entries and
+// the offset of the next directory.
+func (e *exifData) ifd(offset uint32) ([]exifEntry, uint32, error) {
+	if offset == 0 || uint64(offset)+2 > uint64(len(e.raw)) {
+		return nil, 0, fmt.Errorf("exif: IFD offset %d out of range", offset)
+	}
+
+	n := uint32(e.order.Uint16(e.raw[offset:]))
+	end := uint64(offset) + 2 + uint64(n)*12
+	if end+4 > uint64(len(e.raw)) {
+		return nil, 0, errors.New("exif: truncated IFD")
+	}
+
+	entries := make([]exifEntry, n)
+	for i := range n {
+		b := e.raw[offset+2+i*12:]
+		entries[i] = exifEntry{
+			tag:   e.order.Uint16(b[0:2]),
+			typ:   e.order.Uint16(b[2:4]),
+			count: e.order.Uint32(b[4:8]),
+			value: b[8:12],
+		}
+	}
+
+	return entries, e.order.Uint32(e.raw[end:]), nil
+}
+
+func (e *exifData) ifd0() ([]exifEntry, uint32, error) {
+	return e.ifd(e.order.Uint32(e.raw[4:8]))
+}
+
+// data returns the bytes of an entry's value, following the offset when the
+// value does not fit inline.
+func (e *exifData) data(entry exifEntry) ([]byte, error) {
+	size, ok := exifTypeSizes[entry.typ]
+	if !ok {
+		return nil, fmt.Errorf("exif: unknown type %d", entry.typ)
+	}
+
+	total := uint64(size) * uint64(entry.count)
+	if total <= 4 {
+		return entry.value[:total], nil
+	}
+
+	offset := uint64(e.order.Uint32(entry.value))
+	if offset+total > uint64(len(e.raw)) {
+		return nil, errors.New("exif: value out of range")
+	}
+
+	return e.raw[offset : offset+total], nil
+}
+
+func (e *exifData) uint32Value(entry exifEntry) (uint32, bool) {
+	switch entry.typ {
+	case 3:
+		return uint32(e.order.Uint16(entry.value)), true
+	case 4:
+		return e.order.Uint32(entry.value), true
+	default:
+		return 0, false
+	}
+}
+
+func findEntry(entries []exifEntry, tag uint16) (exifEntry, bool) {
+	for _, entry := range entries {
+		if entry.tag == tag {
+			return entry, true
+		}
+	}
+
+	return exifEntry{}, false
+}
+
+// subIFD returns the entries of the directory that the pointer tag in
+// entries refers to.
+func (e *exifData) subIFD(entries []exifEntry, tag uint16) ([]exifEntry, error) {
+	entry, ok := findEntry(entries, tag)
+	if !ok {
+		return nil, nil
+	}
+
+	offset, ok := e.uint32Value(entry)
+	if !ok {
+		return nil, fmt.Errorf("exif: invalid pointer for tag %#x", tag)
+	}
+
+	sub, _, err := e.ifd(offset)
+	return sub, err
+}
+
+func (e *exifData) stringValue(entries []exifEntry, tag uint16) (string, bool) {
+	entry, ok := findEntry(entries, tag)
+	if !ok || entry.typ != 2 {
+		return "", false
+	}
+
+	data, err := e.data(entry)
+	if err != nil {
+		return "", false
+	}
+
+	return strings.TrimRight(string(data), "\x00 "), true
+}
+
+// dateTimeOriginal returns when the picture was taken, in the EXIF
+// "YYYY:MM:DD HH:MM:SS" form.
+func (e *exifData) dateTimeOriginal() (string, bool) {
+	ifd0, _, err := e.ifd0()
+	if err != nil {
+		return "", false
+	}
+
+	exifIFD, err := e.subIFD(ifd0, exifTagExifIFD)
+	if err != nil {
+		return "", false
+	}
+
+	return e.stringValue(exifIFD, exifTagDateTimeOriginal)
+}
+
+// camera returns the make and model of the camera, joined by a space unless
+// the model already starts with the make, as many do.
+func (e *exifData) camera() (string, bool) {
+	ifd0, _, err := e.ifd0()
+	if err != nil {
+		return "", false
+	}
+
+	maker, _ := e.stringValue(ifd0, exifTagMake)
+	model, _ := e.stringValue(ifd0, exifTagModel)
+	if maker == "" || strings.HasPrefix(model, maker) {
+		return model, model != ""
+	}
+
+	return strings.TrimSpace(maker + " " + model), true
+}
+
+// orientation returns the EXIF orientation, 1 through 8, with 1 meaning the
+// pixels are stored upright.
+func (e *exifData) orientation() (int, bool) {
+	ifd0, _, err := e.ifd0()
+	if err != nil {
+		return 0, false
+	}
+
+	entry, ok := findEntry(ifd0, exifTagOrientation)
+	if !ok {
+		return 0, false
+	}
+
+	v, ok := e.uint32Value(entry)
+	if !ok || v < 1 || v > 8 {
+		return 0, false
+	}
+
+	return int(v), true
+}
+
+// withOrientation returns a copy of e whose orientation tag, a SHORT or a
+// LONG like orientation reads, is set to orientation, or e itself when it
+// has no such tag.
+func (e *exifData) withOrientation(orientation int) *exifData {
+	out := &exifData{raw: slices.Clone(e.raw), order: e.order}
+
+	ifd0, _, err := out.ifd0()
+	if err != nil {
+		return e
+	}
+
+	entry, ok := findEntry(ifd0, exifTagOrientation)
+	if !ok {
+		return e
+	}
+
+	// The value aliases the cloned bytes, so this edits them in place.
+	switch entry.typ {
+	case 3:
+		out.order.PutUint16(entry.value, uint16(orientation))
+	case 4:
+		out.order.PutUint32(entry.value, uint32(orientation))
+	default:
+		return e
+	}
+
+	return out
+}
+
+// hasGPS reports whether IFD0 points to a GPS directory.
+func (e *exifData) hasGPS() bool {
+	ifd0, _, err := e.ifd0()
+	if err != nil {
+		return false
+	}
+
+	_, ok := findEntry(ifd0, exifTagGPSIFD)
+	return ok
+}
+
+// thumbnail returns the JPEG thumbnail embedded in IFD1, the directory that
+// follows IFD0. The bytes alias e.raw.
+func (e *exifData) thumbnail() ([]byte, bool) {
+	_, next, err := e.ifd0()
+	if err != nil || next == 0 {
+		return nil, false
+	}
+
+	ifd1, _, err := e.ifd(next)
+	if err != nil {
+		return nil, false
+	}
+
+	offsetEntry, ok := findEntry(ifd1, exifTagThumbnailOffset)
+	if !ok {
+		return nil, false
+	}
+	lengthEntry, ok := findEntry(ifd1, exifTagThumbnailLength)
+	if !ok {
+		return nil, false
+	}
+
+	offset, ok := e.uint32Value(offsetEntry)
+	if !ok {
+		return nil, false
+	}
+	length, ok := e.uint32Value(lengthEntry)
+	if !ok {
+		return nil, false
+	}
+
+	end := uint64(offset) + uint64(length)
+	if length < 2 || end > uint64(len(e.raw)) {
+		return nil, false
+	}
+
+	thumb := e.raw[offset:end]
+	if thumb[0] != 0xff || thumb[1] != 0xd8 {
+		return nil, false
+	}
+
+	return thumb, true
+}
+
+// withoutGPS returns a copy of e with the GPS directory removed. The pointer
+// to it is dropped from IFD0 and the directory and its values are zeroed, so
+// no location data survives in the serialized bytes. Other offsets are
+// unaffected because the data is edited in place.
+func (e *exifData) withoutGPS() (*exifData, error) {
+	out := &exifData{raw: slices.Clone(e.raw), order: e.order}
+
+	ifd0Offset := out.order.Uint32(out.raw[4:8])
+	ifd0, next, err := out.ifd(ifd0Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	gpsEntry, ok := findEntry(ifd0, exifTagGPSIFD)
+	if !ok {
+		return out, nil
+	}
+
+	if gpsOffset, ok := out.uint32Value(gpsEntry); ok {
+		if gps, _, err := out.ifd(gpsOffset); err == nil {
+			for _, entry := range gps {
+				if data, err := out.data(entry); err == nil {
+					clear(data)
+				}
+			}
+			clear(out.raw[gpsOffset : gpsOffset+2+uint32(len(gps))*12+4])
+		}
+	}
+
+	kept := slices.DeleteFunc(ifd0, func(entry exifEntry) bool {
+		return entry.tag == exifTagGPSIFD
+	})
+
+	// Rewrite IFD0 with one entry less. Entries are copied out first because
+	// their value slices alias the directory being overwritten.
+	encoded := make([]byte, 0, 2+len(kept)*12+4)
+	encoded = out.order.AppendUint16(encoded, uint16(len(kept)))
+	for _, entry := range kept {
+		encoded = out.order.AppendUint16(encoded, entry.tag)
+		encoded = out.order.AppendUint16(encoded, entry.typ)
+		encoded = out.order.AppendUint32(encoded, entry.count)
+		encoded = append(encoded, entry.value...)
+	}
+	encoded = out.order.AppendUint32(encoded, next)
+
+	region := out.raw[ifd0Offset : ifd0Offset+2+uint32(len(kept)+1)*12+4]
+	clear(region)
+	copy(region, encoded)
+
+	return out, nil
+}
+
+// readExif returns the EXIF payload of a JPEG file, or nil if it has none.
+func readExif(inputFile string) ([]byte, error) {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return jpegExif(bufio.NewReader(f))
+}
+
+// jpegExif scans the JPEG markers preceding the image data for an APP1
+// segment holding EXIF data.
+func jpegExif(r io.Reader) ([]byte, error) {
+	return jpegSegment(r, 0xe1, exifHeader)
+}
+
+// jpegSegment returns the payload after prefix of the first segment with the
+// given marker and prefix that precedes the image data, or nil if there is
+// none.
+func jpegSegment(r io.Reader, segmentMarker byte, prefix []byte) ([]byte, error) {
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil {
+		return nil, err
+	}
+
+	if marker != [2]byte{0xff, 0xd8} {
+		return nil, nil
+	}
+
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, err
+		}
+
+		if marker[0] != 0xff {
+			return nil, errors.New("jpeg: invalid marker")
+		}
+
+		// Start of scan and end of image: no more metadata segments follow.
+		if marker[1] == 0xda || marker[1] == 0xd9 {
+			return nil, nil
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+
+		if length < 2 {
+			return nil, errors.New("jpeg: invalid segment length")
+		}
+
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+
+		if marker[1] == segmentMarker && bytes.HasPrefix(segment, prefix) {
+			return segment[len(prefix):], nil
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testTag is an EXIF entry for testExif. Values longer than four bytes are
+// stored after their directory.
+type testTag struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func shortTag(tag uint16, v uint16) testTag {
+	return testTag{tag, 3, 1, binary.LittleEndian.AppendUint16(nil, v)}
+}
+
+func longTag(tag uint16, v uint32) testTag {
+	return testTag{tag, 4, 1, binary.LittleEndian.AppendUint32(nil, v)}
+}
+
+func asciiTag(tag uint16, s string) testTag {
+	return testTag{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+// exifBuilder lays out a little-endian TIFF structure one directory at a
+// time, so pointers to directories written earlier can go in later ones.
+type exifBuilder struct {
+	buf []byte
+}
+
+func newExifBuilder() *exifBuilder {
+	return &exifBuilder{buf: []byte("II*\x00\x00\x00\x00\x00")}
+}
+
+// ifd appends a directory holding entries, followed by their out of line
+// values, and returns its offset.
+func (b *exifBuilder) ifd(entries []testTag, next uint32) uint32 {
+	offset := uint32(len(b.buf))
+	dataOffset := offset + 2 + uint32(len(entries))*12 + 4
+
+	var data []byte
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(len(entries)))
+	for _, entry := range entries {
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, entry.tag)
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, entry.typ)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, entry.count)
+		if len(entry.data) <= 4 {
+			b.buf = append(b.buf, entry.data...)
+			b.buf = append(b.buf, make([]byte, 4-len(entry.data))...)
+			continue
+		}
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, dataOffset+uint32(len(data)))
+		data = append(data, entry.data...)
+	}
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, next)
+	b.buf = append(b.buf, data...)
+
+	return offset
+}
+
+// blob appends raw bytes, such as a thumbnail, and returns their offset.
+func (b *exifBuilder) blob(data []byte) uint32 {
+	offset := uint32(len(b.buf))
+	b.buf = append(b.buf, data...)
+	return offset
+}
+
+// finish points the header at ifd0 and returns the structure.
+func (b *exifBuilder) finish(ifd0 uint32) []byte {
+	binary.LittleEndian.PutUint32(b.buf[4:8], ifd0)
+	return b.buf
+}
+
+// writeJPEGWithExif encodes img into dir/name with exif in an APP1 segment.
+func writeJPEGWithExif(t *testing.T, dir string, name string, img image.Image, exif []byte) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := append(append([]byte{}, exifHeader...), exif...)
+	segment := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(payload)+2))
+	data := append(append(append([]byte{0xff, 0xd8}, segment...), payload...), buf.Bytes()[2:]...)
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// outputExif parses the EXIF data of the JPEG file at path.
+func outputExif(t *testing.T, path string) *exifData {
+	t.Helper()
+
+	raw, err := readExif(path)
+	if err != nil || raw == nil {
+		t.Fatalf("reading the EXIF data of %s: %v", path, err)
+	}
+	exif, err := parseExif(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return exif
+}
+
+func TestAutoOrientResetsOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  testTag
+	}{
+		{"short", shortTag(exifTagOrientation, 6)},
+		{"long", longTag(exifTagOrientation, 6)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			b := newExifBuilder()
+			exif := b.finish(b.ifd([]testTag{tt.tag}, 0))
+			inputFile := writeJPEGWithExif(t, dir, "in.jpg", testJPEG(t, 40, 20), exif)
+
+			config := testConfig()
+			config.autoOrient, config.keepMetadata = true, true
+			outputFile := filepath.Join(dir, "out.jpg")
+			if err := convertImage(inputFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			if orientation, ok := outputExif(t, outputFile).orientation(); !ok || orientation != 1 {
+				t.Errorf("orientation after --auto-orient = %d (%v), want 1", orientation, ok)
+			}
+
+			bounds, err := jpegBounds(outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bounds.Dx() != 20 || bounds.Dy() != 40 {
+				t.Errorf("output is %dx%d, want the 40x20 input turned to 20x40", bounds.Dx(), bounds.Dy())
+			}
+		})
+	}
+}
+
+func TestStripGPSKeepsOtherTags(t *testing.T) {
+	const taken = "2024:05:06 07:08:09"
+
+	b := newExifBuilder()
+	gps := b.ifd([]testTag{asciiTag(0x0001, "N"), asciiTag(0x0003, "E")}, 0)
+	exifIFD := b.ifd([]testTag{asciiTag(exifTagDateTimeOriginal, taken)}, 0)
+	exif := b.finish(b.ifd([]testTag{
+		asciiTag(exifTagMake, "Gopher"),
+		shortTag(exifTagOrientation, 3),
+		longTag(exifTagExifIFD, exifIFD),
+		longTag(exifTagGPSIFD, gps),
+	}, 0))
+
+	dir := t.TempDir()
+	inputFile := writeJPEGWithExif(t, dir, "in.jpg", testJPEG(t, 16, 16), exif)
+	if parsed, err := parseExif(exif); err != nil || !parsed.hasGPS() {
+		t.Fatalf("test EXIF data has no GPS IFD: %v", err)
+	}
+
+	config := testConfig()
+	config.keepMetadata, config.stripGPS = true, true
+	outputFile := filepath.Join(dir, "out.jpg")
+	if err := convertImage(inputFile, outputFile, config); err != nil {
+		t.Fatal(err)
+	}
+
+	out := outputExif(t, outputFile)
+	if out.hasGPS() {
+		t.Error("output still has a GPS IFD")
+	}
+	if orientation, ok := out.orientation(); !ok || orientation != 3 {
+		t.Errorf("orientation = %d (%v), want 3 kept", orientation, ok)
+	}
+	if got, ok := out.dateTimeOriginal(); !ok || got != taken {
+		t.Errorf("DateTimeOriginal = %q (%v), want %q kept", got, ok, taken)
+	}
+	if camera, ok := out.camera(); !ok || camera != "Gopher" {
+		t.Errorf("camera = %q (%v), want Gopher kept", camera, ok)
+	}
+}
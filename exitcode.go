@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Exit codes, so scripts can tell failure modes apart. Anything that doesn't
+// fall into one of these classes exits with 1, like log.Fatalln.
+const (
+	exitUsage         = 2
+	exitInputNotFound = 3
+	exitUnsupported   = 4
+	exitOutputExists  = 5
+	exitIO            = 6
+	exitInterrupted   = 130
+)
+
+const exitCodesHelp = `
+Exit codes:
+  1    any other failure
+  2    invalid flags or arguments
+  3    an input file does not exist
+  4    unsupported format or conversion
+  5    the output file already exists
+  6    encoding or writing the output failed
+  130  interrupted
+`
+
+// errUnsupported is wrapped by every error about a format or conversion this
+// build cannot handle.
+var errUnsupported = errors.New("unsupported")
+
+// outputError marks a failure to encode or write the output file.
+type outputError struct {
+	err error
+}
+
+func (e *outputError) Error() string { return e.err.Error() }
+
+func (e *outputError) Unwrap() error { return e.err }
+
+// exitCode maps err to the exit code of its failure class.
+func exitCode(err error) int {
+	var outErr *outputError
+	var pathErr *fs.PathError
+
+	switch {
+	case errors.Is(err, errUnsupported):
+		return exitUnsupported
+	case errors.Is(err, fs.ErrExist):
+		return exitOutputExists
+	case errors.As(err, &outErr):
+		return exitIO
+	case errors.Is(err, fs.ErrNotExist):
+		return exitInputNotFound
+	case errors.As(err, &pathErr):
+		return exitIO
+	default:
+		return 1
+	}
+}
+
+// fatal logs err and exits with the code of its failure class.
+func fatal(err error) {
+	log.Println(err)
+	removeTemps()
+	os.Exit(exitCode(err))
+}
+
+// fatalUsage logs v like log.Fatalln, but exits with exitUsage.
+func fatalUsage(v ...any) {
+	log.Println(v...)
+	removeTemps()
+	os.Exit(exitUsage)
+}
+
+// tempDirs are removed by fatal and fatalUsage, since exiting skips the
+// deferred calls that otherwise remove them.
+var tempDirs []string
+
+// tempFiles holds the temporary outputs that are still being written, for
+// the same reason. Batch jobs create them concurrently.
+var tempFiles = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+// removeTemps removes tempDirs and tempFiles.
+func removeTemps() {
+	for _, dir := range tempDirs {
+		os.RemoveAll(dir)
+	}
+
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	for name := range tempFiles.names {
+		os.Remove(name)
+	}
+}
+
+// handleInterrupts exits with exitInterrupted on SIGINT or SIGTERM, after
+// removing the temporary files, so an interrupted conversion leaves the
+// output as it was.
+func handleInterrupts() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Println(sig)
+		removeTemps()
+		os.Exit(exitInterrupted)
+	}()
+}
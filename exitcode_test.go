@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs the command itself instead of the tests when
+// IMAGE_TEST_MAIN is set, so tests can check how it exits.
+func TestMain(m *testing.M) {
+	if os.Getenv("IMAGE_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// runCommand runs the command with args in dir and returns its exit code and
+// output.
+func runCommand(t *testing.T, dir string, args ...string) (int, string) {
+	t.Helper()
+
+	code, stdout, stderr := pipeCommand(t, dir, nil, args...)
+	return code, string(stdout) + stderr
+}
+
+// pipeCommand runs the command with args in dir, feeding it stdin, and
+// returns its exit code, standard output and standard error.
+func pipeCommand(t *testing.T, dir string, stdin []byte, args ...string) (int, []byte, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "IMAGE_TEST_MAIN=1")
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			t.Fatal(err)
+		}
+	}
+
+	return cmd.ProcessState.ExitCode(), stdout.Bytes(), stderr.String()
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 16, 16))
+	writeTestImage(t, filepath.Join(dir, "taken.jpg"), testJPEG(t, 16, 16))
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"success", []string{"in.png", "out.jpg"}, 0},
+		{"missing input", []string{"missing.png", "out2.jpg"}, exitInputNotFound},
+		{"existing output", []string{"in.png", "taken.jpg"}, exitOutputExists},
+		{"unknown flag", []string{"--bogus", "in.png", "out3.jpg"}, exitUsage},
+		{"invalid flag value", []string{"--padding", "ten", "in.png", "out4.jpg"}, exitUsage},
+		{"missing arguments", []string{"in.png"}, exitUsage},
+		{"unsupported output", []string{"in.png", "out.xyz"}, exitUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, out := runCommand(t, dir, tt.args...); code != tt.want {
+				t.Errorf("image %v exited with %d, want %d; output:\n%s", tt.args, code, tt.want, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var tintModes = []string{"all", "midtones"}
+
+func parseTintMode(mode string) (string, error) {
+	for _, m := range tintModes {
+		if mode == m {
+			return mode, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid tint mode %q: expected one of %v", mode, tintModes)
+}
+
+var grayscaleMethods = []string{"luminosity", "average", "lightness"}
+
+func parseGrayscaleMethod(method string) (string, error) {
+	for _, m := range grayscaleMethods {
+		if method == m {
+			return method, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid grayscale method %q: expected one of %v", method, grayscaleMethods)
+}
+
+// toNRGBA returns a copy of img with straight alpha, which is what the
+// per-pixel filters operate on.
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	destImg := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(destImg, destImg.Bounds(), img, bounds.Min, draw.Src)
+
+	return destImg
+}
+
+func grayscale(img *image.NRGBA, method string) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b := int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2])
+
+		var y int
+		switch method {
+		case "average":
+			y = (r + g + b + 1) / 3
+		case "lightness":
+			y = (max(r, g, b) + min(r, g, b) + 1) / 2
+		default:
+			y = (299*r + 587*g + 114*b + 500) / 1000
+		}
+
+		img.Pix[i] = uint8(y)
+		img.Pix[i+1] = uint8(y)
+		img.Pix[i+2] = uint8(y)
+	}
+}
+
+// chromaKey makes pixels within tolerance of key (Euclidean distance in RGB)
+// fully transparent. Pixels in the next feather units of distance fade from
+// transparent to their original opacity, softening the cut-out edges.
+func chromaKey(img *image.NRGBA, key color.Color, tolerance float64, feather float64) {
+	k := color.NRGBAModel.Convert(key).(color.NRGBA)
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		dr := float64(img.Pix[i]) - float64(k.R)
+		dg := float64(img.Pix[i+1]) - float64(k.G)
+		db := float64(img.Pix[i+2]) - float64(k.B)
+		dist := math.Sqrt(dr*dr + dg*dg + db*db)
+
+		switch {
+		case dist <= tolerance:
+			img.Pix[i+3] = 0
+		case dist < tolerance+feather:
+			keep := (dist - tolerance) / feather
+			img.Pix[i+3] = uint8(float64(img.Pix[i+3])*keep + 0.5)
+		}
+	}
+}
+
+// posterize reduces each color channel to the given number of evenly spaced
+// levels.
+func posterize(img *image.NRGBA, levels int) {
+	var lut [256]uint8
+	steps := float64(levels - 1)
+	for v := range lut {
+		lut[v] = uint8(math.Round(math.Round(float64(v)*steps/255) * 255 / steps))
+	}
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i] = lut[img.Pix[i]]
+		img.Pix[i+1] = lut[img.Pix[i+1]]
+		img.Pix[i+2] = lut[img.Pix[i+2]]
+	}
+}
+
+// vignette darkens img towards its edges. Brightness falls off with the
+// square of the distance from the center, normalized so the corners are
+// darkened by strength; the center pixel is left unchanged.
+func vignette(img *image.NRGBA, strength float64) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	cx, cy := float64(width)/2, float64(height)/2
+	maxDist := cx*cx + cy*cy
+
+	for y := range height {
+		dy := float64(y) + 0.5 - cy
+		for x := range width {
+			dx := float64(x) + 0.5 - cx
+			factor := 1 - strength*(dx*dx+dy*dy)/maxDist
+
+			i := img.PixOffset(x, y)
+			img.Pix[i] = uint8(float64(img.Pix[i])*factor + 0.5)
+			img.Pix[i+1] = uint8(float64(img.Pix[i+1])*factor + 0.5)
+			img.Pix[i+2] = uint8(float64(img.Pix[i+2])*factor + 0.5)
+		}
+	}
+}
+
+// parseRegion parses an "X,Y,WIDTH,HEIGHT" rectangle.
+func parseRegion(regionStr string) (image.Rectangle, error) {
+	fields := strings.Split(regionStr, ",")
+	if len(fields) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid region %q: expected X,Y,WIDTH,HEIGHT", regionStr)
+	}
+
+	values := make([]int, 4)
+	for i, field := range fields {
+		value, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("invalid region %q: %q is not an integer", regionStr, field)
+		}
+		values[i] = value
+	}
+
+	if values[2] <= 0 || values[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("invalid region %q: width and height must be positive", regionStr)
+	}
+
+	return image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3]), nil
+}
+
+// pixelate replaces every block x block square of img within region with its
+// average color. Blocks start at the region's top-left corner; those cut off
+// by the region's far edges are averaged over the part inside it. Colors are
+// weighted by alpha so transparent pixels don't darken their block.
+func pixelate(img *image.NRGBA, block int, region image.Rectangle) {
+	region = region.Intersect(img.Rect)
+
+	for by := region.Min.Y; by < region.Max.Y; by += block {
+		for bx := region.Min.X; bx < region.Max.X; bx += block {
+			rect := image.Rect(bx, by, bx+block, by+block).Intersect(region)
+
+			var r, g, b, a, n int
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					i := img.PixOffset(x, y)
+					alpha := int(img.Pix[i+3])
+					r += int(img.Pix[i]) * alpha
+					g += int(img.Pix[i+1]) * alpha
+					b += int(img.Pix[i+2]) * alpha
+					a += alpha
+					n++
+				}
+			}
+
+			avg := [4]uint8{0, 0, 0, uint8((a + n/2) / n)}
+			if a > 0 {
+				avg[0] = uint8((r + a/2) / a)
+				avg[1] = uint8((g + a/2) / a)
+				avg[2] = uint8((b + a/2) / a)
+			}
+
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					copy(img.Pix[img.PixOffset(x, y):], avg[:])
+				}
+			}
+		}
+	}
+}
+
+// luma is the Rec. 601 luminance of an 8-bit color, rounded.
+func luma(r, g, b uint8) uint8 {
+	return uint8((299*int(r) + 587*int(g) + 114*int(b) + 500) / 1000)
+}
+
+// parseThreshold parses --threshold as a luminance from 0 to 255 or "auto".
+func parseThreshold(thresholdStr string) (int, bool, error) {
+	if thresholdStr == "auto" {
+		return 0, true, nil
+	}
+
+	threshold, err := strconv.Atoi(thresholdStr)
+	if err != nil || threshold < 0 || threshold > 255 {
+		return 0, false, fmt.Errorf("invalid threshold %q: expected 0 to 255 or auto", thresholdStr)
+	}
+
+	return threshold, false, nil
+}
+
+// otsuThreshold picks the luminance threshold that best separates the
+// histogram into two classes, by maximizing the variance between them.
+func otsuThreshold(counts [256]int) int {
+	var total, sum float64
+	for v, n := range counts {
+		total += float64(n)
+		sum += float64(v * n)
+	}
+
+	var best, bestVariance, below, belowSum float64
+	for v, n := range counts {
+		below += float64(n)
+		if below == 0 {
+			continue
+		}
+
+		above := total - below
+		if above == 0 {
+			break
+		}
+
+		belowSum += float64(v * n)
+		meanBelow := belowSum / below
+		meanAbove := (sum - belowSum) / above
+
+		if variance := below * above * (meanBelow - meanAbove) * (meanBelow - meanAbove); variance > bestVariance {
+			bestVariance = variance
+			best = float64(v)
+		}
+	}
+
+	// Values up to and including best form the dark class.
+	return int(best) + 1
+}
+
+// threshold turns every pixel of img black or white depending on whether its
+// luminance is below threshold. Alpha is kept.
+func threshold(img *image.NRGBA, threshold int) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		v := uint8(0)
+		if int(luma(img.Pix[i], img.Pix[i+1], img.Pix[i+2])) >= threshold {
+			v = 255
+		}
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = v, v, v
+	}
+}
+
+// fade blends the colors of img towards bg, keeping opacity of the original
+// and 1-opacity of bg. Unlike lowering alpha, the result stays opaque
+// wherever the source was.
+func fade(img *image.NRGBA, bg color.Color, opacity float64) {
+	c := color.NRGBAModel.Convert(bg).(color.NRGBA)
+	target := [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		for ch := range 3 {
+			v := float64(img.Pix[i+ch])*opacity + target[ch]*(1-opacity)
+			img.Pix[i+ch] = uint8(v + 0.5)
+		}
+	}
+}
+
+// tint blends the colors of img towards c by amount. In midtones mode the
+// blend is weighted by 4*l*(1-l) of each pixel's luminance l, full strength
+// at mid gray and none at black and white, so after --grayscale shadows and
+// highlights stay neutral for a duotone look.
+func tint(img *image.NRGBA, c color.Color, amount float64, mode string) {
+	t := color.NRGBAModel.Convert(c).(color.NRGBA)
+	target := [3]float64{float64(t.R), float64(t.G), float64(t.B)}
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		weight := amount
+		if mode == "midtones" {
+			l := float64(luma(img.Pix[i], img.Pix[i+1], img.Pix[i+2])) / 255
+			weight *= 4 * l * (1 - l)
+		}
+
+		for ch := range 3 {
+			v := float64(img.Pix[i+ch])*(1-weight) + target[ch]*weight
+			img.Pix[i+ch] = uint8(v + 0.5)
+		}
+	}
+}
+
+// duotone maps the luminance of every pixel onto the gradient from shadow to
+// highlight through a 256-entry lookup table, so black becomes shadow and
+// white becomes highlight.
+func duotone(img *image.NRGBA, shadow color.Color, highlight color.Color) {
+	from := color.NRGBAModel.Convert(shadow).(color.NRGBA)
+	to := color.NRGBAModel.Convert(highlight).(color.NRGBA)
+
+	var lut [256][3]uint8
+	for l := range lut {
+		t := float64(l) / 255
+		lerp := func(a, b uint8) uint8 {
+			return uint8(float64(a) + (float64(b)-float64(a))*t + 0.5)
+		}
+		lut[l] = [3]uint8{lerp(from.R, to.R), lerp(from.G, to.G), lerp(from.B, to.B)}
+	}
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := lut[luma(img.Pix[i], img.Pix[i+1], img.Pix[i+2])]
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = c[0], c[1], c[2]
+	}
+}
+
+// parseDuotone parses a --duotone value of two colors joined by a dash, the
+// shadow color first.
+func parseDuotone(duotoneStr string) (color.Color, color.Color, error) {
+	shadowStr, highlightStr, ok := strings.Cut(duotoneStr, "-")
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid duotone %q: expected SHADOW-HIGHLIGHT colors", duotoneStr)
+	}
+
+	shadow, err := parseBackgroundColor(shadowStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse duotone shadow color: %w", err)
+	}
+
+	highlight, err := parseBackgroundColor(highlightStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse duotone highlight color: %w", err)
+	}
+
+	return shadow, highlight, nil
+}
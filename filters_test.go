@@ -0,0 +1,356 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"testing"
+)
+
+// onePixel returns a 1x1 image of c.
+func onePixel(c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, c)
+	return img
+}
+
+func TestGrayscaleMethods(t *testing.T) {
+	// Pure red: the methods weigh its single channel very differently.
+	red := color.NRGBA{R: 255, A: 200}
+
+	tests := []struct {
+		method string
+		want   uint8
+	}{
+		{"luminosity", 76}, // 0.299 * 255
+		{"average", 85},    // 255 / 3
+		{"lightness", 128}, // (255 + 0) / 2
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			img := onePixel(red)
+			grayscale(img, tt.method)
+
+			want := color.NRGBA{R: tt.want, G: tt.want, B: tt.want, A: red.A}
+			if got := img.NRGBAAt(0, 0); got != want {
+				t.Errorf("grayscale(%s) = %v, want %v", tt.method, got, want)
+			}
+		})
+	}
+}
+
+func TestChromaKey(t *testing.T) {
+	green := color.RGBA{G: 255, A: 255}
+
+	tests := []struct {
+		name      string
+		pixel     color.NRGBA
+		wantAlpha uint8
+	}{
+		{"the key", color.NRGBA{G: 255, A: 255}, 0},
+		{"within tolerance", color.NRGBA{R: 10, G: 240, B: 10, A: 255}, 0},
+		// 40 away from the key, halfway through the 30 to 50 feather.
+		{"in the feather", color.NRGBA{G: 215, A: 255}, 128},
+		{"faint in the feather", color.NRGBA{G: 215, A: 100}, 50},
+		{"beyond the feather", color.NRGBA{R: 200, G: 180, B: 60, A: 255}, 255},
+		{"red subject", color.NRGBA{R: 220, G: 30, B: 30, A: 255}, 255},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := onePixel(tt.pixel)
+			chromaKey(img, green, 30, 20)
+
+			got := img.NRGBAAt(0, 0)
+			if got.A != tt.wantAlpha {
+				t.Errorf("alpha of %v = %d, want %d", tt.pixel, got.A, tt.wantAlpha)
+			}
+			if got.R != tt.pixel.R || got.G != tt.pixel.G || got.B != tt.pixel.B {
+				t.Errorf("chroma key changed the color of %v to %v", tt.pixel, got)
+			}
+		})
+	}
+}
+
+func TestPosterizeLevels(t *testing.T) {
+	// A gradient running through every value on each channel, in a
+	// different direction per channel.
+	gradient := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := range 256 {
+		gradient.SetNRGBA(x, 0, color.NRGBA{R: uint8(x), G: uint8(255 - x), B: uint8(x * 7), A: 255})
+	}
+
+	tests := []struct {
+		levels int
+		want   []uint8
+	}{
+		{2, []uint8{0, 255}},
+		{3, []uint8{0, 128, 255}},
+		{4, []uint8{0, 85, 170, 255}},
+		{256, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.levels), func(t *testing.T) {
+			img := toNRGBA(gradient)
+			posterize(img, tt.levels)
+
+			for ch, name := range []string{"red", "green", "blue"} {
+				seen := map[uint8]bool{}
+				for i := ch; i < len(img.Pix); i += 4 {
+					seen[img.Pix[i]] = true
+				}
+
+				if len(seen) != tt.levels {
+					t.Errorf("%s has %d distinct values, want %d", name, len(seen), tt.levels)
+				}
+				for _, v := range tt.want {
+					if !seen[v] {
+						t.Errorf("%s is missing level %d", name, v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVignette(t *testing.T) {
+	gray := color.NRGBA{R: 200, G: 200, B: 200, A: 255}
+
+	tests := []struct {
+		strength   float64
+		wantCorner uint8
+	}{
+		{0, 200},
+		// The corner pixel centers are 32/40.5 of the way out.
+		{0.5, 121},
+		{1, 42},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.FormatFloat(tt.strength, 'g', -1, 64), func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, 9, 9))
+			for i := 0; i < len(img.Pix); i += 4 {
+				copy(img.Pix[i:], []uint8{gray.R, gray.G, gray.B, gray.A})
+			}
+			vignette(img, tt.strength)
+
+			if got := img.NRGBAAt(4, 4); got != gray {
+				t.Errorf("center = %v, want %v unchanged", got, gray)
+			}
+			for _, p := range []image.Point{{0, 0}, {8, 0}, {0, 8}, {8, 8}} {
+				want := color.NRGBA{R: tt.wantCorner, G: tt.wantCorner, B: tt.wantCorner, A: 255}
+				if got := img.NRGBAAt(p.X, p.Y); got != want {
+					t.Errorf("corner %v = %v, want %v", p, got, want)
+				}
+			}
+			if edge, corner := img.NRGBAAt(4, 0).R, img.NRGBAAt(0, 0).R; edge < corner {
+				t.Errorf("edge midpoint %d is darker than the corner %d", edge, corner)
+			}
+		})
+	}
+}
+
+func TestPixelateAveragesBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		pixels []color.NRGBA
+		want   color.NRGBA
+	}{
+		{
+			"opaque",
+			[]color.NRGBA{{R: 0, A: 255}, {R: 100, A: 255}, {R: 200, A: 255}, {R: 60, G: 40, A: 255}},
+			color.NRGBA{R: 90, G: 10, A: 255},
+		},
+		{
+			// The transparent black pixel only lowers the alpha.
+			"transparent pixel",
+			[]color.NRGBA{{R: 200, A: 255}, {R: 100, A: 255}, {}, {R: 60, B: 30, A: 255}},
+			color.NRGBA{R: 120, B: 10, A: 191},
+		},
+		{
+			"alpha weighted",
+			[]color.NRGBA{{R: 200, A: 200}, {G: 200, A: 100}, {B: 100, A: 50}, {R: 50, A: 50}},
+			color.NRGBA{R: 106, G: 50, B: 13, A: 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+			for i, c := range tt.pixels {
+				img.SetNRGBA(i%2, i/2, c)
+			}
+			pixelate(img, 2, img.Rect)
+
+			for i := range tt.pixels {
+				if got := img.NRGBAAt(i%2, i/2); got != tt.want {
+					t.Errorf("pixel (%d,%d) = %v, want %v", i%2, i/2, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPixelateRegionEdges(t *testing.T) {
+	// A 5 pixel wide ramp in blocks of 2 from x=0: the last block is cut
+	// off by the region and averages only its single pixel.
+	img := image.NewNRGBA(image.Rect(0, 0, 6, 1))
+	for x := range 6 {
+		img.SetNRGBA(x, 0, color.NRGBA{R: uint8(x * 40), A: 255})
+	}
+	pixelate(img, 2, image.Rect(0, 0, 5, 1))
+
+	want := []uint8{20, 20, 100, 100, 160, 200}
+	for x, w := range want {
+		if got := img.NRGBAAt(x, 0).R; got != w {
+			t.Errorf("red at x=%d = %d, want %d", x, got, w)
+		}
+	}
+}
+
+func TestThresholdModes(t *testing.T) {
+	// A gray ramp whose luminance is x.
+	ramp := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := range 256 {
+		ramp.SetNRGBA(x, 0, color.NRGBA{R: uint8(x), G: uint8(x), B: uint8(x), A: 255})
+	}
+
+	tests := []struct {
+		thresholdStr string
+		want         int
+	}{
+		{"0", 0},
+		{"64", 64},
+		{"200", 200},
+		{"255", 255},
+		// An even ramp splits down the middle.
+		{"auto", 128},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.thresholdStr, func(t *testing.T) {
+			cutoff, auto, err := parseThreshold(tt.thresholdStr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img := toNRGBA(ramp)
+			if auto {
+				cutoff = otsuThreshold(computeHistogram(img).luma)
+			}
+			if cutoff != tt.want {
+				t.Fatalf("threshold for %q = %d, want %d", tt.thresholdStr, cutoff, tt.want)
+			}
+			threshold(img, cutoff)
+
+			for x := range 256 {
+				want := color.NRGBA{A: 255}
+				if x >= tt.want {
+					want = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+				}
+				if got := img.NRGBAAt(x, 0); got != want {
+					t.Errorf("pixel of luminance %d = %v, want %v", x, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOtsuThresholdSplitsClusters(t *testing.T) {
+	// Two ramps, one dark and one light, with nothing in between.
+	var counts [256]int
+	for v := 40; v <= 60; v++ {
+		counts[v] = 10
+	}
+	for v := 180; v <= 220; v++ {
+		counts[v] = 5
+	}
+
+	if got := otsuThreshold(counts); got <= 60 || got > 180 {
+		t.Errorf("otsuThreshold of clusters at 40-60 and 180-220 = %d, want between them", got)
+	}
+}
+
+func TestFadeBlendsTowardsBackground(t *testing.T) {
+	pixel := color.NRGBA{R: 200, G: 100, B: 0, A: 255}
+	bg := color.RGBA{R: 0, G: 0, B: 100, A: 255}
+
+	tests := []struct {
+		opacity float64
+		want    color.NRGBA
+	}{
+		{1, pixel},
+		{0.5, color.NRGBA{R: 100, G: 50, B: 50, A: 255}},
+		{0.25, color.NRGBA{R: 50, G: 25, B: 75, A: 255}},
+		{0, color.NRGBA{B: 100, A: 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.FormatFloat(tt.opacity, 'g', -1, 64), func(t *testing.T) {
+			img := onePixel(pixel)
+			fade(img, bg, tt.opacity)
+
+			if got := img.NRGBAAt(0, 0); got != tt.want {
+				t.Errorf("fade(%v, %v) = %v, want %v", pixel, tt.opacity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTintModes(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+
+	tests := []struct {
+		name  string
+		mode  string
+		pixel color.NRGBA
+		want  color.NRGBA
+	}{
+		{"all on gray", "all", color.NRGBA{R: 128, G: 128, B: 128, A: 255}, color.NRGBA{R: 192, G: 64, B: 64, A: 255}},
+		{"all on black", "all", color.NRGBA{A: 255}, color.NRGBA{R: 128, A: 255}},
+		// Luminance 64 weighs the tint by 4 * 0.25 * 0.75.
+		{"midtones on dark gray", "midtones", color.NRGBA{R: 64, G: 64, B: 64, A: 80}, color.NRGBA{R: 136, G: 40, B: 40, A: 80}},
+		{"midtones on black", "midtones", color.NRGBA{A: 255}, color.NRGBA{A: 255}},
+		{"midtones on white", "midtones", color.NRGBA{R: 255, G: 255, B: 255, A: 255}, color.NRGBA{R: 255, G: 255, B: 255, A: 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := onePixel(tt.pixel)
+			tint(img, red, 0.5, tt.mode)
+
+			if got := img.NRGBAAt(0, 0); got != tt.want {
+				t.Errorf("tint(%v, %s) = %v, want %v", tt.pixel, tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuotoneEndpoints(t *testing.T) {
+	shadow := color.RGBA{R: 0x20, G: 0x20, B: 0x80, A: 255}
+	highlight := color.RGBA{R: 0xff, G: 0xd0, B: 0xa0, A: 255}
+
+	tests := []struct {
+		name  string
+		pixel color.NRGBA
+		want  color.NRGBA
+	}{
+		{"black", color.NRGBA{A: 255}, color.NRGBA{R: 0x20, G: 0x20, B: 0x80, A: 255}},
+		{"white", color.NRGBA{R: 255, G: 255, B: 255, A: 255}, color.NRGBA{R: 0xff, G: 0xd0, B: 0xa0, A: 255}},
+		{"faint white", color.NRGBA{R: 255, G: 255, B: 255, A: 40}, color.NRGBA{R: 0xff, G: 0xd0, B: 0xa0, A: 40}},
+		// Pure green has luminance 150, 150/255 of the way along.
+		{"green", color.NRGBA{G: 255, A: 255}, color.NRGBA{R: 163, G: 136, B: 147, A: 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := onePixel(tt.pixel)
+			duotone(img, shadow, highlight)
+
+			if got := img.NRGBAAt(0, 0); got != tt.want {
+				t.Errorf("duotone(%v) = %v, want %v", tt.pixel, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math"
+)
+
+const (
+	// fitMinQuality is the lowest JPEG quality --scale-to-fit-bytes drops to
+	// before it starts shrinking the image instead.
+	fitMinQuality = 40
+
+	// fitMinSide is the smallest width or height it shrinks the image to.
+	fitMinSide = 16
+)
+
+// withByteBudget wraps the JPEG encoder so that its output fits in budget
+// bytes. It looks for the highest quality between the configured one and
+// fitMinQuality that fits, and only when even fitMinQuality doesn't, shrinks
+// the image and searches again. The quality and size it settles on are
+// logged.
+func withByteBudget(encode encodeFunc, budget int64) encodeFunc {
+	return func(w io.Writer, img image.Image, config *Config) error {
+		maxQuality := config.qualityFor("jpeg")
+		if config.autoQuality {
+			maxQuality = autoJPEGQuality(img)
+		}
+		minQuality := min(fitMinQuality, maxQuality)
+
+		bounds := img.Bounds()
+		scale := 1.0
+		for {
+			size := scaleSize(Size{width: bounds.Dx(), height: bounds.Dy()}, scale)
+			scaled := resizeImage(img, size)
+
+			buf, quality, smallest, err := fitQuality(encode, scaled, minQuality, maxQuality, budget, config)
+			if err != nil {
+				return err
+			}
+			if buf != nil {
+				log.Printf("fit to %d KB: quality %d, %dx%d, %d KB", budget/1024, quality, size.width, size.height, (buf.Len()+1023)/1024)
+				_, err := w.Write(buf.Bytes())
+				return err
+			}
+
+			if min(size.width, size.height) <= fitMinSide {
+				return fmt.Errorf("cannot fit output in %d KB: still %d KB at quality %d and %dx%d", budget/1024, (smallest+1023)/1024, minQuality, size.width, size.height)
+			}
+
+			// Encoded size grows roughly with the pixel count, so the next
+			// scale aims just under the budget, shrinking by 5% to 50%.
+			scale *= max(0.5, min(0.95, 0.95*math.Sqrt(float64(budget)/float64(smallest))))
+			minSide := float64(fitMinSide) / float64(min(bounds.Dx(), bounds.Dy()))
+			scale = max(scale, minSide)
+		}
+	}
+}
+
+// fitQuality binary searches for the highest quality from minQuality to
+// maxQuality whose encoding of img fits in budget, returning that encoding
+// and quality. When none fits, the returned buffer is nil and smallest is the
+// size at minQuality.
+func fitQuality(encode encodeFunc, img image.Image, minQuality int, maxQuality int, budget int64, config *Config) (*bytes.Buffer, int, int, error) {
+	attempt := *config
+	attempt.autoQuality = false
+
+	encodeAt := func(quality int) (*bytes.Buffer, error) {
+		attempt.quality = quality
+		var buf bytes.Buffer
+		err := encode(&buf, img, &attempt)
+		return &buf, err
+	}
+
+	best, err := encodeAt(minQuality)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if int64(best.Len()) > budget {
+		return nil, 0, best.Len(), nil
+	}
+
+	bestQuality := minQuality
+	low, high := minQuality+1, maxQuality
+	for low <= high {
+		quality := (low + high) / 2
+		buf, err := encodeAt(quality)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		if int64(buf.Len()) <= budget {
+			best, bestQuality = buf, quality
+			low = quality + 1
+		} else {
+			high = quality - 1
+		}
+	}
+
+	return best, bestQuality, 0, nil
+}
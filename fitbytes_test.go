@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// noise returns a width x height image of random colors, which JPEG
+// compresses poorly.
+func noise(width int, height int) *image.RGBA {
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.IntN(256))
+		if i%4 == 3 {
+			img.Pix[i] = 0xff
+		}
+	}
+
+	return img
+}
+
+func TestScaleToFitBytes(t *testing.T) {
+	dir := t.TempDir()
+	inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), noise(1200, 900))
+
+	tests := []struct {
+		budgetKB   int64
+		wantShrunk bool
+	}{
+		{budgetKB: 4096, wantShrunk: false},
+		{budgetKB: 64, wantShrunk: true},
+		{budgetKB: 8, wantShrunk: true},
+		{budgetKB: 1, wantShrunk: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d KB", tt.budgetKB), func(t *testing.T) {
+			config := testConfig()
+			config.fitBytes = tt.budgetKB * 1024
+			outputFile := filepath.Join(t.TempDir(), "out.jpg")
+			if err := convertImage(inputFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := os.Stat(outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() > config.fitBytes {
+				t.Errorf("output is %d bytes, over the %d KB budget", info.Size(), tt.budgetKB)
+			}
+
+			bounds, err := jpegBounds(outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if shrunk := bounds.Dx() < 1200; shrunk != tt.wantShrunk {
+				t.Errorf("output is %dx%d, want shrunk %v", bounds.Dx(), bounds.Dy(), tt.wantShrunk)
+			}
+			if abs(bounds.Dx()*900-bounds.Dy()*1200) > 1200 {
+				t.Errorf("output is %dx%d, want the 4:3 aspect ratio kept", bounds.Dx(), bounds.Dy())
+			}
+		})
+	}
+}
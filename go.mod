@@ -2,4 +2,17 @@ module github.com/arthvm/image
 
 go 1.24.4
 
-require github.com/spf13/pflag v1.0.7 // indirect
+require github.com/spf13/pflag v1.0.7
+
+require (
+	github.com/gen2brain/avif v0.4.4
+	github.com/gen2brain/heic v0.4.7
+	github.com/gen2brain/webp v0.6.4
+	golang.org/x/image v0.30.0
+	golang.org/x/text v0.28.0
+)
+
+require (
+	github.com/ebitengine/purego v0.10.1 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
+)
@@ -0,0 +1,12 @@
+//go:build heic
+
+package main
+
+import "github.com/gen2brain/heic"
+
+// heicSupported reports whether this binary was built with the HEIC decoder.
+const heicSupported = true
+
+func init() {
+	RegisterDecoder("heic", heic.Decode)
+}
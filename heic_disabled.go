@@ -0,0 +1,8 @@
+//go:build !heic
+
+package main
+
+// heicSupported reports whether this binary was built with the HEIC decoder.
+// Like the AVIF encoder it embeds a WebAssembly build of a C library, so it
+// is only compiled in with the heic build tag.
+const heicSupported = false
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+// histogram holds per-bin pixel counts for the red, green, blue and luminance
+// channels of an image.
+type histogram struct {
+	red, green, blue, luma [256]int
+}
+
+func computeHistogram(img *image.NRGBA) *histogram {
+	h := &histogram{}
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b := img.Pix[i], img.Pix[i+1], img.Pix[i+2]
+		h.red[r]++
+		h.green[g]++
+		h.blue[b]++
+		h.luma[luma(r, g, b)]++
+	}
+
+	return h
+}
+
+func (h *histogram) peak() int {
+	peak := 1
+	for i := range 256 {
+		peak = max(peak, h.red[i], h.green[i], h.blue[i], h.luma[i])
+	}
+
+	return peak
+}
+
+// render draws the histogram as a chart of the given size. Luminance is a gray
+// area behind the channels, which are blended additively so overlapping
+// channels show up as their mixed color (white where all three overlap).
+func (h *histogram) render(size Size) *image.RGBA {
+	chart := image.NewRGBA(image.Rect(0, 0, size.width, size.height))
+	peak := float64(h.peak())
+
+	barHeight := func(count int) int {
+		return int(float64(count) / peak * float64(size.height))
+	}
+
+	for x := range size.width {
+		bin := x * 256 / size.width
+		lumaH := barHeight(h.luma[bin])
+		redH, greenH, blueH := barHeight(h.red[bin]), barHeight(h.green[bin]), barHeight(h.blue[bin])
+
+		for y := range size.height {
+			level := size.height - y
+			base := uint8(32)
+			if level <= lumaH {
+				base = 96
+			}
+
+			c := color.RGBA{R: base, G: base, B: base, A: 255}
+			if level <= redH {
+				c.R = 255
+			}
+			if level <= greenH {
+				c.G = 255
+			}
+			if level <= blueH {
+				c.B = 255
+			}
+
+			chart.SetRGBA(x, y, c)
+		}
+	}
+
+	return chart
+}
+
+// writeHistogram renders the histogram of inputFile as a PNG chart.
+func writeHistogram(inputFile string, outputFile string, size Size, config *Config) error {
+	srcImg, err := readImage(inputFile, config)
+	if err != nil {
+		return err
+	}
+
+	chart := computeHistogram(toNRGBA(srcImg)).render(size)
+
+	return writeOutput(outputFile, config, func(w io.Writer) error {
+		return encodePNG(w, chart, config)
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+)
+
+// infoMaxSamples bounds how many pixels are looked at to count colors; larger
+// images are sampled with a stride, which makes the count an estimate.
+const infoMaxSamples = 1 << 20
+
+// imageInfo is the --info report: what --validate says about an input plus
+// its metadata.
+type imageInfo struct {
+	validation
+	BitDepth int `json:"bitDepth"`
+	// Colors is exact unless the image is larger than infoMaxSamples.
+	Colors     int          `json:"colors"`
+	ICCProfile bool         `json:"iccProfile"`
+	EXIF       *exifSummary `json:"exif,omitempty"`
+}
+
+type exifSummary struct {
+	Camera      string `json:"camera,omitempty"`
+	Date        string `json:"date,omitempty"`
+	Orientation int    `json:"orientation,omitempty"`
+	GPS         bool   `json:"gps"`
+}
+
+// bitDepth returns the bits per sample of img's color model.
+func bitDepth(img image.Image) int {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return 16
+	default:
+		return 8
+	}
+}
+
+// estimateColors counts the distinct colors of img, sampling at most
+// infoMaxSamples pixels.
+func estimateColors(img image.Image) int {
+	bounds := img.Bounds()
+	step := 1
+	for (bounds.Dx()/step)*(bounds.Dy()/step) > infoMaxSamples {
+		step++
+	}
+
+	colors := make(map[color.NRGBA]struct{})
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			colors[color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)] = struct{}{}
+		}
+	}
+
+	return len(colors)
+}
+
+// hasICCProfile reports whether inputFile embeds an ICC profile: an iCCP
+// chunk in a PNG, or an APP2 ICC_PROFILE segment in a JPEG.
+func hasICCProfile(inputFile string, format string, meta *Metadata) bool {
+	switch format {
+	case "png":
+		if meta.pngColor == nil {
+			return false
+		}
+		for _, chunk := range meta.pngColor.chunks {
+			if string(chunk[4:8]) == "iCCP" {
+				return true
+			}
+		}
+	case "jpeg":
+		f, err := os.Open(inputFile)
+		if err != nil {
+			return false
+		}
+		defer f.Close()
+
+		profile, err := jpegSegment(bufio.NewReader(f), 0xe2, []byte("ICC_PROFILE\x00"))
+		return err == nil && profile != nil
+	}
+
+	return false
+}
+
+// readInfo decodes inputFile and gathers its imageInfo, without converting
+// anything.
+func readInfo(inputFile string, config *Config) (*imageInfo, error) {
+	img, format, err := decodeFully(inputFile, config)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := readMetadata(inputFile, config)
+	info := &imageInfo{
+		validation: *describeImage(inputFile, format, img),
+		BitDepth:   bitDepth(img),
+		Colors:     estimateColors(img),
+		ICCProfile: hasICCProfile(inputFile, format, meta),
+	}
+
+	if exif := meta.exif; exif != nil {
+		info.EXIF = &exifSummary{GPS: exif.hasGPS()}
+		info.EXIF.Camera, _ = exif.camera()
+		info.EXIF.Date, _ = exif.dateTimeOriginal()
+		info.EXIF.Orientation, _ = exif.orientation()
+	}
+
+	return info, nil
+}
+
+func printInfo(inputFile string, asJSON bool, config *Config) error {
+	info, err := readInfo(inputFile, config)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+
+	fmt.Printf("%s: %s, %dx%d\n", info.File, info.Format, info.Width, info.Height)
+	fmt.Printf("  color model: %s, %d-bit, alpha: %t\n", info.ColorModel, info.BitDepth, info.HasAlpha)
+	fmt.Printf("  colors: %d\n", info.Colors)
+	fmt.Printf("  icc profile: %t\n", info.ICCProfile)
+
+	if info.EXIF == nil {
+		fmt.Println("  exif: none")
+		return nil
+	}
+
+	var parts []string
+	if info.EXIF.Camera != "" {
+		parts = append(parts, "camera "+info.EXIF.Camera)
+	}
+	if info.EXIF.Date != "" {
+		parts = append(parts, "taken "+info.EXIF.Date)
+	}
+	if info.EXIF.Orientation != 0 {
+		parts = append(parts, fmt.Sprintf("orientation %d", info.EXIF.Orientation))
+	}
+	parts = append(parts, fmt.Sprintf("gps: %t", info.EXIF.GPS))
+	fmt.Printf("  exif: %s\n", strings.Join(parts, ", "))
+
+	return nil
+}
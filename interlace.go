@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image/png"
+	"io"
+)
+
+// adam7Passes are the origin and spacing of the pixels each of the seven
+// Adam7 passes holds.
+var adam7Passes = []struct{ x, y, dx, dy int }{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// pngChannels is the number of samples per pixel of each PNG color type.
+var pngChannels = map[byte]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}
+
+// interlacePNG rewrites a non-interlaced PNG, as written by image/png, into
+// an Adam7 interlaced one with the same color type, bit depth and ancillary
+// chunks. image/png can read such files but not write them.
+func interlacePNG(data []byte, level png.CompressionLevel) ([]byte, error) {
+	const signatureLen = 8
+	if len(data) < signatureLen {
+		return nil, errors.New("interlace: not a PNG")
+	}
+
+	var chunks [][]byte
+	var idat bytes.Buffer
+	idatIndex := -1
+	for rest := data[signatureLen:]; len(rest) > 0; {
+		if len(rest) < 12 {
+			return nil, errors.New("interlace: truncated chunk")
+		}
+		length := int(binary.BigEndian.Uint32(rest[:4]))
+		if len(rest) < 12+length {
+			return nil, errors.New("interlace: truncated chunk")
+		}
+
+		chunk := rest[:12+length]
+		rest = rest[12+length:]
+		if string(chunk[4:8]) == "IDAT" {
+			if idatIndex < 0 {
+				idatIndex = len(chunks)
+			}
+			idat.Write(chunk[8 : 8+length])
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) == 0 || string(chunks[0][4:8]) != "IHDR" || idatIndex < 0 {
+		return nil, errors.New("interlace: missing IHDR or IDAT")
+	}
+
+	ihdr := chunks[0][8:21]
+	width := int(binary.BigEndian.Uint32(ihdr[0:4]))
+	height := int(binary.BigEndian.Uint32(ihdr[4:8]))
+	depth, colorType := int(ihdr[8]), ihdr[9]
+	channels, ok := pngChannels[colorType]
+	if !ok {
+		return nil, fmt.Errorf("interlace: unknown color type %d", colorType)
+	}
+	if ihdr[12] != 0 {
+		return data, nil
+	}
+
+	zr, err := zlib.NewReader(&idat)
+	if err != nil {
+		return nil, err
+	}
+	filtered, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	bitsPerPixel := channels * depth
+	pixels, err := unfilterRows(filtered, width, height, bitsPerPixel)
+	if err != nil {
+		return nil, err
+	}
+
+	passes, err := adam7Encode(pixels, width, height, bitsPerPixel, zlibLevel(level))
+	if err != nil {
+		return nil, err
+	}
+
+	header := bytes.Clone(chunks[0])
+	header[8+12] = 1
+	binary.BigEndian.PutUint32(header[21:], crc32.ChecksumIEEE(header[4:21]))
+	chunks[0] = header
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:signatureLen])
+	for i, chunk := range chunks {
+		if i == idatIndex {
+			out.Write(pngChunk("IDAT", passes))
+		}
+		out.Write(chunk)
+	}
+
+	return out.Bytes(), nil
+}
+
+// zlibLevel maps a png.CompressionLevel to the zlib level image/png uses for
+// it.
+func zlibLevel(level png.CompressionLevel) int {
+	switch level {
+	case png.NoCompression:
+		return zlib.NoCompression
+	case png.BestSpeed:
+		return zlib.BestSpeed
+	case png.BestCompression:
+		return zlib.BestCompression
+	default:
+		return zlib.DefaultCompression
+	}
+}
+
+// unfilterRows undoes the per row filters of non-interlaced image data,
+// returning the packed rows back to back.
+func unfilterRows(filtered []byte, width int, height int, bitsPerPixel int) ([]byte, error) {
+	stride := (width*bitsPerPixel + 7) / 8
+	if len(filtered) < height*(stride+1) {
+		return nil, errors.New("interlace: short image data")
+	}
+
+	bpp := max(1, bitsPerPixel/8)
+	pixels := make([]byte, height*stride)
+	prev := make([]byte, stride)
+	for y := range height {
+		filter := filtered[y*(stride+1)]
+		row := pixels[y*stride : (y+1)*stride]
+		copy(row, filtered[y*(stride+1)+1:(y+1)*(stride+1)])
+
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+
+			switch filter {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("interlace: unknown filter %d", filter)
+			}
+		}
+		prev = row
+	}
+
+	return pixels, nil
+}
+
+// adam7Encode splits packed rows into the Adam7 passes, filters each pass
+// row and compresses the lot at level.
+func adam7Encode(pixels []byte, width int, height int, bitsPerPixel int, level int) ([]byte, error) {
+	stride := (width*bitsPerPixel + 7) / 8
+	bpp := max(1, bitsPerPixel/8)
+
+	var out bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&out, level)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pass := range adam7Passes {
+		passWidth := (width - pass.x + pass.dx - 1) / pass.dx
+		passHeight := (height - pass.y + pass.dy - 1) / pass.dy
+		if passWidth <= 0 || passHeight <= 0 {
+			continue
+		}
+
+		passStride := (passWidth*bitsPerPixel + 7) / 8
+		prev := make([]byte, passStride)
+		row := make([]byte, passStride)
+		for py := range passHeight {
+			src := pixels[(pass.y+py*pass.dy)*stride:][:stride]
+			clear(row)
+			for px := range passWidth {
+				copyPixel(row, px, src, pass.x+px*pass.dx, bitsPerPixel)
+			}
+
+			if _, err := zw.Write(filterRow(row, prev, bpp)); err != nil {
+				return nil, err
+			}
+			prev, row = row, prev
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// copyPixel copies pixel sx of the packed row src to pixel dx of dst, which
+// must start out zeroed for depths below 8 bits.
+func copyPixel(dst []byte, dx int, src []byte, sx int, bitsPerPixel int) {
+	if bitsPerPixel >= 8 {
+		n := bitsPerPixel / 8
+		copy(dst[dx*n:(dx+1)*n], src[sx*n:(sx+1)*n])
+		return
+	}
+
+	mask := byte(1)<<bitsPerPixel - 1
+	sbit, dbit := sx*bitsPerPixel, dx*bitsPerPixel
+	v := src[sbit/8] >> (8 - bitsPerPixel - sbit%8) & mask
+	dst[dbit/8] |= v << (8 - bitsPerPixel - dbit%8)
+}
+
+// filterRow picks the filter giving the smallest sum of absolute differences,
+// the heuristic image/png uses, and returns the filter type followed by the
+// filtered row.
+func filterRow(row []byte, prev []byte, bpp int) []byte {
+	var best []byte
+	bestSum := -1
+	for filter := range byte(5) {
+		out := make([]byte, 1+len(row))
+		out[0] = filter
+
+		sum := 0
+		for i, v := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+
+			switch filter {
+			case 1:
+				v -= left
+			case 2:
+				v -= up
+			case 3:
+				v -= byte((int(left) + int(up)) / 2)
+			case 4:
+				v -= paeth(left, up, upLeft)
+			}
+
+			out[1+i] = v
+			sum += min(int(v), 256-int(v))
+		}
+
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = out, sum
+		}
+	}
+
+	return best
+}
+
+// paeth is the PNG Paeth predictor.
+func paeth(a byte, b byte, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/png"
+	"testing"
+)
+
+func TestInterlacePNG(t *testing.T) {
+	rgba := testPattern(37, 23)
+
+	gray := image.NewGray(image.Rect(0, 0, 13, 9))
+	gray16 := image.NewGray16(image.Rect(0, 0, 13, 9))
+	paletted := image.NewPaletted(image.Rect(0, 0, 11, 7), palette.Plan9)
+	for y := range 9 {
+		for x := range 13 {
+			gray.SetGray(x, y, color.Gray{Y: uint8(x*19 + y*7)})
+			gray16.SetGray16(x, y, color.Gray16{Y: uint16(x*4099 + y*257)})
+			paletted.SetColorIndex(x%11, y%7, uint8(x*y))
+		}
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"rgba", rgba},
+		{"opaque rgb", testJPEG(t, 19, 17)},
+		{"gray", gray},
+		{"gray16", gray16},
+		{"paletted", paletted},
+		{"single pixel", rgba.SubImage(image.Rect(3, 3, 4, 4))},
+		{"narrower than a pass", rgba.SubImage(image.Rect(0, 0, 3, 23))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encode := func(interlace bool) []byte {
+				config := testConfig()
+				config.interlace = interlace
+
+				var buf bytes.Buffer
+				if err := encodePNG(&buf, tt.img, config); err != nil {
+					t.Fatal(err)
+				}
+				return buf.Bytes()
+			}
+			interlaced, plain := encode(true), encode(false)
+
+			// The interlace method is the last byte of the IHDR data.
+			if method := interlaced[28]; method != 1 {
+				t.Fatalf("IHDR interlace method = %d, want 1 (Adam7)", method)
+			}
+
+			got, err := png.Decode(bytes.NewReader(interlaced))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := png.Decode(bytes.NewReader(plain))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("decoded %v, want %v", got.Bounds(), want.Bounds())
+			}
+			if gotType, wantType := fmt.Sprintf("%T", got), fmt.Sprintf("%T", want); gotType != wantType {
+				t.Errorf("interlaced PNG decodes to a %s, want a %s", gotType, wantType)
+			}
+			bounds := want.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					if px, wantPx := got.At(x, y), want.At(x, y); px != wantPx {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, px, wantPx)
+					}
+				}
+			}
+		})
+	}
+}
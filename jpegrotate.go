@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"slices"
+)
+
+// rotatedOrientation maps an EXIF orientation to the one that shows the
+// image turned a further 90 degrees clockwise.
+var rotatedOrientation = map[int]int{1: 6, 6: 3, 3: 8, 8: 1, 2: 7, 7: 4, 4: 5, 5: 2}
+
+// isRightAngle reports whether degrees is a nonzero multiple of 90.
+func isRightAngle(degrees float64) bool {
+	return degrees != 0 && math.Mod(degrees, 90) == 0
+}
+
+// rotateRightAngle turns img clockwise by a multiple of 90 degrees by moving
+// pixels rather than resampling them, so nothing is blurred.
+func rotateRightAngle(img image.Image, degrees float64) *image.NRGBA {
+	orientation := 1
+	for range int(math.Mod(degrees+360, 360)) / 90 {
+		orientation = rotatedOrientation[orientation]
+	}
+
+	return orient(img, orientation)
+}
+
+// isLosslessRotation reports whether --rotate-lossless can handle the
+// conversion of a JPEG with the given bounds: a right angle --rotate-exact
+// must be all it asks for.
+func isLosslessRotation(bounds image.Rectangle, config *Config) bool {
+	if !config.rotateLossless || !isRightAngle(config.rotate) || config.stripGPS || config.embedSource || config.fitBytes > 0 {
+		return false
+	}
+
+	unrotated := *config
+	unrotated.rotate = 0
+
+	return isPassthrough(bounds, &unrotated)
+}
+
+// rotateJPEGLosslessly copies the JPEG inputFile to outputFile with only its
+// EXIF orientation changed to show it rotated by config.rotate, leaving the
+// compressed image data and all other metadata untouched. It reports false,
+// without writing anything, when the file has no orientation tag to change.
+func rotateJPEGLosslessly(inputFile string, outputFile string, config *Config) (bool, error) {
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return false, err
+	}
+
+	raw, err := jpegExif(bytes.NewReader(data))
+	if err != nil || raw == nil {
+		return false, err
+	}
+
+	exif, err := parseExif(raw)
+	if err != nil {
+		return false, nil
+	}
+
+	orientation, ok := exif.orientation()
+	if !ok {
+		return false, nil
+	}
+	for range int(math.Mod(config.rotate+360, 360)) / 90 {
+		orientation = rotatedOrientation[orientation]
+	}
+
+	rotated := exif.withOrientation(orientation)
+	if rotated == exif {
+		return false, nil
+	}
+
+	// The tag is rewritten in place, so the segment keeps its length.
+	offset := bytes.Index(data, append(slices.Clone(exifHeader), raw...))
+	if offset < 0 {
+		return false, nil
+	}
+	copy(data[offset+len(exifHeader):], rotated.raw)
+
+	return true, writeOutput(outputFile, config, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// jpegBounds returns the dimensions of the JPEG inputFile without decoding
+// it.
+func jpegBounds(inputFile string) (image.Rectangle, error) {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	defer f.Close()
+
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	return image.Rect(0, 0, cfg.Width, cfg.Height), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// meanDifference is the mean absolute difference of the channels of a and
+// b, which must have the same size.
+func meanDifference(a, b image.Image) float64 {
+	var sum, n float64
+	bounds := a.Bounds()
+	for y := range bounds.Dy() {
+		for x := range bounds.Dx() {
+			r1, g1, b1, _ := a.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(b.Bounds().Min.X+x, b.Bounds().Min.Y+y).RGBA()
+			for _, d := range []int{int(r1) - int(r2), int(g1) - int(g2), int(b1) - int(b2)} {
+				sum += float64(abs(d)) / 257
+				n++
+			}
+		}
+	}
+
+	return sum / n
+}
+
+func TestRotateLosslessDoesNotDegrade(t *testing.T) {
+	dir := t.TempDir()
+	b := newExifBuilder()
+	exif := b.finish(b.ifd([]testTag{shortTag(exifTagOrientation, 1)}, 0))
+	original := writeJPEGWithExif(t, dir, "in.jpg", testJPEG(t, 64, 48), exif)
+
+	// rotate turns original a full circle in four 90 degree conversions and
+	// returns the last output.
+	rotate := func(name string, lossless bool) string {
+		t.Helper()
+
+		inputFile := original
+		for i := range 4 {
+			config := testConfig()
+			config.rotate, config.rotateLossless, config.keepMetadata = 90, lossless, true
+			outputFile := filepath.Join(dir, fmt.Sprintf("%s%d.jpg", name, i))
+			if err := convertImage(inputFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+			inputFile = outputFile
+		}
+
+		return inputFile
+	}
+
+	// Lossless rotation only changes the orientation tag.
+	lossless := rotate("lossless", true)
+	first := outputExif(t, filepath.Join(dir, "lossless0.jpg"))
+	if orientation, _ := first.orientation(); orientation != 6 {
+		t.Errorf("orientation after one lossless rotation = %d, want 6", orientation)
+	}
+	want, err := os.ReadFile(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(lossless)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("four lossless rotations did not give back the original file")
+	}
+
+	// Re-encoding drifts further from the original with every rotation.
+	reencoded := rotate("reencoded", false)
+	src := readTestImage(t, original)
+	if diff := meanDifference(src, readTestImage(t, reencoded)); diff == 0 {
+		t.Error("four re-encoded rotations left the pixels unchanged, the lossy path was not taken")
+	} else {
+		t.Logf("mean difference after four re-encoded rotations: %.2f", diff)
+	}
+	if diff := meanDifference(src, readTestImage(t, lossless)); diff != 0 {
+		t.Errorf("mean difference after four lossless rotations = %.2f, want 0", diff)
+	}
+}
@@ -1,204 +1,1983 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"image/jpeg"
 	"image/png"
-	"log"
+	"math"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/arthvm/image/convert"
 	flag "github.com/spf13/pflag"
 )
 
-type Padding struct {
-	top    int
-	right  int
-	bottom int
-	left   int
+// Padding is shared with the convert package, so --padding means the same
+// there.
+type Padding = convert.Padding
+
+type Config struct {
+	inFormat  string
+	outFormat string
+	// raw is the layout of --in-format raw input.
+	raw rawLayout
+	// rawOrder and rawDepth shape raw output, see encodeRaw.
+	rawOrder string
+	rawDepth int
+
+	bgColor    color.Color
+	background Background
+	matte      Background
+	edgeColors EdgeColors
+	padding    Padding
+	square     bool
+	radius     Corners
+	// rotate turns the image clockwise by this many degrees, growing the
+	// canvas to fit unless rotateCrop is set.
+	rotate     float64
+	rotateCrop bool
+	// rotateLossless turns JPEGs by their EXIF orientation when possible,
+	// see rotateJPEGLosslessly.
+	rotateLossless bool
+	// deskew straightens the image by its detected skew, see skewAngle.
+	deskew bool
+	// trimTransparent crops transparent margins before padding.
+	trimTransparent bool
+	// shadow is the drop shadow drawn behind the image, or nil for none.
+	shadow *Shadow
+	// quality and avifQuality are -1 unless given, see qualityFor.
+	quality     int
+	avifQuality int
+	autoQuality bool
+	// webOptimized swaps in the defaults listed in webOptimizedHelp.
+	webOptimized bool
+	// interlace writes PNG output Adam7 interlaced, see interlacePNG.
+	interlace bool
+	verbose   bool
+	resize    Size
+	// smartCrop is the --smart-crop size, or zero, see smartCrop.
+	smartCrop Size
+	scale     float64
+	noUpscale bool
+	// onlyEnlarge leaves images that already reach the --resize box in
+	// either dimension alone, like ImageMagick's < modifier.
+	onlyEnlarge bool
+	// keepAspect is "fit" or "letterbox" when --resize must not distort.
+	keepAspect  string
+	tile        Size
+	fileMode    os.FileMode
+	premultiply bool
+	// strict turns warnings into errors, see warn.
+	strict bool
+	// allowPartial keeps what decodes of truncated JPEG inputs, see
+	// decodeTruncatedJPEG.
+	allowPartial bool
+
+	// maxOutputSize is the most bytes an encoded image may take, or 0 for
+	// no limit.
+	maxOutputSize int64
+	// fitBytes is the byte budget of --scale-to-fit-bytes, or 0, see
+	// withByteBudget.
+	fitBytes int64
+
+	// tmpDir holds temporary files instead of the output's directory, for
+	// atomic writes, or os.TempDir, for piped data, when set.
+	tmpDir string
+
+	// onlyIfSmaller, when positive, is the size of the original file: output
+	// that doesn't come out smaller is not written, see errNotSmaller.
+	onlyIfSmaller int64
+	// inPlace lets the output replace the input file it is converted from.
+	inPlace bool
+
+	// preserveMtime gives the output the modification time of its source,
+	// which convertFile records in modTime for writeOutput.
+	preserveMtime bool
+	modTime       time.Time
+
+	// seed seeds every randomized step, see defaultSeed.
+	seed int64
+
+	// reproducible guarantees byte-for-byte identical output for identical
+	// input, wherever it is converted: no timestamps in metadata and only the
+	// base name of --embed-source paths.
+	reproducible bool
+
+	// embedSource records the input path in PNG and JPEG output, with the
+	// conversion time when embedSourceTime is set.
+	embedSource     bool
+	embedSourceTime bool
+	// inputName is what warnings and --embed-source call the input when it
+	// is not read from a path of its own, see displayName.
+	inputName string
+
+	dataURI bool
+
+	keepMetadata bool
+	stripGPS     bool
+	// autoOrient applies the EXIF orientation to the pixels, resetting the
+	// tag in kept metadata.
+	autoOrient bool
+
+	autoFormatColors int
+
+	chromaKey       color.Color
+	chromaTolerance float64
+	chromaFeather   float64
+
+	grayscale       bool
+	grayscaleMethod string
+
+	// duotone holds the shadow and highlight colors luminance is mapped
+	// onto, see duotone.
+	duotone *[2]color.Color
+
+	// tint is blended into the image by tintAmount when set, see tint.
+	tint       color.Color
+	tintAmount float64
+	tintMode   string
+
+	posterize int
+	vignette  float64
+	// opacity fades the image towards bgColor below 1.
+	opacity float64
+
+	// threshold binarizes the image at this luminance, or is -1 for none.
+	threshold     int
+	autoThreshold bool
+
+	pixelate int
+	// pixelateRegion limits --pixelate to part of the image when set.
+	pixelateRegion *image.Rectangle
+
+	datestamp      bool
+	datestampPos   string
+	datestampColor color.Color
+
+	text      string
+	textPos   string
+	textColor color.Color
+	textSize  float64
+	textBox   color.Color
+	textFont  string
+
+	compareThreshold int
+
+	contactCell       Size
+	contactGap        int
+	contactLabels     bool
+	contactLabelColor color.Color
+
+	// palette, when set, is the exact palette of GIF and PNG output.
+	palette color.Palette
+	// quantize is the method fitting an adaptive palette to GIF and PNG
+	// output, or empty for the fixed palettes.
+	quantize string
+	dither   bool
+
+	// pipeline overrides the order of renderStages when set.
+	pipeline []stage
+
+	// frameDelay is the pause between animation frames in milliseconds.
+	frameDelay int
+	// loopCount follows gif.GIF.LoopCount: 0 loops forever, -1 plays once.
+	loopCount int
+	// gifGlobalPalette quantizes every animation frame to one palette
+	// computed from all of them, and gifOptimize additionally stores only
+	// the part of each frame that changed.
+	gifGlobalPalette bool
+	gifOptimize      bool
+	// gifTransparent is the color made transparent in GIF output, and
+	// gifBackground the color of its logical screen background.
+	gifTransparent color.Color
+	gifBackground  color.Color
 }
 
-type Config struct {
-	bgColor color.Color
-	padding Padding
-	quality int
-}
+func main() {
+	handleInterrupts()
+
+	var bgColor string
+	flag.StringVarP(
+		&bgColor,
+		"background",
+		"b",
+		"white",
+		"Determines the background of the canvas (a color, gradient:FROM-TO, checkerboard, or blur for a blurred copy of the image); PNG output only takes patterns, use --matte to flatten it onto a color",
+	)
+
+	var gradientDirection string
+	flag.StringVar(
+		&gradientDirection,
+		"gradient-direction",
+		"vertical",
+		"Direction of a gradient background (vertical, horizontal or diagonal)",
+	)
+
+	var edgeColors [4]string
+	for i, edge := range []string{"top", "right", "bottom", "left"} {
+		flag.StringVar(
+			&edgeColors[i],
+			"background-"+edge,
+			"",
+			"Color of the "+edge+" padding (defaults to the canvas background)",
+		)
+	}
+
+	var backgroundImage string
+	flag.StringVar(
+		&backgroundImage,
+		"background-image",
+		"",
+		"Place the image over this picture, scaled to cover the canvas, instead of a solid background",
+	)
+
+	var matte string
+	flag.StringVar(
+		&matte,
+		"matte",
+		"",
+		"Flatten PNG output onto this background (same values as --background) instead of keeping transparency",
+	)
+
+	var checkerSize int
+	flag.IntVar(&checkerSize, "checker-size", 8, "Size in pixels of the squares of a checkerboard background")
+
+	var padding string
+	flag.StringVarP(&padding, "padding", "p", "", "Configure image padding")
+
+	var square bool
+	flag.BoolVar(&square, "square", false, "Pad the shorter side so the output is a centered square")
+
+	var radius string
+	flag.StringVar(
+		&radius,
+		"radius",
+		"",
+		"Round the image corners by RADIUS, or TOP-LEFT,TOP-RIGHT,BOTTOM-RIGHT,BOTTOM-LEFT radii",
+	)
+
+	var shadow bool
+	flag.BoolVar(&shadow, "shadow", false, "Draw a soft drop shadow behind the image, visible within the padding")
+
+	var shadowBlur float64
+	flag.Float64Var(&shadowBlur, "shadow-blur", 8, "Blur radius (standard deviation in pixels, 0 to 100) of the drop shadow")
+
+	var shadowOffset string
+	flag.StringVar(&shadowOffset, "shadow-offset", "6,6", "Offset of the drop shadow as X,Y pixels")
+
+	var shadowColor string
+	flag.StringVar(&shadowColor, "shadow-color", "black", "Color of the drop shadow")
+
+	var shadowOpacity float64
+	flag.Float64Var(&shadowOpacity, "shadow-opacity", 0.5, "Opacity of the drop shadow (0 to 1)")
+
+	var quality string
+	flag.StringVarP(
+		&quality,
+		"quality",
+		"q",
+		"",
+		"Defines the quality of the compression (1 to 100, or auto to pick a jpeg quality from the image's detail; defaults to 90 for jpeg, 60 for avif and 80 for webp; ignored by other formats)",
+	)
+
+	var avifQuality int
+	flag.IntVar(&avifQuality, "avif-quality", 0, "Quality of AVIF output (0 to 100, 100 is lossless), overriding --quality")
+
+	var verbose bool
+	flag.BoolVarP(&verbose, "verbose", "v", false, "Report decisions made during the conversion")
+
+	var resize string
+	flag.StringVar(
+		&resize,
+		"resize",
+		"",
+		"Resize the image to WIDTHxHEIGHT before padding, WIDTHx or xHEIGHT to keep the aspect ratio, or an ImageMagick geometry: WxH! exact, WxH> shrink to fit, WxH< enlarge to fit, WxH^ fill, or N%",
+	)
+
+	var smartCropStr string
+	flag.StringVar(
+		&smartCropStr,
+		"smart-crop",
+		"",
+		"Scale the image to fill WIDTHxHEIGHT and crop the most detailed window of that size instead of the center",
+	)
+
+	var chromaKey string
+	flag.StringVar(&chromaKey, "chroma-key", "", "Make pixels close to this color transparent (e.g. 00ff00)")
+
+	var chromaTolerance float64
+	flag.Float64Var(
+		&chromaTolerance,
+		"chroma-tolerance",
+		30,
+		"RGB distance from the key color within which --chroma-key removes pixels",
+	)
+
+	var chromaFeather float64
+	flag.Float64Var(
+		&chromaFeather,
+		"chroma-feather",
+		0,
+		"Width of the band beyond --chroma-tolerance over which removed pixels fade back in",
+	)
+
+	var grayscale bool
+	flag.BoolVar(&grayscale, "grayscale", false, "Convert the image to grayscale")
+
+	var grayscaleMethod string
+	flag.StringVar(
+		&grayscaleMethod,
+		"grayscale-method",
+		"luminosity",
+		"Formula used by --grayscale (luminosity, average or lightness)",
+	)
+
+	var scale float64
+	flag.Float64Var(&scale, "scale", 0, "Scale the image by a factor of its own dimensions (e.g. 0.5)")
+
+	var tile string
+	flag.StringVar(&tile, "tile", "", "Repeat the image to fill a WIDTHxHEIGHT canvas instead of scaling it")
+
+	var keepAspect string
+	flag.StringVar(
+		&keepAspect,
+		"keep-aspect",
+		"",
+		"Keep the aspect ratio with --resize: fit within the box, letterbox to fill it with the background, or cover it",
+	)
+	flag.Lookup("keep-aspect").NoOptDefVal = "fit"
+
+	var noUpscale bool
+	flag.BoolVar(&noUpscale, "no-upscale", false, "Never resize beyond the source's native dimensions")
+
+	var autoFormat bool
+	flag.BoolVar(
+		&autoFormat,
+		"auto-format",
+		false,
+		"Pick PNG or JPEG from the image contents and append the extension to the output name",
+	)
+
+	var autoFormatColors int
+	flag.IntVar(
+		&autoFormatColors,
+		"auto-format-colors",
+		1024,
+		"Opaque images with at most this many colors are treated as graphics by --auto-format",
+	)
+
+	var inFormat string
+	flag.StringVar(&inFormat, "in-format", "", "Decode the input as this format instead of using its extension")
+	flag.StringVar(&inFormat, "stdin-format", "", "Alias for --in-format")
+	flag.StringVar(&inFormat, "from", "", "Alias for --in-format")
+
+	var rawWidth, rawHeight, rawChannelCount int
+	flag.IntVar(&rawWidth, "raw-width", 0, "Width in pixels of --in-format raw input")
+	flag.IntVar(&rawHeight, "raw-height", 0, "Height in pixels of --in-format raw input")
+	flag.IntVar(&rawChannelCount, "raw-channels", 4, "Bytes per pixel of --in-format raw input: 1 (gray), 3 (RGB) or 4 (RGBA)")
+
+	var rawOrder string
+	flag.StringVar(&rawOrder, "raw-order", "rgba", "Channel order of raw output: rgba, rgb, bgra, bgr, argb or gray")
+
+	var rawDepth int
+	flag.IntVar(&rawDepth, "raw-depth", 8, "Bits per sample of raw output, 8 or 16 (big-endian)")
+
+	var outFormat string
+	flag.StringVar(&outFormat, "out-format", "", "Encode the output as this format instead of using its extension")
+	flag.StringVar(&outFormat, "to", "", "Alias for --out-format")
+
+	var reproducible bool
+	flag.BoolVar(
+		&reproducible,
+		"reproducible",
+		false,
+		"Guarantee byte-for-byte identical output for identical input (no timestamps or directories in metadata)",
+	)
+
+	var dataURI bool
+	flag.BoolVar(
+		&dataURI,
+		"data-uri",
+		false,
+		"Write the output as a base64 data URI (to stdout when no output file is given)",
+	)
+
+	var datestamp bool
+	flag.BoolVar(&datestamp, "datestamp", false, "Draw the EXIF capture date of JPEG inputs in a corner")
+
+	var datestampPos string
+	flag.StringVar(
+		&datestampPos,
+		"datestamp-pos",
+		"bottom-right",
+		"Corner of the datestamp (top-left, top-right, bottom-left or bottom-right)",
+	)
+
+	var datestampColor string
+	flag.StringVar(&datestampColor, "datestamp-color", "white", "Color of the datestamp text")
+
+	var text string
+	flag.StringVar(&text, "text", "", "Draw this caption onto the output")
+
+	var textPos string
+	flag.StringVar(
+		&textPos,
+		"text-pos",
+		"bottom-center",
+		"Anchor of the caption (top-left, top-center, top-right, middle-left, center, middle-right, bottom-left, bottom-center or bottom-right)",
+	)
+
+	var textColor string
+	flag.StringVar(&textColor, "text-color", "white", "Color of the caption text")
+
+	var textSize float64
+	flag.Float64Var(&textSize, "text-size", 24, "Font size of the caption in points")
+
+	var textBox string
+	flag.StringVar(&textBox, "text-box", "", "Draw a box of this color behind the caption")
+
+	var textFont string
+	flag.StringVar(&textFont, "font", "", "TrueType/OpenType font for the caption (defaults to a built-in font)")
+
+	var posterize int
+	flag.IntVar(&posterize, "posterize", 0, "Reduce each color channel to this many levels (2 to 256)")
+
+	var pixelate int
+	flag.IntVar(&pixelate, "pixelate", 0, "Average each BLOCKxBLOCK square into a single color")
+
+	var pixelateRegion string
+	flag.StringVar(&pixelateRegion, "pixelate-region", "", "Only pixelate the X,Y,WIDTH,HEIGHT part of the image")
+
+	var thresholdStr string
+	flag.StringVar(
+		&thresholdStr,
+		"threshold",
+		"",
+		"Turn pixels black or white by luminance: 0 to 255, or auto to pick one with Otsu's method",
+	)
+
+	var duotoneColors string
+	flag.StringVar(
+		&duotoneColors,
+		"duotone",
+		"",
+		"Map luminance onto a gradient between two colors, shadows first, e.g. 202080-ffd0a0",
+	)
+
+	var tintColor string
+	flag.StringVar(&tintColor, "tint", "", "Blend this color into the image, e.g. ff8800")
+
+	var tintAmount float64
+	flag.Float64Var(&tintAmount, "tint-amount", 0.3, "Strength of --tint, from 0 (none) to 1 (solid color)")
+
+	var tintMode string
+	flag.StringVar(&tintMode, "tint-mode", "all", "Tones --tint affects: all, or midtones for a duotone look after --grayscale")
+
+	var opacity float64
+	flag.Float64Var(&opacity, "opacity", 1, "Fade the image towards the background color, from 1 (unchanged) to 0")
+
+	var vignette float64
+	flag.Float64Var(&vignette, "vignette", 0, "Darken the image towards its edges, from 0 (none) to 1 (strong)")
+
+	var rotate float64
+	flag.Float64Var(&rotate, "rotate-exact", 0, "Rotate the image clockwise by this many degrees, e.g. 37.5")
+
+	var deskew bool
+	flag.BoolVar(&deskew, "deskew", false, "Detect the skew of a scanned document, up to 15 degrees, and straighten it")
+
+	var rotateLossless bool
+	flag.BoolVar(
+		&rotateLossless,
+		"rotate-lossless",
+		false,
+		"Turn JPEG to JPEG conversions by 90, 180 or 270 --rotate-exact degrees by updating the EXIF orientation instead of re-encoding",
+	)
+
+	var rotateFit bool
+	flag.BoolVar(&rotateFit, "rotate-fit", false, "Grow the canvas to fit the rotated or deskewed image (the default)")
+
+	var rotateCrop bool
+	flag.BoolVar(&rotateCrop, "rotate-crop", false, "Crop the rotated or deskewed image to the original size")
+
+	var trimTransparent bool
+	flag.BoolVar(
+		&trimTransparent,
+		"trim-transparent",
+		false,
+		"Crop fully transparent rows and columns around the content before padding",
+	)
+
+	var autoOrient bool
+	flag.BoolVar(&autoOrient, "auto-orient", false, "Turn JPEG inputs upright according to their EXIF orientation")
+
+	var embedSource bool
+	flag.BoolVar(&embedSource, "embed-source", false, "Record the input path in PNG (Source text) and JPEG (comment) output")
+
+	var embedSourceTime bool
+	flag.BoolVar(&embedSourceTime, "embed-source-time", false, "Also record when the output was converted with --embed-source")
+
+	var webOptimized bool
+	flag.BoolVar(&webOptimized, "web-optimized", false, "Use smaller defaults suited to serving on the web, see below")
+
+	var interlace bool
+	flag.BoolVar(&interlace, "interlace", false, "Write Adam7 interlaced PNG output, which browsers show progressively")
+
+	var keepMetadata bool
+	flag.BoolVar(&keepMetadata, "keep-metadata", false, "Copy the EXIF metadata of JPEG inputs into JPEG output")
+
+	var stripGPS bool
+	flag.BoolVar(
+		&stripGPS,
+		"strip-gps",
+		false,
+		"Keep EXIF metadata but remove GPS location tags (implies --keep-metadata)",
+	)
+
+	var maxOutputSize int
+	flag.IntVar(&maxOutputSize, "max-output-size", 0, "Fail instead of writing output larger than this many KB (0 for no limit)")
+
+	var fitKB int
+	flag.IntVar(
+		&fitKB,
+		"scale-to-fit-bytes",
+		0,
+		"Fit JPEG output in this many KB, lowering quality first and then downscaling (0 to disable)",
+	)
+
+	var onlyIfSmaller bool
+	flag.BoolVar(
+		&onlyIfSmaller,
+		"only-if-smaller",
+		false,
+		"Only write the output if it is smaller than the input, which may then be replaced in place",
+	)
+
+	var tmpDir string
+	flag.StringVar(
+		&tmpDir,
+		"tmpdir",
+		"",
+		"Directory for temporary files (defaults to the output's directory for written files and the system temp directory for piped data)",
+	)
+
+	var preserveMtime bool
+	flag.BoolVar(&preserveMtime, "preserve-mtime", false, "Give the output file the modification time of the input")
+
+	var allowPartial bool
+	flag.BoolVar(
+		&allowPartial,
+		"allow-partial",
+		false,
+		"Convert what can be recovered of truncated JPEG inputs, with a warning, instead of failing",
+	)
+
+	var strict bool
+	flag.BoolVar(&strict, "strict", false, "Fail instead of warning when the output would be degraded")
+
+	var premultiply bool
+	flag.BoolVar(
+		&premultiply,
+		"premultiply",
+		true,
+		"Composite with premultiplied alpha; false keeps straight alpha, which is more exact for faint PNG transparency",
+	)
+
+	var seed int64
+	flag.Int64Var(&seed, "seed", defaultSeed, "Seed for randomized steps such as --palette clustering")
+
+	var fileMode string
+	flag.StringVar(&fileMode, "mode", "0644", "Permissions of the output file in octal (subject to umask)")
+
+	var placeholder string
+	flag.StringVar(
+		&placeholder,
+		"placeholder",
+		"",
+		"Generate a solid-color image of the given size (WIDTHxHEIGHT) instead of converting",
+	)
+
+	var paletteSize int
+	flag.IntVar(
+		&paletteSize,
+		"palette",
+		0,
+		"Print the N most dominant colors of the input instead of converting",
+	)
+
+	var compare bool
+	flag.BoolVar(&compare, "compare", false, "Compare two images and write a visualization of their differences")
+
+	var compareThreshold int
+	flag.IntVar(
+		&compareThreshold,
+		"compare-threshold",
+		0,
+		"Per-channel difference (0 to 255) tolerated before --compare counts a pixel as differing",
+	)
+
+	var compareResize bool
+	flag.BoolVar(&compareResize, "compare-resize", false, "Resize the second image to match the first when comparing")
+
+	var extractAlphaFile string
+	flag.StringVar(
+		&extractAlphaFile,
+		"extract-alpha",
+		"",
+		"Write the input's alpha channel to this file as a grayscale PNG",
+	)
+
+	var extractThumbnailFile string
+	flag.StringVar(
+		&extractThumbnailFile,
+		"extract-thumbnail",
+		"",
+		"Write the input's embedded EXIF thumbnail, or a downscaled one if it has none, to this JPEG file",
+	)
+
+	var contactSheet string
+	flag.StringVar(
+		&contactSheet,
+		"contact-sheet",
+		"",
+		"Arrange thumbnails of all inputs into a COLSxROWS grid written to the last file name",
+	)
+
+	var split string
+	flag.StringVar(
+		&split,
+		"split",
+		"",
+		"Cut the input into a COLSxROWS grid of at most 10000 images, named by an output template with {r} and {c}",
+	)
+
+	var splitCell string
+	flag.StringVar(&splitCell, "split-cell", "", "Like --split, but cut the input into cells of WIDTHxHEIGHT")
+
+	var normalizeOrientation bool
+	flag.BoolVar(
+		&normalizeOrientation,
+		"normalize-orientation",
+		false,
+		"Rotate the JPEGs in the given directories upright in place and reset their EXIF orientation",
+	)
+
+	var inputList string
+	flag.StringVar(&inputList, "input-list", "", "Convert every file listed in this file, one path per line, into --out-dir")
+
+	var outDir string
+	flag.StringVar(&outDir, "out-dir", "", "Directory --input-list writes its output files to")
+
+	var recursive bool
+	flag.BoolVarP(&recursive, "recursive", "r", false, "Also convert the images in subdirectories when the input is a directory")
+
+	var maxMemory int
+	flag.IntVar(&maxMemory, "max-memory", 0, "Limit the estimated memory of batch conversions running at once, in MB (0 for no limit)")
+
+	var jobCount int
+	flag.IntVar(&jobCount, "jobs", runtime.NumCPU(), "Number of batch conversions to run at once; 1 converts the files one by one, in order")
+
+	var slugifyNames bool
+	flag.BoolVar(
+		&slugifyNames,
+		"slugify",
+		false,
+		"Make batch output names web-safe: lowercase, no accents, dashes for spaces, numbered when they collide",
+	)
+
+	var densities string
+	flag.StringVar(
+		&densities,
+		"densities",
+		"",
+		"Write one output per pixel density, e.g. 1x,2x,3x, named like icon.png, icon@2x.png (--resize sets the 1x size)",
+	)
+
+	var allowUpscale bool
+	flag.BoolVar(&allowUpscale, "allow-upscale", false, "Let --densities enlarge a source too small for the highest density")
+
+	var contactCell string
+	flag.StringVar(&contactCell, "cell-size", "200x200", "Size of each contact sheet thumbnail as WIDTHxHEIGHT")
+
+	var contactGap int
+	flag.IntVar(&contactGap, "cell-gap", 10, "Background pixels between contact sheet cells")
+
+	var contactLabels bool
+	flag.BoolVar(&contactLabels, "cell-labels", false, "Label contact sheet cells with their file names")
+
+	var contactLabelColor string
+	flag.StringVar(&contactLabelColor, "cell-label-color", "black", "Color of contact sheet labels")
+
+	var info bool
+	flag.BoolVar(&info, "info", false, "Print the format, dimensions, color details and metadata of the input, without converting")
+
+	var histogram bool
+	flag.BoolVar(&histogram, "histogram", false, "Render the RGB and luminance histogram of the input as a PNG chart")
+
+	var histogramSize string
+	flag.StringVar(&histogramSize, "size", "512x300", "Size of the --histogram chart as WIDTHxHEIGHT")
+
+	var validate bool
+	flag.BoolVar(&validate, "validate", false, "Check that the input decodes fully and describe it, without writing anything")
+
+	var paletteFile string
+	flag.StringVar(
+		&paletteFile,
+		"palette-file",
+		"",
+		"File with one hex color per line to use as the exact palette of GIF and PNG output",
+	)
+
+	var quantize string
+	flag.StringVar(
+		&quantize,
+		"quantize",
+		"",
+		"Fit a palette to the image for GIF and indexed PNG output (median-cut, octree or kmeans)",
+	)
+
+	var dither bool
+	flag.BoolVar(&dither, "dither", true, "Dither when reducing colors to a palette")
+
+	var frameDelay int
+	flag.IntVar(&frameDelay, "delay", 100, "Milliseconds each still image is shown in an animated GIF (animated WebP inputs keep their own timing)")
+
+	var loopCount int
+	flag.IntVar(&loopCount, "loop", 0, "Times an animated GIF repeats after playing once (0 forever, -1 never)")
+
+	var gifGlobalPalette bool
+	flag.BoolVar(
+		&gifGlobalPalette,
+		"gif-global-palette",
+		false,
+		"Reduce all frames of an animated GIF to one palette computed from every frame",
+	)
+
+	var gifOptimize bool
+	flag.BoolVar(
+		&gifOptimize,
+		"gif-optimize",
+		false,
+		"Use a global palette and store only the changed region of each animated GIF frame",
+	)
+
+	var gifTransparent string
+	flag.StringVar(&gifTransparent, "gif-transparent", "", "Make pixels of exactly this color transparent in GIF output")
+
+	var gifBackground string
+	flag.StringVar(&gifBackground, "gif-background", "", "Color of the logical screen background recorded in GIF output")
+
+	var pipeline string
+	flag.StringVar(
+		&pipeline,
+		"pipeline",
+		"",
+		"Comma separated order of the transform stages, e.g. resize,grayscale,pad",
+	)
+
+	var explain bool
+	flag.BoolVar(&explain, "explain", false, "Print the stages a conversion would run, in order, without converting")
+
+	var jsonOutput bool
+	flag.BoolVar(&jsonOutput, "json", false, "Print reports as JSON")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(os.Stderr, webOptimizedHelp)
+		fmt.Fprint(os.Stderr, exitCodesHelp)
+	}
+
+	flag.Parse()
+
+	args := flag.Args()
+
+	if maxOutputSize < 0 {
+		fatalUsage("invalid max output size: must not be negative")
+	}
+
+	if fitKB < 0 {
+		fatalUsage("invalid --scale-to-fit-bytes: must not be negative")
+	}
+	if fitKB > 0 && maxOutputSize > 0 {
+		fatalUsage("--scale-to-fit-bytes cannot be used with --max-output-size")
+	}
+
+	if frameDelay < 0 {
+		fatalUsage("invalid delay: must not be negative")
+	}
+
+	if loopCount < -1 {
+		fatalUsage("invalid loop count: must be -1 or more")
+	}
+
+	if checkerSize <= 0 {
+		fatalUsage("invalid checker size: must be positive")
+	}
+
+	parsedBackground, err := parseBackground(bgColor, gradientDirection, checkerSize)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	var parsedEdges [4]color.Color
+	for i, edgeColor := range edgeColors {
+		if edgeColor == "" {
+			continue
+		}
+
+		parsedEdges[i], err = parseBackgroundColor(edgeColor)
+		if err != nil {
+			fatalUsage(err)
+		}
+	}
+
+	var parsedMatte Background
+	if matte != "" {
+		parsedMatte, err = parseBackground(matte, gradientDirection, checkerSize)
+		if err != nil {
+			fatalUsage(err)
+		}
+	}
+
+	parsedPadding, err := convert.ParsePadding(padding)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	parsedRadius, err := parseRadius(radius)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	var resizeSize Size
+	var onlyEnlarge bool
+	if resize != "" {
+		geometry, err := parseGeometry(resize)
+		if err != nil {
+			fatalUsage(err)
+		}
+
+		if geometry.size.isPartial() && keepAspect != "" {
+			fatalUsage("--keep-aspect needs both --resize dimensions, as a single one already keeps the aspect ratio")
+		}
+		if geometry.modifier && keepAspect != "" {
+			fatalUsage("--keep-aspect cannot be combined with a --resize modifier")
+		}
+		if geometry.modifier && flag.CommandLine.Changed("scale") {
+			fatalUsage("--resize and --scale cannot be used together")
+		}
+
+		resizeSize = geometry.size
+		if geometry.modifier {
+			keepAspect = geometry.keepAspect
+			noUpscale = noUpscale || geometry.noUpscale
+			onlyEnlarge = geometry.onlyEnlarge
+		}
+		if geometry.scale > 0 {
+			scale, resize = geometry.scale, ""
+		}
+	}
+
+	if keepAspect != "" {
+		if err := parseKeepAspect(keepAspect); err != nil {
+			fatalUsage(err)
+		}
+
+		if resize == "" {
+			fatalUsage("--keep-aspect requires --resize")
+		}
+	}
+
+	if flag.CommandLine.Changed("scale") {
+		if scale <= 0 {
+			fatalUsage("invalid scale factor: must be greater than 0")
+		}
+
+		if resize != "" {
+			fatalUsage("--resize and --scale cannot be used together")
+		}
+	}
+
+	var smartCropSize Size
+	if smartCropStr != "" {
+		width, height, err := parseDimensions(smartCropStr)
+		if err != nil {
+			fatalUsage(err)
+		}
+		if flag.CommandLine.Changed("resize") || flag.CommandLine.Changed("scale") {
+			fatalUsage("--smart-crop cannot be used with --resize or --scale")
+		}
+		smartCropSize = Size{width: width, height: height}
+	}
+
+	if tmpDir != "" {
+		if info, err := os.Stat(tmpDir); err != nil {
+			fatal(err)
+		} else if !info.IsDir() {
+			fatalUsage(fmt.Sprintf("invalid --tmpdir %q: not a directory", tmpDir))
+		}
+	}
+
+	if embedSourceTime {
+		if !embedSource {
+			fatalUsage("--embed-source-time requires --embed-source")
+		}
+		if reproducible {
+			fatalUsage("--embed-source-time cannot be used with --reproducible")
+		}
+	}
+
+	parsedMode, err := parseFileMode(fileMode)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	var parsedChromaKey color.Color
+	if chromaKey != "" {
+		parsedChromaKey, err = parseBackgroundColor(chromaKey)
+		if err != nil {
+			fatalUsage(err)
+		}
+	}
+
+	if chromaTolerance < 0 || chromaFeather < 0 {
+		fatalUsage("invalid chroma key tolerance: must not be negative")
+	}
+
+	var parsedShadow *Shadow
+	if shadow {
+		if !(shadowBlur >= 0 && shadowBlur <= maxShadowBlur) {
+			fatalUsage(fmt.Sprintf("invalid shadow blur %g: must be between 0 and %d", shadowBlur, maxShadowBlur))
+		}
+
+		if shadowOpacity < 0 || shadowOpacity > 1 {
+			fatalUsage("invalid shadow opacity: must be between 0 and 1")
+		}
+
+		offset, err := parseOffset(shadowOffset)
+		if err != nil {
+			fatalUsage("shadow:", err)
+		}
+
+		parsedColor, err := parseBackgroundColor(shadowColor)
+		if err != nil {
+			fatalUsage("shadow:", err)
+		}
+
+		parsedShadow = &Shadow{
+			color:   parsedColor,
+			opacity: shadowOpacity,
+			blur:    shadowBlur,
+			offset:  offset,
+		}
+	}
+
+	if flag.CommandLine.Changed("posterize") && (posterize < 2 || posterize > 256) {
+		fatalUsage("invalid posterize levels: must be between 2 and 256")
+	}
+
+	if (rotateFit || rotateCrop) && !flag.CommandLine.Changed("rotate-exact") && !deskew {
+		fatalUsage("--rotate-fit and --rotate-crop require --rotate-exact or --deskew")
+	}
+
+	if rotateLossless && !isRightAngle(math.Mod(rotate, 360)) {
+		fatalUsage("--rotate-lossless requires --rotate-exact 90, 180 or 270")
+	}
+
+	if rotateFit && rotateCrop {
+		fatalUsage("--rotate-fit and --rotate-crop cannot be used together")
+	}
+
+	if pixelate < 0 {
+		fatalUsage("invalid pixelate block size: must not be negative")
+	}
+
+	var parsedPixelateRegion *image.Rectangle
+	if pixelateRegion != "" {
+		if pixelate == 0 {
+			fatalUsage("--pixelate-region requires --pixelate")
+		}
+
+		region, err := parseRegion(pixelateRegion)
+		if err != nil {
+			fatalUsage("pixelate:", err)
+		}
+		parsedPixelateRegion = &region
+	}
+
+	parsedThreshold, autoThreshold := -1, false
+	if thresholdStr != "" {
+		parsedThreshold, autoThreshold, err = parseThreshold(thresholdStr)
+		if err != nil {
+			fatalUsage(err)
+		}
+	}
+
+	if opacity < 0 || opacity > 1 {
+		fatalUsage("invalid opacity: must be between 0 and 1")
+	}
+
+	if vignette < 0 || vignette > 1 {
+		fatalUsage("invalid vignette strength: must be between 0 and 1")
+	}
+
+	parsedGrayscaleMethod, err := parseGrayscaleMethod(grayscaleMethod)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	var parsedDuotone *[2]color.Color
+	if duotoneColors != "" {
+		shadow, highlight, err := parseDuotone(duotoneColors)
+		if err != nil {
+			fatalUsage(err)
+		}
+		parsedDuotone = &[2]color.Color{shadow, highlight}
+	}
+
+	var parsedTint color.Color
+	if tintColor != "" {
+		parsedTint, err = parseBackgroundColor(tintColor)
+		if err != nil {
+			fatalUsage("tint:", err)
+		}
+	}
+
+	if tintAmount < 0 || tintAmount > 1 {
+		fatalUsage("invalid tint amount: must be between 0 and 1")
+	}
+
+	parsedTintMode, err := parseTintMode(tintMode)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	var parsedInFormat string
+	if inFormat != "" {
+		parsedInFormat, err = parseFormat(inFormat, decoders)
+		if err != nil {
+			fatalUsage("input:", err)
+		}
+	}
+
+	rawFlags := flag.CommandLine.Changed("raw-width") || flag.CommandLine.Changed("raw-height") ||
+		flag.CommandLine.Changed("raw-channels")
+	if parsedInFormat == "raw" || rawFlags {
+		if parsedInFormat != "raw" {
+			fatalUsage("--raw-width, --raw-height and --raw-channels require --in-format raw")
+		}
+		if rawWidth <= 0 || rawHeight <= 0 {
+			fatalUsage("--in-format raw requires a positive --raw-width and --raw-height")
+		}
+		if err := parseRawChannels(rawChannelCount); err != nil {
+			fatalUsage(err)
+		}
+	}
+
+	parsedRawOrder, err := parseRawOrder(rawOrder)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	if rawDepth != 8 && rawDepth != 16 {
+		fatalUsage("invalid raw depth: must be 8 or 16")
+	}
+
+	var parsedOutFormat string
+	if outFormat != "" {
+		parsedOutFormat, err = parseFormat(outFormat, encoders)
+		if err != nil {
+			fatalUsage("output:", err)
+		}
+
+		if autoFormat {
+			fatalUsage("--auto-format and --out-format cannot be used together")
+		}
+	}
+
+	parsedDatestampPos, err := parseDatestampPosition(datestampPos)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	parsedDatestampColor, err := parseBackgroundColor(datestampColor)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	parsedTextPos, err := parseTextPosition(textPos)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	parsedTextColor, err := parseBackgroundColor(textColor)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	var parsedTextBox color.Color
+	if textBox != "" {
+		parsedTextBox, err = parseBackgroundColor(textBox)
+		if err != nil {
+			fatalUsage(err)
+		}
+	}
+
+	if textSize <= 0 {
+		fatalUsage("invalid text size: must be positive")
+	}
+
+	cellWidth, cellHeight, err := parseDimensions(contactCell)
+	if err != nil {
+		fatalUsage("cell size:", err)
+	}
+
+	if contactGap < 0 {
+		fatalUsage("invalid cell gap: must not be negative")
+	}
+
+	parsedLabelColor, err := parseBackgroundColor(contactLabelColor)
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	if compareThreshold < 0 || compareThreshold > 255 {
+		fatalUsage("invalid compare threshold: must be between 0 and 255")
+	}
+
+	var tileSize Size
+	if tile != "" {
+		width, height, err := parseDimensions(tile)
+		if err != nil {
+			fatalUsage(err)
+		}
+
+		tileSize = Size{width: width, height: height}
+	}
+
+	if backgroundImage != "" {
+		// The background keeps its own extension-derived format even when
+		// --in-format overrides the input's.
+		bgImg, err := readImage(backgroundImage, &Config{})
+		if err != nil {
+			fatal(fmt.Errorf("background image: %w", err))
+		}
+
+		parsedBackground = imageBackground{img: bgImg}
+	}
+
+	var parsedGIFTransparent color.Color
+	if gifTransparent != "" {
+		parsedGIFTransparent, err = parseBackgroundColor(gifTransparent)
+		if err != nil {
+			fatalUsage("gif transparent color:", err)
+		}
+
+		if gifOptimize {
+			fatalUsage("--gif-transparent cannot be used with --gif-optimize")
+		}
+	}
+
+	var parsedGIFBackground color.Color
+	if gifBackground != "" {
+		parsedGIFBackground, err = parseBackgroundColor(gifBackground)
+		if err != nil {
+			fatalUsage("gif background color:", err)
+		}
+	}
+
+	if quantize != "" {
+		if paletteFile != "" {
+			fatalUsage("--quantize and --palette-file cannot be used together")
+		}
+
+		quantize, err = parseQuantizeMethod(quantize)
+		if err != nil {
+			fatalUsage(err)
+		}
+	}
+
+	var parsedPalette color.Palette
+	if paletteFile != "" {
+		parsedPalette, err = readPaletteFile(paletteFile)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	parsedQuality, autoQuality, qualityErr := parseQuality(quality)
+	if qualityErr != nil && !errors.Is(qualityErr, errQualityRange) {
+		fatalUsage(qualityErr)
+	}
+
+	parsedAVIFQuality := -1
+	if flag.CommandLine.Changed("avif-quality") {
+		parsedAVIFQuality = max(0, min(100, avifQuality))
+	}
+
+	config := &Config{
+		inFormat:  parsedInFormat,
+		raw:       rawLayout{width: rawWidth, height: rawHeight, channels: rawChannelCount},
+		rawOrder:  parsedRawOrder,
+		rawDepth:  rawDepth,
+		outFormat: parsedOutFormat,
+
+		bgColor:    backgroundColor(parsedBackground),
+		background: parsedBackground,
+		matte:      parsedMatte,
+		edgeColors: EdgeColors{
+			top:    parsedEdges[0],
+			right:  parsedEdges[1],
+			bottom: parsedEdges[2],
+			left:   parsedEdges[3],
+		},
+		padding:     parsedPadding,
+		square:      square,
+		radius:      parsedRadius,
+		shadow:      parsedShadow,
+		quality:     parsedQuality,
+		avifQuality: parsedAVIFQuality,
+
+		webOptimized: webOptimized,
+		interlace:    interlace || webOptimized && !flag.CommandLine.Changed("interlace"),
+		autoQuality:  autoQuality,
+		verbose:      verbose,
+		resize:       resizeSize,
+		smartCrop:    smartCropSize,
+		scale:        scale,
+		noUpscale:    noUpscale,
+		onlyEnlarge:  onlyEnlarge,
+		keepAspect:   keepAspect,
+		tile:         tileSize,
+		fileMode:     parsedMode,
+		premultiply:  premultiply,
+		strict:       strict,
+
+		allowPartial: allowPartial,
+
+		maxOutputSize: int64(maxOutputSize) * 1024,
+		fitBytes:      int64(fitKB) * 1024,
+
+		preserveMtime: preserveMtime,
+		tmpDir:        tmpDir,
+
+		seed: seed,
+
+		reproducible: reproducible,
+
+		embedSource:     embedSource,
+		embedSourceTime: embedSourceTime,
+
+		dataURI: dataURI,
+
+		keepMetadata: keepMetadata || stripGPS,
+		stripGPS:     stripGPS,
+		autoOrient:   autoOrient,
+
+		autoFormatColors: autoFormatColors,
+
+		chromaKey:       parsedChromaKey,
+		chromaTolerance: chromaTolerance,
+		chromaFeather:   chromaFeather,
+
+		grayscale:       grayscale,
+		grayscaleMethod: parsedGrayscaleMethod,
+
+		duotone: parsedDuotone,
+
+		tint:       parsedTint,
+		tintAmount: tintAmount,
+		tintMode:   parsedTintMode,
+
+		posterize: posterize,
+		vignette:  vignette,
+		opacity:   opacity,
+
+		rotate:     math.Mod(rotate, 360),
+		rotateCrop: rotateCrop,
+
+		rotateLossless: rotateLossless,
+		deskew:         deskew,
+
+		trimTransparent: trimTransparent,
+
+		threshold:     parsedThreshold,
+		autoThreshold: autoThreshold,
+
+		pixelate:       pixelate,
+		pixelateRegion: parsedPixelateRegion,
+
+		datestamp:      datestamp,
+		datestampPos:   parsedDatestampPos,
+		datestampColor: parsedDatestampColor,
+
+		text:      text,
+		textPos:   parsedTextPos,
+		textColor: parsedTextColor,
+		textSize:  textSize,
+		textBox:   parsedTextBox,
+		textFont:  textFont,
+
+		compareThreshold: compareThreshold,
+
+		contactCell:       Size{width: cellWidth, height: cellHeight},
+		contactGap:        contactGap,
+		contactLabels:     contactLabels,
+		contactLabelColor: parsedLabelColor,
+
+		palette: parsedPalette,
+		dither:  dither,
+
+		quantize: quantize,
+
+		frameDelay: frameDelay,
+		loopCount:  loopCount,
+
+		gifGlobalPalette: gifGlobalPalette,
+		gifOptimize:      gifOptimize,
+		gifTransparent:   parsedGIFTransparent,
+		gifBackground:    parsedGIFBackground,
+	}
+
+	// An out of range --quality is only an error when the output may use it.
+	if qualityErr != nil {
+		format := plannedOutputFormat(args, parsedOutFormat, autoFormat)
+		if format == "" || usesQuality(format) {
+			fatalUsage(qualityErr)
+		}
+		if err := warn(config, "ignoring --quality %s, %s output does not use it", quality, format); err != nil {
+			fatal(err)
+		}
+	}
+
+	if scale > 1 && !noUpscale {
+		if err := warn(config, "scaling by %g enlarges the image; pass --no-upscale to prevent it", scale); err != nil {
+			fatal(err)
+		}
+	}
+
+	if pipeline != "" {
+		config.pipeline, err = parsePipeline(pipeline, config)
+		if err != nil {
+			fatalUsage(err)
+		}
+	}
+
+	if placeholder != "" {
+		if len(args) != 1 {
+			fatalUsage("must provide only the output file name when generating a placeholder")
+		}
+
+		outFile := args[0]
+
+		width, height, err := parseDimensions(placeholder)
+		if err != nil {
+			fatalUsage(err)
+		}
+
+		if err := createPlaceholder(outFile, width, height, config); err != nil {
+			fatal(err)
+		}
+
+		fmt.Println("Placeholder created:", outFile)
+		return
+	}
+
+	if paletteSize != 0 {
+		if len(args) != 1 {
+			fatalUsage("must provide only the input file name when extracting a palette")
+		}
+
+		if err := printPalette(args[0], paletteSize, jsonOutput, config); err != nil {
+			fatal(err)
+		}
+
+		return
+	}
+
+	if validate {
+		if len(args) != 1 {
+			fatalUsage("must provide only the input file name when validating")
+		}
+		if allowPartial {
+			fatalUsage("--allow-partial cannot be used with --validate")
+		}
+
+		if err := printValidation(args[0], jsonOutput, config); err != nil {
+			fatal(err)
+		}
+
+		return
+	}
+
+	if info {
+		if len(args) != 1 {
+			fatalUsage("must provide only the input file name for --info")
+		}
+
+		if err := printInfo(args[0], jsonOutput, config); err != nil {
+			fatal(err)
+		}
+
+		return
+	}
+
+	if histogram {
+		if len(args) != 2 {
+			fatalUsage("must provide the input file name and a chart output file name for a histogram")
+		}
+
+		width, height, err := parseDimensions(histogramSize)
+		if err != nil {
+			fatalUsage("histogram:", err)
+		}
+
+		if err := writeHistogram(args[0], args[1], Size{width: width, height: height}, config); err != nil {
+			fatal(err)
+		}
+
+		fmt.Println("Histogram written:", args[1])
+		return
+	}
+
+	if extractAlphaFile != "" {
+		if len(args) == 0 || len(args) > 2 {
+			fatalUsage("must provide the input file name, and optionally an output file name, when extracting alpha")
+		}
+
+		if err := extractAlpha(args[0], extractAlphaFile, config); err != nil {
+			fatal(err)
+		}
+
+		fmt.Println("Alpha mask written:", extractAlphaFile)
+
+		if len(args) == 1 {
+			return
+		}
+	}
+
+	if extractThumbnailFile != "" {
+		if len(args) == 0 || len(args) > 2 {
+			fatalUsage("must provide the input file name, and optionally an output file name, when extracting a thumbnail")
+		}
+
+		embedded, err := extractThumbnail(args[0], extractThumbnailFile, config)
+		if err != nil {
+			fatal(err)
+		}
+
+		if embedded {
+			fmt.Println("Embedded thumbnail written:", extractThumbnailFile)
+		} else {
+			fmt.Println("Generated thumbnail written:", extractThumbnailFile)
+		}
+
+		if len(args) == 1 {
+			return
+		}
+	}
+
+	if contactSheet != "" {
+		if len(args) < 2 {
+			fatalUsage("must provide at least one input file name and an output file name for a contact sheet")
+		}
+
+		cols, rows, err := parseDimensions(contactSheet)
+		if err != nil {
+			fatalUsage("contact sheet:", err)
+		}
+
+		outFile := args[len(args)-1]
+		grid := Size{width: cols, height: rows}
+
+		if err := createContactSheet(args[:len(args)-1], outFile, grid, config); err != nil {
+			fatal(err)
+		}
+
+		fmt.Println("Contact sheet created:", outFile)
+		return
+	}
+
+	if split != "" || splitCell != "" {
+		if split != "" && splitCell != "" {
+			fatalUsage("--split and --split-cell cannot be used together")
+		}
+
+		if len(args) != 2 {
+			fatalUsage("must provide the input file name and an output name template when splitting")
+		}
+
+		var grid, cell Size
+		if split != "" {
+			cols, rows, err := parseDimensions(split)
+			if err != nil {
+				fatalUsage("split:", err)
+			}
+			if cols*rows > maxSplitTiles {
+				fatalUsage(fmt.Sprintf("invalid split %q: %d files is more than the limit of %d", split, cols*rows, maxSplitTiles))
+			}
+			grid = Size{width: cols, height: rows}
+		} else {
+			width, height, err := parseDimensions(splitCell)
+			if err != nil {
+				fatalUsage("split cell:", err)
+			}
+			cell = Size{width: width, height: height}
+		}
+
+		written, err := splitImage(args[0], args[1], grid, cell, config)
+		if err != nil {
+			fatal(err)
+		}
+
+		fmt.Printf("Split into %d images: %s\n", written, args[1])
+		return
+	}
+
+	if densities != "" {
+		if len(args) != 2 {
+			fatalUsage("must provide both input file and output file names for --densities")
+		}
+		if flag.CommandLine.Changed("scale") {
+			fatalUsage("--densities cannot be used with --scale, use --resize for the 1x size")
+		}
+		if autoFormat || dataURI {
+			fatalUsage("--densities cannot be used with --auto-format or --data-uri")
+		}
+
+		parsedDensities, err := parseDensities(densities)
+		if err != nil {
+			fatalUsage(err)
+		}
+
+		fmt.Println("Converting:", args[0])
+
+		written, err := convertDensities(args[0], args[1], parsedDensities, allowUpscale, config)
+		if err != nil {
+			fatal(err)
+		}
+
+		fmt.Println("Images converted:", strings.Join(written, ", "))
+		return
+	}
+
+	if allowUpscale {
+		fatalUsage("--allow-upscale requires --densities")
+	}
+
+	if compare {
+		if len(args) != 3 {
+			fatalUsage("must provide two input file names and a diff output file name when comparing")
+		}
+
+		result, err := compareFiles(args[0], args[1], args[2], compareResize, config)
+		if err != nil {
+			fatal(err)
+		}
+
+		fmt.Printf("%.2f%% of pixels differ, mean error %.2f\n", result.percent(), result.meanError)
+		fmt.Println("Diff written:", args[2])
+		return
+	}
+
+	if jobCount < 1 {
+		fatalUsage("invalid jobs: must be at least 1")
+	}
+
+	if normalizeOrientation {
+		if len(args) == 0 {
+			fatalUsage("--normalize-orientation requires at least one directory")
+		}
+		if inputList != "" || outDir != "" || dataURI || autoFormat {
+			fatalUsage("--normalize-orientation rewrites files in place and cannot be used with --input-list, --out-dir, --data-uri or --auto-format")
+		}
+
+		var jobs []batchJob
+		total := 0
+		for _, dir := range args {
+			dirJobs, dirTotal, err := orientationJobs(dir, config)
+			if err != nil {
+				fatal(err)
+			}
+			jobs, total = append(jobs, dirJobs...), total+dirTotal
+		}
+
+		if !jsonOutput {
+			fmt.Printf("%d of %d JPEGs need rotation\n", len(jobs), total)
+		}
+		if len(jobs) == 0 && !jsonOutput {
+			return
+		}
 
-func main() {
-	var bgColor string
-	flag.StringVarP(
-		&bgColor,
-		"background",
-		"b",
-		"white",
-		"Determines the background color for jpeg files",
-	)
+		// The EXIF metadata is kept, --strip-gps aside, with the orientation
+		// reset by exifSegment.
+		config.autoOrient, config.keepMetadata, config.inPlace = true, true, true
 
-	var padding string
-	flag.StringVarP(&padding, "padding", "p", "", "Configure image padding")
+		summary := runBatch(jobs, jobCount, false, false, int64(maxMemory)<<20, jsonOutput, config)
+		if jsonOutput {
+			if err := summary.print(true); err != nil {
+				fatal(err)
+			}
+		} else {
+			fmt.Printf("Normalized %d of %d files\n", len(jobs)-summary.Failed, total)
+		}
+		if summary.Failed > 0 {
+			fatal(fmt.Errorf("%d of %d conversions failed", summary.Failed, len(jobs)))
+		}
+		return
+	}
 
-	var quality int
-	flag.IntVarP(&quality, "quality", "q", 50, "Defines the quality of the compression (0 to 100)")
+	if inputList != "" {
+		if len(args) != 0 {
+			fatalUsage("--input-list takes no file name arguments")
+		}
+		if outDir == "" {
+			fatalUsage("--input-list requires --out-dir")
+		}
+		if dataURI {
+			fatalUsage("--input-list cannot be used with --data-uri")
+		}
+		if maxMemory < 0 {
+			fatalUsage("invalid max memory: must not be negative")
+		}
 
-	flag.Parse()
+		inFiles, err := readInputList(inputList)
+		if err != nil {
+			fatal(err)
+		}
 
-	args := flag.Args()
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			fatal(err)
+		}
 
-	if len(args) != 2 {
-		log.Fatalln("must provide both input file and output file names")
+		jobs := make([]batchJob, len(inFiles))
+		for i, inFile := range inFiles {
+			jobs[i] = batchJob{inputFile: inFile, outputFile: batchOutputFile(inFile, outDir, autoFormat, slugifyNames, config)}
+		}
+		if slugifyNames {
+			numberDuplicates(jobs, autoFormat)
+		}
+
+		convertBatch(jobs, outDir, jobCount, autoFormat, onlyIfSmaller, int64(maxMemory)<<20, jsonOutput, config)
+		return
 	}
 
-	inFile := args[0]
-	outFile := args[1]
+	if len(args) == 2 && isDir(args[0]) {
+		if outDir != "" {
+			fatalUsage("--out-dir cannot be used with an input directory, the output directory is the second argument")
+		}
+		if dataURI {
+			fatalUsage("an input directory cannot be converted with --data-uri")
+		}
+		if maxMemory < 0 {
+			fatalUsage("invalid max memory: must not be negative")
+		}
 
-	fmt.Println("Converting:", inFile)
+		if err := os.MkdirAll(args[1], 0o755); err != nil {
+			fatal(err)
+		}
 
-	parsedColor, err := parseBackgroundColor(bgColor)
-	if err != nil {
-		log.Fatalln(err)
+		jobs, skipped, err := dirJobs(args[0], args[1], recursive, autoFormat, slugifyNames, config)
+		if err != nil {
+			fatal(err)
+		}
+		if skipped > 0 && !jsonOutput {
+			fmt.Printf("Skipping %d files that are not images\n", skipped)
+		}
+
+		convertBatch(jobs, args[1], jobCount, autoFormat, onlyIfSmaller, int64(maxMemory)<<20, jsonOutput, config)
+		return
 	}
 
-	parsedPadding, err := parsePadding(padding)
-	if err != nil {
-		log.Fatalln(err)
+	if recursive {
+		fatalUsage("--recursive requires an input directory")
 	}
 
-	config := &Config{
-		bgColor: parsedColor,
-		padding: *parsedPadding,
-		quality: max(0, min(100, quality)),
+	if outDir != "" {
+		fatalUsage("--out-dir requires --input-list")
 	}
 
-	if err := convertImage(inFile, outFile, config); err != nil {
-		log.Fatalln(err)
+	if slugifyNames {
+		fatalUsage("--slugify requires --input-list or an input directory")
 	}
 
-	fmt.Println("Image converted:", outFile)
-}
+	if maxMemory != 0 {
+		fatalUsage("--max-memory requires --input-list, an input directory or --normalize-orientation")
+	}
+
+	if flag.CommandLine.Changed("jobs") {
+		fatalUsage("--jobs requires --input-list, an input directory or --normalize-orientation")
+	}
+
+	if len(args) > 2 {
+		inFiles := args[:len(args)-1]
+		outFile := args[len(args)-1]
+
+		fmt.Println("Assembling:", strings.Join(inFiles, ", "))
+
+		run, created := convertPages, "Document created:"
+		if outputFormat(outFile, config) == "gif" {
+			run, created = convertAnimation, "Animation created:"
+		}
 
-func parsePadding(paddingStr string) (*Padding, error) {
-	paddings := strings.Split(paddingStr, ",")
-	pdArgs := len(paddings)
+		if err := run(inFiles, outFile, config); err != nil {
+			fatal(err)
+		}
 
-	if paddingStr == "" {
-		pdArgs = 0
+		fmt.Println(created, outFile)
+		return
 	}
 
-	switch pdArgs {
-	case 0:
-		return &Padding{
-			top:    0,
-			right:  0,
-			bottom: 0,
-			left:   0,
-		}, nil
-	case 1:
-		padding, err := strconv.Atoi(paddings[0])
-		if err != nil {
-			return nil, fmt.Errorf("parse padding: %w", err)
+	// With a format to write, a missing output means standard output and
+	// missing input and output mean a pipe from standard input.
+	switch {
+	case len(args) == 1 && (dataURI || config.outFormat != ""):
+		args = append(args, "-")
+	case len(args) == 0 && config.outFormat != "":
+		args = []string{"-", "-"}
+	}
+
+	if len(args) != 2 {
+		fatalUsage("must provide both input file and output file names, or --out-format to write to standard output")
+	}
+
+	inFile := args[0]
+	outFile := args[1]
+
+	if outFile == "-" && !dataURI {
+		if config.outFormat == "" {
+			fatalUsage("writing to standard output requires --out-format")
 		}
+		if autoFormat || onlyIfSmaller {
+			fatalUsage("--auto-format and --only-if-smaller cannot be used when writing to standard output")
+		}
+	}
 
-		return &Padding{
-			top:    padding,
-			right:  padding,
-			bottom: padding,
-			left:   padding,
-		}, nil
-	case 2:
-		ypadding, err := strconv.Atoi(paddings[0])
-		if err != nil {
-			return nil, fmt.Errorf("parse vertical padding: %w", err)
+	if inFile == "-" {
+		if preserveMtime {
+			fatalUsage("--preserve-mtime cannot be used when reading standard input")
 		}
 
-		xpadding, err := strconv.Atoi(paddings[1])
+		spooled, dir, err := spoolStdin(config)
 		if err != nil {
-			return nil, fmt.Errorf("parse horizontal padding: %w", err)
+			fatal(err)
 		}
+		tempDirs = append(tempDirs, dir)
+		defer os.RemoveAll(dir)
 
-		return &Padding{
-			top:    ypadding,
-			right:  xpadding,
-			bottom: ypadding,
-			left:   xpadding,
-		}, nil
-	case 4:
-		tpadding, err := strconv.Atoi(paddings[0])
-		if err != nil {
-			return nil, fmt.Errorf("parse top padding: %w", err)
+		inFile = spooled
+	}
+
+	if dataURI && config.outFormat == "" && detectFormat(outFile) == "unknown" {
+		config.outFormat = inputFormat(inFile, config)
+	}
+
+	if onlyIfSmaller && dataURI {
+		fatalUsage("--only-if-smaller cannot be used with --data-uri")
+	}
+
+	if explain {
+		if err := explainPipeline(os.Stdout, inFile, outFile, autoFormat, config); err != nil {
+			fatal(err)
 		}
 
-		rpadding, err := strconv.Atoi(paddings[1])
-		if err != nil {
-			return nil, fmt.Errorf("parse right padding: %w", err)
+		return
+	}
+
+	// The data URI or image itself is the only thing printed when writing to
+	// stdout.
+	if outFile == "-" {
+		run := convertToStdout
+		if dataURI {
+			run = func(inputFile string, config *Config) error {
+				return convertImage(inputFile, outFile, config)
+			}
+		}
+
+		if err := run(inFile, config); err != nil {
+			fatal(err)
 		}
 
-		bpadding, err := strconv.Atoi(paddings[2])
+		return
+	}
+
+	fmt.Println("Converting:", config.displayName(inFile))
+
+	outFile, err = convertFile(inFile, outFile, autoFormat, onlyIfSmaller, config)
+	if errors.Is(err, errNotSmaller) {
+		fmt.Println("Kept original:", err)
+		return
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Println("Image converted:", outFile)
+	if savings := savingsReport(outFile, config); savings != "" {
+		fmt.Println(savings)
+	}
+}
+
+// convertBatch runs the jobs of a batch into outDir and prints its summary,
+// exiting with an error if any of them failed.
+func convertBatch(jobs []batchJob, outDir string, workers int, autoFormat bool, onlyIfSmaller bool, maxMemory int64, asJSON bool, config *Config) {
+	if !asJSON {
+		fmt.Printf("Converting %d files into %s\n", len(jobs), outDir)
+	}
+
+	summary := runBatch(jobs, workers, autoFormat, onlyIfSmaller, maxMemory, asJSON, config)
+	if err := summary.print(asJSON); err != nil {
+		fatal(err)
+	}
+	if summary.Failed > 0 {
+		fatal(fmt.Errorf("%d of %d conversions failed", summary.Failed, len(jobs)))
+	}
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// convertFile converts inputFile to outputFile, or with autoFormat to
+// outputFile plus the extension of the chosen format, and returns the path
+// written. With onlyIfSmaller the output is only written, possibly replacing
+// inputFile in place, when it comes out smaller than inputFile; otherwise the
+// error wraps errNotSmaller.
+func convertFile(inputFile string, outputFile string, autoFormat bool, onlyIfSmaller bool, config *Config) (string, error) {
+	if err := warnMisnamed(inputFile, config); err != nil {
+		return "", err
+	}
+
+	if config.preserveMtime {
+		inInfo, err := os.Stat(inputFile)
 		if err != nil {
-			return nil, fmt.Errorf("parse bottom padding: %w", err)
+			return "", err
 		}
+		config.modTime = inInfo.ModTime()
+	}
 
-		lpadding, err := strconv.Atoi(paddings[3])
+	if onlyIfSmaller {
+		inInfo, err := os.Stat(inputFile)
 		if err != nil {
-			return nil, fmt.Errorf("parse left padding: %w", err)
+			return "", err
 		}
+		config.onlyIfSmaller = max(1, inInfo.Size())
 
-		return &Padding{
-			top:    tpadding,
-			right:  rpadding,
-			bottom: bpadding,
-			left:   lpadding,
-		}, nil
-	default:
-		return nil, fmt.Errorf("invalid padding")
+		if outInfo, err := os.Stat(outputFile); err == nil && !autoFormat {
+			config.inPlace = os.SameFile(inInfo, outInfo)
+		}
 	}
-}
 
-func parseBackgroundColor(colorStr string) (color.Color, error) {
-	switch strings.ToLower(colorStr) {
-	case "black":
-		return color.Black, nil
-	case "white":
-		return color.White, nil
-	case "red":
-		return color.RGBA{R: 255}, nil
-	case "green":
-		return color.RGBA{G: 255}, nil
-	case "blue":
-		return color.RGBA{B: 255}, nil
-	default:
-		return parseHexColor(colorStr)
+	if autoFormat {
+		return convertAutoFormat(inputFile, outputFile, config)
 	}
+
+	return outputFile, convertImage(inputFile, outputFile, config)
 }
 
-var hexReg = regexp.MustCompile(`\w{2}`)
+// savingsReport describes how many bytes --only-if-smaller saved by writing
+// outputFile, or returns "" when the flag is not set.
+func savingsReport(outputFile string, config *Config) string {
+	if config.onlyIfSmaller == 0 {
+		return ""
+	}
+
+	info, err := os.Stat(outputFile)
+	if err != nil {
+		return ""
+	}
 
-func parseHexColor(hexStr string) (color.Color, error) {
-	colorVals := hexReg.FindAllString(strings.TrimPrefix(hexStr, "#"), 3)
+	saved := config.onlyIfSmaller - info.Size()
+	return fmt.Sprintf("Saved %d bytes (%.1f%%)", saved, 100*float64(saved)/float64(config.onlyIfSmaller))
+}
+
+func parseDimensions(dimStr string) (int, int, error) {
+	widthStr, heightStr, ok := strings.Cut(strings.ToLower(dimStr), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid dimensions %q: expected WIDTHxHEIGHT", dimStr)
+	}
 
-	r, err := strconv.ParseInt(colorVals[0], 16, 64)
+	width, err := strconv.Atoi(widthStr)
 	if err != nil {
-		return nil, err
+		return 0, 0, fmt.Errorf("parse width: %w", err)
 	}
-	g, err := strconv.ParseInt(colorVals[1], 16, 64)
+
+	height, err := strconv.Atoi(heightStr)
 	if err != nil {
-		return nil, err
+		return 0, 0, fmt.Errorf("parse height: %w", err)
 	}
-	b, err := strconv.ParseInt(colorVals[2], 16, 64)
+
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid dimensions %q: width and height must be positive", dimStr)
+	}
+
+	return width, height, nil
+}
+
+func parseFileMode(modeStr string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(modeStr, 8, 32)
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("invalid file mode %q: expected an octal number such as 0644", modeStr)
+	}
+
+	if mode > 0o777 {
+		return 0, fmt.Errorf("invalid file mode %q: only permission bits (0000 to 0777) are allowed", modeStr)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// canvasPadding returns the padding to apply around an image with the given
+// bounds. With --keep-aspect=letterbox the image is first padded out to the
+// --resize box, and with --square the shorter side is padded further. Extra
+// padding is split evenly between both edges.
+func canvasPadding(bounds image.Rectangle, config *Config) Padding {
+	padding := config.padding
+
+	if config.keepAspect == "letterbox" {
+		dx := max(0, config.resize.width-bounds.Dx())
+		dy := max(0, config.resize.height-bounds.Dy())
+		padding.Left += dx / 2
+		padding.Right += dx - dx/2
+		padding.Top += dy / 2
+		padding.Bottom += dy - dy/2
 	}
 
-	return color.RGBA{
-		R: uint8(r),
-		G: uint8(g),
-		B: uint8(b),
-	}, err
+	if !config.square {
+		return padding
+	}
+
+	width := bounds.Dx() + padding.Left + padding.Right
+	height := bounds.Dy() + padding.Top + padding.Bottom
+
+	if width > height {
+		diff := width - height
+		padding.Top += diff / 2
+		padding.Bottom += diff - diff/2
+	} else {
+		diff := height - width
+		padding.Left += diff / 2
+		padding.Right += diff - diff/2
+	}
+
+	return padding
+}
+
+func parseBackgroundColor(colorStr string) (color.Color, error) {
+	return convert.ParseColor(colorStr)
+}
+
+func parseHexColor(hexStr string) (color.Color, error) {
+	return convert.ParseHexColor(hexStr)
 }
 
 func detectFormat(filename string) string {
@@ -208,91 +1987,405 @@ func detectFormat(filename string) string {
 		return "png"
 	case ".jpeg", ".jpg":
 		return "jpeg"
+	case ".tiff", ".tif":
+		return "tiff"
+	case ".gif":
+		return "gif"
+	case ".avif":
+		return "avif"
+	case ".heic", ".heif":
+		return "heic"
+	case ".webp":
+		return "webp"
 	default:
+		if format := strings.TrimPrefix(ext, "."); format != "" && isRegisteredFormat(format) {
+			return format
+		}
 		return "unknown"
 	}
 }
 
+// inputFormat returns the format used to decode inputFile: the --in-format
+// override, otherwise the format its content is recognized as, falling back
+// to the file extension.
+func inputFormat(inputFile string, config *Config) string {
+	if config.inFormat != "" {
+		return config.inFormat
+	}
+
+	if sniffed := sniffFile(inputFile); sniffed != "unknown" {
+		return sniffed
+	}
+
+	return detectFormat(inputFile)
+}
+
+// plannedOutputFormat returns the format every output of the command line
+// will be encoded in, as far as it is known before any input is read: the
+// --out-format override or the extension of the last argument. It returns ""
+// when the format is picked per file, as with --auto-format or an output
+// directory.
+func plannedOutputFormat(args []string, outFormat string, autoFormat bool) string {
+	switch {
+	case autoFormat:
+		return ""
+	case outFormat != "":
+		return outFormat
+	case len(args) < 2:
+		return ""
+	}
+
+	if format := detectFormat(args[len(args)-1]); format != "unknown" {
+		return format
+	}
+
+	return ""
+}
+
+// outputFormat returns the format used to encode outputFile, preferring the
+// --out-format override over the file extension.
+func outputFormat(outputFile string, config *Config) string {
+	if config.outFormat != "" {
+		return config.outFormat
+	}
+
+	return detectFormat(outputFile)
+}
+
+// parseFormat validates a format override against the names registered in
+// formats, accepting the same aliases as file extensions (jpg, tif).
+func parseFormat[F any](formatStr string, formats map[string]F) (string, error) {
+	format := detectFormat("." + strings.ToLower(formatStr))
+	if _, ok := formats[format]; ok {
+		return format, nil
+	}
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return "", fmt.Errorf("invalid format %q: expected one of %s", formatStr, strings.Join(names, ", "))
+}
+
 func convertImage(inputFile string, outputFile string, config *Config) error {
-	inputFormat := detectFormat(inputFile)
-	outputFormat := detectFormat(outputFile)
+	inFormat := inputFormat(inputFile, config)
+	outFormat := outputFormat(outputFile, config)
 
 	switch {
-	case inputFormat == "png" && outputFormat == "jpeg":
+	case inFormat == "heic" && !heicSupported:
+		return errHEICUnsupported
+	case inFormat == "png" && outFormat == "jpeg":
 		return convertPNGToJPEG(inputFile, outputFile, config)
-	case inputFormat == "jpeg" && outputFormat == "png":
+	case inFormat == "jpeg" && outFormat == "png":
 		return convertJPEGToPNG(inputFile, outputFile, config)
+	case inFormat == "png" && outFormat == "png":
+		return convertPNGToPNG(inputFile, outputFile, config)
+	case inFormat == "jpeg" && outFormat == "jpeg":
+		return convertJPEGToJPEG(inputFile, outputFile, config)
+	case outFormat == "gif" && inFormat == "webp" && isAnimatedWebPFile(inputFile):
+		return convertAnimation([]string{inputFile}, outputFile, config)
+	case outFormat == "tiff":
+		return convertPages([]string{inputFile}, outputFile, config)
+	case outFormat == "avif":
+		if !avifSupported {
+			return fmt.Errorf("%w output format: avif, rebuild with -tags avif", errUnsupported)
+		}
+		return convertRegistered(inputFile, outputFile, pngBackground(config), config)
+	case outFormat == "webp":
+		if !webpEncodeSupported {
+			return fmt.Errorf("%w output format: webp, rebuild with -tags webp", errUnsupported)
+		}
+		return convertRegistered(inputFile, outputFile, pngBackground(config), config)
+	case outFormat == "jpeg" || outFormat == "gif" || outFormat == "raw" && !rawHasAlpha(config.rawOrder):
+		// None of these has an alpha channel to keep.
+		return convertRegistered(inputFile, outputFile, config.background, config)
+	case isRegisteredFormat(inFormat) && isRegisteredFormat(outFormat):
+		return convertRegistered(inputFile, outputFile, pngBackground(config), config)
+	case outFormat == "unknown":
+		return fmt.Errorf(
+			"%w output format for %s: expected an extension or --out-format of %s",
+			errUnsupported, outputFile, strings.Join(encoderNames(), ", "),
+		)
 	default:
-		return fmt.Errorf("unsupported conversion: %s to %s", inputFormat, outputFormat)
+		return fmt.Errorf("%w conversion: %s to %s", errUnsupported, inFormat, outFormat)
 	}
 }
 
-func convertPNGToJPEG(inputFile string, outputFile string, config *Config) error {
-	f, err := os.Open(inputFile)
+// renderImage runs the enabled renderStages on srcImg in order: the resize and
+// filter stages, placing the result on a padded canvas filled with bg, and
+// drawing any overlays.
+func renderImage(srcImg image.Image, meta *Metadata, bg Background, config *Config) (draw.Image, error) {
+	rc := &renderContext{meta: meta, bg: bg, config: config}
+
+	img := srcImg
+	for _, s := range pipelineStages(config) {
+		if !s.enabled(config) {
+			continue
+		}
+
+		var err error
+		img, err = s.apply(img, rc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+	}
+
+	return img.(draw.Image), nil
+}
+
+// composeCanvas draws img onto a new canvas filled with bg, grown by the
+// configured padding. The canvas stores premultiplied alpha unless
+// --premultiply=false asks for straight alpha, which keeps the colors of
+// faint, mostly transparent pixels exact in PNG output.
+func composeCanvas(img image.Image, bg Background, config *Config) draw.Image {
+	bounds := img.Bounds()
+
+	padding := canvasPadding(bounds, config)
+
+	newRect, dest := padding.Canvas(bounds)
+
+	var destImg draw.Image = image.NewRGBA(newRect)
+	if !config.premultiply {
+		destImg = image.NewNRGBA(newRect)
+	}
+
+	draw.Draw(destImg, newRect, bg.Image(newRect), image.Point{}, draw.Src)
+	drawEdges(destImg, padding, config.edgeColors)
+	if config.shadow != nil {
+		drawShadow(destImg, img, dest, config.shadow)
+	}
+	if nrgba, ok := destImg.(*image.NRGBA); ok {
+		drawStraightOver(nrgba, dest, img, bounds.Min)
+	} else {
+		draw.Draw(destImg, dest, img, bounds.Min, draw.Over)
+	}
+
+	return destImg
+}
+
+// drawStraightOver composites src over the r part of destImg in 8-bit
+// straight alpha. draw.Draw goes through 16-bit premultiplied colors even for
+// NRGBA images, which rounds away the colors of faint pixels.
+func drawStraightOver(destImg *image.NRGBA, r image.Rectangle, src image.Image, sp image.Point) {
+	clipped := r.Intersect(destImg.Rect)
+	sp = sp.Add(clipped.Min.Sub(r.Min))
+	r = clipped
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			s := color.NRGBAModel.Convert(src.At(sp.X+x-r.Min.X, sp.Y+y-r.Min.Y)).(color.NRGBA)
+			i := destImg.PixOffset(x, y)
+			d := destImg.Pix[i : i+4 : i+4]
+
+			switch {
+			case s.A == 0:
+				continue
+			case s.A == 0xff || d[3] == 0:
+				d[0], d[1], d[2], d[3] = s.R, s.G, s.B, s.A
+				continue
+			}
+
+			sa := float64(s.A) / 0xff
+			da := float64(d[3]) / 0xff * (1 - sa)
+			a := sa + da
+			blend := func(sc, dc uint8) uint8 {
+				return uint8((float64(sc)*sa+float64(dc)*da)/a + 0.5)
+			}
+			d[0], d[1], d[2], d[3] = blend(s.R, d[0]), blend(s.G, d[1]), blend(s.B, d[2]), uint8(a*0xff+0.5)
+		}
+	}
+}
+
+// EdgeColors holds optional per-edge padding colors. A nil edge keeps the
+// canvas background.
+type EdgeColors struct {
+	top    color.Color
+	right  color.Color
+	bottom color.Color
+	left   color.Color
+}
+
+// drawEdges fills each padding strip that has its own color. The top and
+// bottom strips span the full canvas width, so the corners take the color of
+// the adjacent horizontal edge.
+func drawEdges(destImg draw.Image, padding Padding, edges EdgeColors) {
+	rect := destImg.Bounds()
+
+	strips := []struct {
+		color color.Color
+		rect  image.Rectangle
+	}{
+		{edges.top, image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+padding.Top)},
+		{edges.bottom, image.Rect(rect.Min.X, rect.Max.Y-padding.Bottom, rect.Max.X, rect.Max.Y)},
+		{edges.left, image.Rect(rect.Min.X, rect.Min.Y+padding.Top, rect.Min.X+padding.Left, rect.Max.Y-padding.Bottom)},
+		{edges.right, image.Rect(rect.Max.X-padding.Right, rect.Min.Y+padding.Top, rect.Max.X, rect.Max.Y-padding.Bottom)},
+	}
+
+	for _, strip := range strips {
+		if strip.color == nil || strip.rect.Empty() {
+			continue
+		}
+
+		draw.Draw(destImg, strip.rect, image.NewUniform(strip.color), image.Point{}, draw.Src)
+	}
+}
+
+// pngBackground returns the canvas background for PNG output: transparent
+// unless --matte asks for the alpha to be flattened or --background names a
+// pattern or picture, which fills the padding and shows through transparent
+// pixels just like the per-edge --background-* colors. A solid --background
+// only flattens formats without alpha, so the white default doesn't cost PNG
+// output its transparency.
+func pngBackground(config *Config) Background {
+	if config.matte != nil {
+		return config.matte
+	}
+
+	if _, ok := config.background.(solidBackground); ok {
+		return solidBackground{color: color.Transparent}
+	}
+
+	return config.background
+}
+
+func convertPNGToPNG(inputFile string, outputFile string, config *Config) error {
+	srcImg, err := readImage(inputFile, config)
 	if err != nil {
 		return err
 	}
 
-	srcImg, err := png.Decode(f)
+	meta := readMetadata(inputFile, config)
+	destImg, err := renderImage(srcImg, meta, pngBackground(config), config)
+	if err != nil {
+		return err
+	}
+
+	return writeImage(outputFile, destImg, meta, config)
+}
+
+// convertRegistered converts between any formats with a registered decoder
+// and encoder, rendering onto bg. readImage and writeImage report formats
+// that are missing one of the two.
+func convertRegistered(inputFile string, outputFile string, bg Background, config *Config) error {
+	srcImg, err := readImage(inputFile, config)
 	if err != nil {
 		return err
 	}
-	f.Close()
 
-	bounds := srcImg.Bounds()
+	if format := outputFormat(outputFile, config); format == "jpeg" || format == "gif" {
+		if err := warnFlatten(srcImg, inputFile, config); err != nil {
+			return err
+		}
+	}
+
+	meta := readMetadata(inputFile, config)
+	destImg, err := renderImage(toSRGB(srcImg, meta), meta, bg, config)
+	if err != nil {
+		return err
+	}
 
-	newWidth := bounds.Dx() + config.padding.right + config.padding.left
-	newHeight := bounds.Dy() + config.padding.top + config.padding.bottom
-	newRect := image.Rect(0, 0, newWidth, newHeight)
-	offset := image.Pt(config.padding.left, config.padding.top)
+	return writeImage(outputFile, destImg, meta, config)
+}
 
-	destImg := image.NewRGBA(newRect)
+func convertPNGToJPEG(inputFile string, outputFile string, config *Config) error {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	bg := image.NewUniform(config.bgColor)
+	srcImg, err := png.Decode(f)
+	if err != nil {
+		return err
+	}
 
-	draw.Draw(destImg, newRect, bg, bounds.Min, draw.Src)
-	draw.Draw(destImg, bounds.Add(offset), srcImg, bounds.Min, draw.Over)
+	if err := warnFlatten(srcImg, inputFile, config); err != nil {
+		return err
+	}
 
-	outFile, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	meta := readMetadata(inputFile, config)
+	destImg, err := renderImage(toSRGB(srcImg, meta), meta, config.background, config)
 	if err != nil {
 		return err
 	}
 
-	return jpeg.Encode(outFile, destImg, &jpeg.Options{
-		Quality: config.quality,
-	})
+	return writeImage(outputFile, destImg, meta, config)
 }
 
-func convertJPEGToPNG(inputFile string, outputFile string, config *Config) error {
-	f, err := os.Open(inputFile)
+// convertJPEGToJPEG re-encodes a JPEG, which together with --keep-metadata
+// allows editing its pixels or EXIF data without changing format.
+func convertJPEGToJPEG(inputFile string, outputFile string, config *Config) error {
+	if config.rotateLossless {
+		bounds, err := jpegBounds(inputFile)
+		if err != nil {
+			return err
+		}
+
+		reason := "other changes were asked for"
+		if isLosslessRotation(bounds, config) {
+			rotated, err := rotateJPEGLosslessly(inputFile, outputFile, config)
+			if rotated || err != nil {
+				return err
+			}
+			reason = "it has no EXIF orientation tag"
+		}
+
+		if err := warn(config, "cannot rotate %s losslessly, %s; re-encoding it", config.displayName(inputFile), reason); err != nil {
+			return err
+		}
+
+		// Re-encoding at the top quality at least loses little per pass.
+		if config.quality < 0 && !config.autoQuality {
+			maxQuality := *config
+			maxQuality.quality = 100
+			config = &maxQuality
+		}
+	}
+
+	srcImg, err := readImage(inputFile, config)
 	if err != nil {
 		return err
 	}
 
-	srcImg, err := jpeg.Decode(f)
+	meta := readMetadata(inputFile, config)
+	destImg, err := renderImage(srcImg, meta, config.background, config)
 	if err != nil {
 		return err
 	}
-	f.Close()
 
-	outFile, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_EXCL|os.O_CREATE, 0644)
+	return writeImage(outputFile, destImg, meta, config)
+}
+
+func convertJPEGToPNG(inputFile string, outputFile string, config *Config) error {
+	f, err := os.Open(inputFile)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	bounds := srcImg.Bounds()
+	srcImg, err := jpegDecoder(config)(f)
+	if err != nil {
+		return err
+	}
 
-	minWidth := bounds.Dx() + config.padding.right + config.padding.left
-	minHeight := bounds.Dy() + config.padding.top + config.padding.bottom
-	newRect := image.Rect(0, 0, minWidth, minHeight)
-	offset := image.Pt(config.padding.left, config.padding.top)
+	meta := readMetadata(inputFile, config)
 
-	destImg := image.NewRGBA(newRect)
+	// JPEGs are opaque, so without padding or other stages the canvas
+	// would end up holding exactly the source pixels. Converting them
+	// directly skips filling and compositing over the background.
+	if isPassthrough(srcImg.Bounds(), config) {
+		bounds := srcImg.Bounds()
+		destImg := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(destImg, destImg.Bounds(), srcImg, bounds.Min, draw.Src)
 
-	bg := image.NewUniform(color.Transparent)
+		return writeImage(outputFile, destImg, meta, config)
+	}
 
-	draw.Draw(destImg, newRect, bg, bounds.Min, draw.Src)
-	draw.Draw(destImg, bounds.Add(offset), srcImg, bounds.Min, draw.Over)
+	destImg, err := renderImage(srcImg, meta, pngBackground(config), config)
+	if err != nil {
+		return err
+	}
 
-	return png.Encode(outFile, destImg)
+	return writeImage(outputFile, destImg, meta, config)
 }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testConfig returns the Config the command builds when no flags are given.
+func testConfig() *Config {
+	return &Config{
+		quality:     -1,
+		avifQuality: -1,
+		threshold:   -1,
+		opacity:     1,
+		fileMode:    0o644,
+		premultiply: true,
+		dither:      true,
+		background:  solidBackground{color: color.White},
+	}
+}
+
+// testJPEG returns a decoded JPEG with some detail in it.
+func testJPEG(t testing.TB, width int, height int) image.Image {
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+// passthroughCopy is what convertJPEGToPNG encodes when isPassthrough holds.
+func passthroughCopy(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	return dst
+}
+
+func TestJPEGToPNGPassthroughMatchesRender(t *testing.T) {
+	config := testConfig()
+	img := testJPEG(t, 97, 61)
+	if !isPassthrough(img.Bounds(), config) {
+		t.Fatal("a conversion without flags is not a passthrough")
+	}
+
+	rendered, err := renderImage(img, nil, pngBackground(config), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	direct := passthroughCopy(img)
+	bounds := rendered.Bounds()
+	if bounds != direct.Bounds() {
+		t.Fatalf("rendered bounds %v, passthrough bounds %v", bounds, direct.Bounds())
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if got, want := direct.RGBAAt(x, y), color.RGBAModel.Convert(rendered.At(x, y)); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, rendered %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+// BenchmarkJPEGToPNG measures building the image convertJPEGToPNG encodes,
+// with and without the passthrough; PNG encoding costs the same for both.
+func BenchmarkJPEGToPNG(b *testing.B) {
+	config := testConfig()
+	img := testJPEG(b, 1024, 768)
+
+	b.Run("passthrough", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			passthroughCopy(img)
+		}
+	})
+
+	b.Run("render", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := renderImage(img, nil, pngBackground(config), config); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestTruncatedInputLeavesNothingBehind(t *testing.T) {
+	img := testJPEG(t, 64, 64)
+
+	var pngData, jpegData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		data    []byte
+		output  string
+		convert func(inputFile string, outputFile string, config *Config) error
+	}{
+		{"png to jpeg", "in.png", pngData.Bytes(), "out.jpg", convertPNGToJPEG},
+		{"jpeg to png", "in.jpg", jpegData.Bytes(), "out.png", convertJPEGToPNG},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inputFile := filepath.Join(dir, tt.input)
+			if err := os.WriteFile(inputFile, tt.data[:len(tt.data)/2], 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			fds := openFiles(t)
+			if err := tt.convert(inputFile, filepath.Join(dir, tt.output), testConfig()); err == nil {
+				t.Fatal("converting a truncated input succeeded")
+			}
+			if after := openFiles(t); after > fds {
+				t.Errorf("%d files open after the failed conversion, %d before", after, fds)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if entry.Name() != tt.input {
+					t.Errorf("failed conversion left %s behind", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+// openFiles counts the file descriptors the test process has open, skipping
+// the test where /proc doesn't list them.
+func openFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot list open files:", err)
+	}
+
+	return len(entries)
+}
+
+// writeTestImage encodes img to path in the format its extension names.
+func writeTestImage(t testing.TB, path string, img image.Image) string {
+	t.Helper()
+
+	encode, ok := encoders[detectFormat(path)]
+	if !ok {
+		t.Fatalf("no encoder for %s", path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := encode(f, img, testConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// readTestImage decodes the image file at path.
+func readTestImage(t testing.TB, path string) image.Image {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", path, err)
+	}
+
+	return img
+}
+
+func TestDrawEdges(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	green := color.RGBA{G: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	gray := color.RGBA{R: 128, G: 128, B: 128, A: 255}
+
+	// A 30x20 canvas with padding 2 above, 3 right, 4 below and 5 left.
+	padding := Padding{Top: 2, Right: 3, Bottom: 4, Left: 5}
+
+	tests := []struct {
+		name  string
+		edges EdgeColors
+		at    image.Point
+		want  color.RGBA
+	}{
+		{"top", EdgeColors{top: red, right: green, bottom: blue, left: white}, image.Pt(15, 1), red},
+		{"right", EdgeColors{top: red, right: green, bottom: blue, left: white}, image.Pt(28, 10), green},
+		{"bottom", EdgeColors{top: red, right: green, bottom: blue, left: white}, image.Pt(15, 17), blue},
+		{"left", EdgeColors{top: red, right: green, bottom: blue, left: white}, image.Pt(2, 10), white},
+		{"top-left corner takes the top", EdgeColors{top: red, left: white}, image.Pt(0, 0), red},
+		{"bottom-right corner takes the bottom", EdgeColors{right: green, bottom: blue}, image.Pt(29, 19), blue},
+		{"image area", EdgeColors{top: red, right: green, bottom: blue, left: white}, image.Pt(15, 10), gray},
+		{"edge without a color", EdgeColors{top: red}, image.Pt(28, 10), gray},
+		{"last row of the top strip", EdgeColors{top: red, left: white}, image.Pt(2, 1), red},
+		{"first row below the top strip", EdgeColors{top: red, left: white}, image.Pt(2, 2), white},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canvas := image.NewRGBA(image.Rect(0, 0, 30, 20))
+			draw.Draw(canvas, canvas.Rect, image.NewUniform(gray), image.Point{}, draw.Src)
+
+			drawEdges(canvas, padding, tt.edges)
+			if got := canvas.RGBAAt(tt.at.X, tt.at.Y); got != tt.want {
+				t.Errorf("pixel %v = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStraightAlphaRoundTrip(t *testing.T) {
+	// Faint pixels whose colors premultiplied alpha cannot store exactly.
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	pixels := []color.NRGBA{
+		{R: 201, G: 103, B: 7, A: 3},
+		{R: 255, G: 128, B: 1, A: 1},
+		{R: 17, G: 240, B: 99, A: 20},
+		{R: 90, G: 60, B: 30, A: 255},
+	}
+	for x, c := range pixels {
+		src.SetNRGBA(x, 0, c)
+	}
+
+	tests := []struct {
+		premultiply bool
+		wantExact   bool
+	}{
+		{false, true},
+		{true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("premultiply=%v", tt.premultiply), func(t *testing.T) {
+			dir := t.TempDir()
+			inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), src)
+
+			config := testConfig()
+			config.premultiply = tt.premultiply
+			// Padding makes the conversion go through composeCanvas.
+			config.padding = Padding{Right: 1}
+			outputFile := filepath.Join(dir, "out.png")
+			if err := convertImage(inputFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			out := readTestImage(t, outputFile)
+			exact := true
+			for x, want := range pixels {
+				got := color.NRGBAModel.Convert(out.At(x, 0)).(color.NRGBA)
+				if got != want {
+					exact = false
+					if tt.wantExact {
+						t.Errorf("pixel %d = %v, want %v", x, got, want)
+					}
+				}
+			}
+			if !tt.wantExact && exact {
+				t.Error("premultiplied output kept every faint color exactly, want the test pixels to lose precision")
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Metadata is what the pipeline knows about an input beyond its pixels.
+type Metadata struct {
+	exif     *exifData
+	pngColor *pngColor
+	// source is the input path recorded in the output by --embed-source, or
+	// only its base name under --reproducible.
+	source string
+}
+
+// readMetadata collects the metadata of inputFile. Metadata is best effort:
+// missing or malformed metadata never fails a conversion.
+func readMetadata(inputFile string, config *Config) *Metadata {
+	meta := &Metadata{}
+	if config.embedSource {
+		meta.source = config.displayName(inputFile)
+		if config.reproducible && config.inputName == "" {
+			meta.source = filepath.Base(inputFile)
+		}
+	}
+
+	switch inputFormat(inputFile, config) {
+	case "png":
+		if info, err := readPNGColor(inputFile); err == nil {
+			meta.pngColor = info
+		}
+		return meta
+	case "jpeg":
+	default:
+		return meta
+	}
+
+	raw, err := readExif(inputFile)
+	if err != nil || raw == nil {
+		return meta
+	}
+
+	if exif, err := parseExif(raw); err == nil {
+		meta.exif = exif
+	}
+
+	return meta
+}
+
+// exifSegment returns the APP1 segment to embed in JPEG output, or nil when
+// metadata is not kept or the source has none. Metadata that cannot be
+// carried over is dropped with a warning.
+func exifSegment(meta *Metadata, config *Config) ([]byte, error) {
+	if !config.keepMetadata || meta.exif == nil {
+		return nil, nil
+	}
+
+	exif := meta.exif
+	if config.stripGPS {
+		stripped, err := exif.withoutGPS()
+		if err != nil {
+			return nil, warn(config, "dropping EXIF metadata: %v", err)
+		}
+		exif = stripped
+	}
+
+	// The pixels were turned upright, so viewers must not turn them again.
+	if config.autoOrient && sourceOrientation(meta) != 1 {
+		exif = exif.withOrientation(1)
+	}
+
+	payload := append(slices.Clone(exifHeader), exif.raw...)
+	if len(payload)+2 > 0xffff {
+		return nil, warn(config, "dropping EXIF metadata: %d bytes does not fit in a JPEG segment", len(payload))
+	}
+
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...), nil
+}
+
+// sourceTime returns the conversion time --embed-source-time records, or ""
+// when it is not asked for.
+func sourceTime(config *Config) string {
+	if !config.embedSourceTime {
+		return ""
+	}
+
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// jpegSourceComment returns a COM segment naming the source of the output,
+// and when it was converted if asked for.
+func jpegSourceComment(meta *Metadata, config *Config) []byte {
+	text := "Source: " + meta.source
+	if converted := sourceTime(config); converted != "" {
+		text += "\nCreation Time: " + converted
+	}
+	if len(text)+2 > 0xffff {
+		text = text[:0xffff-2]
+	}
+
+	segment := []byte{0xff, 0xfe, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(text)+2))
+
+	return append(segment, text...)
+}
+
+// pngSourceChunks returns the text chunks naming the source of the output:
+// "Source" and, if asked for, the registered "Creation Time" keyword.
+func pngSourceChunks(meta *Metadata, config *Config) [][]byte {
+	chunks := [][]byte{pngTextChunk("Source", meta.source)}
+	if converted := sourceTime(config); converted != "" {
+		chunks = append(chunks, pngTextChunk("Creation Time", converted))
+	}
+
+	return chunks
+}
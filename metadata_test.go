@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// embeddedSource reads back what --embed-source recorded in the PNG or JPEG
+// file at path: the Source text chunk or comment, and the conversion time if
+// there is one.
+func embeddedSource(t *testing.T, path string) (string, string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(data, pngSignature) {
+		comment, err := jpegSegment(bytes.NewReader(data), 0xfe, []byte("Source: "))
+		if err != nil || comment == nil {
+			t.Fatalf("no Source comment in %s: %v", path, err)
+		}
+		source, converted, _ := strings.Cut(string(comment), "\nCreation Time: ")
+		return source, converted
+	}
+
+	text := map[string]string{}
+	for rest := data[len(pngSignature):]; len(rest) >= 12; {
+		length := binary.BigEndian.Uint32(rest[:4])
+		typ, chunk := string(rest[4:8]), rest[8:8+length]
+		if keyword, value, ok := bytes.Cut(chunk, []byte{0}); typ == "tEXt" && ok {
+			text[string(keyword)] = string(value)
+		}
+		rest = rest[12+length:]
+	}
+	if _, ok := text["Source"]; !ok {
+		t.Fatalf("no Source text chunk in %s", path)
+	}
+
+	return text["Source"], text["Creation Time"]
+}
+
+func TestEmbedSource(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		reproducible bool
+		sourceTime   bool
+		inputName    string
+		wantBase     bool
+	}{
+		{name: "png", output: "out.png"},
+		{name: "jpeg", output: "out.jpg"},
+		{name: "png reproducible", output: "out.png", reproducible: true, wantBase: true},
+		{name: "jpeg with time", output: "out.jpg", sourceTime: true},
+		{name: "png with time", output: "out.png", sourceTime: true},
+		{name: "input name", output: "out.jpg", inputName: "upload.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 16, 12))
+
+			config := testConfig()
+			config.embedSource, config.embedSourceTime = true, tt.sourceTime
+			config.reproducible, config.inputName = tt.reproducible, tt.inputName
+			outputFile := filepath.Join(dir, tt.output)
+			if err := convertImage(inputFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			want := inputFile
+			switch {
+			case tt.inputName != "":
+				want = tt.inputName
+			case tt.wantBase:
+				want = "in.png"
+			}
+
+			source, converted := embeddedSource(t, outputFile)
+			if source != want {
+				t.Errorf("embedded source = %q, want %q", source, want)
+			}
+			if _, err := time.Parse(time.RFC3339, converted); (err == nil) != tt.sourceTime {
+				t.Errorf("embedded conversion time = %q, want a time recorded: %v", converted, tt.sourceTime)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+)
+
+// orient transforms img so that it displays upright without the EXIF
+// orientation tag: orientation 1 is upright, 2 mirrored, 3 upside down,
+// 4 flipped vertically, 5 transposed, 6 turned counterclockwise (so it is
+// rotated clockwise here), 7 transversed and 8 turned clockwise.
+func orient(img image.Image, orientation int) *image.NRGBA {
+	src := toNRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+
+	// Orientations 5 through 8 swap the axes.
+	size := Size{width: width, height: height}
+	if orientation >= 5 {
+		size = Size{width: height, height: width}
+	}
+	destImg := image.NewNRGBA(image.Rect(0, 0, size.width, size.height))
+
+	for y := range height {
+		for x := range width {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			default:
+				dx, dy = x, y
+			}
+
+			si := src.PixOffset(x, y)
+			copy(destImg.Pix[destImg.PixOffset(dx, dy):][:4], src.Pix[si:si+4])
+		}
+	}
+
+	return destImg
+}
+
+// sourceOrientation returns the EXIF orientation of the source described by
+// meta, or 1 when it has none.
+func sourceOrientation(meta *Metadata) int {
+	if meta == nil || meta.exif == nil {
+		return 1
+	}
+
+	orientation, ok := meta.exif.orientation()
+	if !ok {
+		return 1
+	}
+
+	return orientation
+}
+
+// orientationJobs lists the JPEGs directly inside dir whose EXIF orientation
+// is not upright, each to be rewritten in place, along with how many JPEGs
+// dir holds in total.
+func orientationJobs(dir string, config *Config) ([]batchJob, int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var jobs []batchJob
+	total := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || detectFormat(entry.Name()) != "jpeg" {
+			continue
+		}
+		total++
+
+		inputFile := filepath.Join(dir, entry.Name())
+		if sourceOrientation(readMetadata(inputFile, config)) != 1 {
+			jobs = append(jobs, batchJob{inputFile: inputFile, outputFile: inputFile})
+		}
+	}
+
+	return jobs, total, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+const (
+	paletteSamples    = 10000
+	paletteIterations = 20
+)
+
+// defaultSeed seeds randomized steps unless --seed says otherwise. The
+// k-means++ initialization of --palette and --quantize kmeans is currently
+// the only one; dithering and everything else is deterministic.
+const defaultSeed = 1
+
+type dominantColor struct {
+	Color   string  `json:"color"`
+	Percent float64 `json:"percent"`
+}
+
+// extractPalette returns the n most dominant colors of img using k-means
+// clustering over a deterministic sample of its opaque pixels. The clusters
+// are seeded from seed, so equal seeds give equal palettes.
+func extractPalette(img image.Image, n int, seed int64) ([]dominantColor, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid palette size %d: must be positive", n)
+	}
+
+	samples := samplePixels(img, paletteSamples)
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("image has no opaque pixels to sample")
+	}
+
+	n = min(n, len(samples))
+	centroids, assignments := kmeans(samples, n, seed)
+
+	counts := make([]int, n)
+	for _, c := range assignments {
+		counts[c]++
+	}
+
+	colors := make([]dominantColor, 0, n)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return counts[order[a]] > counts[order[b]]
+	})
+
+	for _, c := range order {
+		if counts[c] == 0 {
+			continue
+		}
+		colors = append(colors, dominantColor{
+			Color:   formatHexColor(centroids[c]),
+			Percent: 100 * float64(counts[c]) / float64(len(samples)),
+		})
+	}
+
+	return colors, nil
+}
+
+// kmeans clusters samples into n colors, seeded with k-means++ from seed. It
+// returns the cluster centers and the cluster of each sample.
+func kmeans(samples [][3]float64, n int, seed int64) ([][3]float64, []int) {
+	rng := rand.New(rand.NewSource(seed))
+
+	centroids := initCentroids(samples, n, rng)
+	assignments := make([]int, len(samples))
+
+	for range paletteIterations {
+		changed := false
+		for i, s := range samples {
+			nearest := nearestCentroid(s, centroids)
+			if nearest != assignments[i] {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+
+		sums := make([][3]float64, n)
+		counts := make([]int, n)
+		for i, s := range samples {
+			c := assignments[i]
+			sums[c][0] += s[0]
+			sums[c][1] += s[1]
+			sums[c][2] += s[2]
+			counts[c]++
+		}
+
+		for c := range centroids {
+			if counts[c] == 0 {
+				continue
+			}
+			centroids[c] = [3]float64{
+				sums[c][0] / float64(counts[c]),
+				sums[c][1] / float64(counts[c]),
+				sums[c][2] / float64(counts[c]),
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	return centroids, assignments
+}
+
+// samplePixels picks up to limit evenly spaced pixels, skipping mostly
+// transparent ones, so the result only depends on the image contents.
+func samplePixels(img image.Image, limit int) [][3]float64 {
+	bounds := img.Bounds()
+	total := bounds.Dx() * bounds.Dy()
+	step := max(1, total/limit)
+
+	samples := make([][3]float64, 0, min(total, limit))
+	for i := 0; i < total; i += step {
+		x := bounds.Min.X + i%bounds.Dx()
+		y := bounds.Min.Y + i/bounds.Dx()
+
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		if c.A < 128 {
+			continue
+		}
+
+		samples = append(samples, [3]float64{float64(c.R), float64(c.G), float64(c.B)})
+	}
+
+	return samples
+}
+
+// initCentroids seeds the clusters using k-means++.
+func initCentroids(samples [][3]float64, n int, rng *rand.Rand) [][3]float64 {
+	centroids := make([][3]float64, 0, n)
+	centroids = append(centroids, samples[rng.Intn(len(samples))])
+
+	// dists holds the distance of each sample to its nearest centroid so
+	// far, which only the newest centroid can lower.
+	dists := make([]float64, len(samples))
+	for i, s := range samples {
+		dists[i] = colorDistance(s, centroids[0])
+	}
+
+	for len(centroids) < n {
+		if len(centroids) > 1 {
+			newest := centroids[len(centroids)-1]
+			for i, s := range samples {
+				dists[i] = min(dists[i], colorDistance(s, newest))
+			}
+		}
+
+		var total float64
+		for _, d := range dists {
+			total += d
+		}
+
+		if total == 0 {
+			break
+		}
+
+		target := rng.Float64() * total
+		for i, d := range dists {
+			target -= d
+			if target <= 0 {
+				centroids = append(centroids, samples[i])
+				break
+			}
+		}
+	}
+
+	for len(centroids) < n {
+		centroids = append(centroids, centroids[0])
+	}
+
+	return centroids
+}
+
+func nearestCentroid(s [3]float64, centroids [][3]float64) int {
+	nearest := 0
+	best := colorDistance(s, centroids[0])
+	for i := 1; i < len(centroids); i++ {
+		if d := colorDistance(s, centroids[i]); d < best {
+			best = d
+			nearest = i
+		}
+	}
+
+	return nearest
+}
+
+func colorDistance(a, b [3]float64) float64 {
+	dr := a[0] - b[0]
+	dg := a[1] - b[1]
+	db := a[2] - b[2]
+
+	return dr*dr + dg*dg + db*db
+}
+
+func formatHexColor(c [3]float64) string {
+	return fmt.Sprintf("#%02x%02x%02x", uint8(c[0]+0.5), uint8(c[1]+0.5), uint8(c[2]+0.5))
+}
+
+func printPalette(inputFile string, n int, asJSON bool, config *Config) error {
+	img, err := readImage(inputFile, config)
+	if err != nil {
+		return err
+	}
+
+	colors, err := extractPalette(img, n, config.seed)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(colors)
+	}
+
+	for _, c := range colors {
+		fmt.Printf("%s %.1f%%\n", c.Color, c.Percent)
+	}
+
+	return nil
+}
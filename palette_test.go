@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSeedReproducesQuantizedOutput(t *testing.T) {
+	dir := t.TempDir()
+	inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 96, 64))
+
+	encode := func(name string, seed int64) []byte {
+		t.Helper()
+
+		config := testConfig()
+		config.quantize, config.seed = "kmeans", seed
+		outputFile := filepath.Join(dir, name)
+		if err := convertImage(inputFile, outputFile, config); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return data
+	}
+
+	first, second := encode("a.gif", 7), encode("b.gif", 7)
+	if !bytes.Equal(first, second) {
+		t.Error("two runs with --seed 7 gave different bytes")
+	}
+	if other := encode("c.gif", 8); bytes.Equal(first, other) {
+		t.Error("--seed 7 and --seed 8 gave identical bytes, the seed is not used")
+	}
+}
+
+func TestSeedReproducesPalette(t *testing.T) {
+	img := testJPEG(t, 96, 64)
+
+	extract := func(seed int64) []dominantColor {
+		t.Helper()
+
+		colors, err := extractPalette(img, 6, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return colors
+	}
+
+	if first, second := extract(1), extract(1); !slices.Equal(first, second) {
+		t.Errorf("two runs with the same seed gave %v and %v", first, second)
+	}
+	if first, other := extract(1), extract(2); slices.Equal(first, other) {
+		t.Errorf("seeds 1 and 2 both gave %v", first)
+	}
+}
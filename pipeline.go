@@ -0,0 +1,557 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// renderContext carries what stages need besides the image being rendered.
+type renderContext struct {
+	meta   *Metadata
+	bg     Background
+	config *Config
+}
+
+// stage is one step of renderImage. Stages run in the order of renderStages,
+// and --explain lists the enabled ones from the same table, so the printed
+// plan cannot drift from what actually runs.
+type stage struct {
+	name string
+	// flags names the options that enable the stage.
+	flags string
+	// overlay stages draw onto the canvas, so they must run after pad.
+	overlay  bool
+	enabled  func(config *Config) bool
+	describe func(config *Config) string
+	apply    func(img image.Image, rc *renderContext) (image.Image, error)
+}
+
+var renderStages = []stage{
+	{
+		name:  "orient",
+		flags: "--auto-orient",
+		enabled: func(config *Config) bool {
+			return config.autoOrient
+		},
+		describe: func(config *Config) string {
+			return "from exif"
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			orientation := sourceOrientation(rc.meta)
+			if rc.config.verbose {
+				log.Printf("exif orientation: %d", orientation)
+			}
+			if orientation == 1 {
+				return img, nil
+			}
+			return orient(img, orientation), nil
+		},
+	},
+	{
+		name:  "resize",
+		flags: "--resize or --scale",
+		enabled: func(config *Config) bool {
+			return !config.resize.isZero() || config.scale > 0
+		},
+		describe: func(config *Config) string {
+			desc := config.resize.String()
+			if config.scale > 0 {
+				desc = fmt.Sprintf("x%g", config.scale)
+			}
+			if config.keepAspect != "" {
+				desc += " keep-aspect " + config.keepAspect
+			}
+			if config.noUpscale {
+				desc += " no-upscale"
+			}
+			if config.onlyEnlarge {
+				desc += " only-enlarge"
+			}
+			return desc + " catmullrom"
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			return resizeImage(img, resizeTarget(img.Bounds(), rc.config)), nil
+		},
+	},
+	{
+		name:  "smart-crop",
+		flags: "--smart-crop",
+		enabled: func(config *Config) bool {
+			return !config.smartCrop.isZero()
+		},
+		describe: func(config *Config) string {
+			return fmt.Sprintf("%dx%d by edge energy", config.smartCrop.width, config.smartCrop.height)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			cropped, offset := smartCrop(img, rc.config.smartCrop)
+			if rc.config.verbose {
+				log.Printf("smart crop: window at %d,%d", offset.X, offset.Y)
+			}
+			return cropped, nil
+		},
+	},
+	{
+		name:  "deskew",
+		flags: "--deskew",
+		enabled: func(config *Config) bool {
+			return config.deskew
+		},
+		describe: func(config *Config) string {
+			mode := "fit"
+			if config.rotateCrop {
+				mode = "crop"
+			}
+			return fmt.Sprintf("up to %d degrees %s bilinear", maxDeskewAngle, mode)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			angle := skewAngle(img)
+			if rc.config.verbose {
+				log.Printf("detected skew: %g degrees", angle)
+			}
+			if angle == 0 {
+				return img, nil
+			}
+			return rotate(img, -angle, rc.config.rotateCrop), nil
+		},
+	},
+	{
+		name:  "rotate",
+		flags: "--rotate-exact",
+		enabled: func(config *Config) bool {
+			return config.rotate != 0
+		},
+		describe: func(config *Config) string {
+			mode := "fit"
+			if config.rotateCrop {
+				mode = "crop"
+			}
+			if isRightAngle(config.rotate) && (!config.rotateCrop || math.Abs(config.rotate) == 180) {
+				return fmt.Sprintf("%g degrees exact", config.rotate)
+			}
+			return fmt.Sprintf("%g degrees %s bilinear", config.rotate, mode)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			// Right angles need no resampling, unless cropping a non-square
+			// image to its original size.
+			if isRightAngle(rc.config.rotate) && (!rc.config.rotateCrop || math.Abs(rc.config.rotate) == 180) {
+				return rotateRightAngle(img, rc.config.rotate), nil
+			}
+			return rotate(img, rc.config.rotate, rc.config.rotateCrop), nil
+		},
+	},
+	{
+		name:  "chroma-key",
+		flags: "--chroma-key",
+		enabled: func(config *Config) bool {
+			return config.chromaKey != nil
+		},
+		describe: func(config *Config) string {
+			return fmt.Sprintf(
+				"%s tolerance %g feather %g",
+				hexString(config.chromaKey), config.chromaTolerance, config.chromaFeather,
+			)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			destImg := toNRGBA(img)
+			chromaKey(destImg, rc.config.chromaKey, rc.config.chromaTolerance, rc.config.chromaFeather)
+			return destImg, nil
+		},
+	},
+	{
+		name:  "grayscale",
+		flags: "--grayscale",
+		enabled: func(config *Config) bool {
+			return config.grayscale
+		},
+		describe: func(config *Config) string {
+			return config.grayscaleMethod
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			destImg := toNRGBA(img)
+			grayscale(destImg, rc.config.grayscaleMethod)
+			return destImg, nil
+		},
+	},
+	{
+		name:  "duotone",
+		flags: "--duotone",
+		enabled: func(config *Config) bool {
+			return config.duotone != nil
+		},
+		describe: func(config *Config) string {
+			return hexString(config.duotone[0]) + "-" + hexString(config.duotone[1])
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			destImg := toNRGBA(img)
+			duotone(destImg, rc.config.duotone[0], rc.config.duotone[1])
+			return destImg, nil
+		},
+	},
+	{
+		name:  "tint",
+		flags: "--tint",
+		enabled: func(config *Config) bool {
+			return config.tint != nil
+		},
+		describe: func(config *Config) string {
+			return fmt.Sprintf("%s amount %g %s", hexString(config.tint), config.tintAmount, config.tintMode)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			destImg := toNRGBA(img)
+			tint(destImg, rc.config.tint, rc.config.tintAmount, rc.config.tintMode)
+			return destImg, nil
+		},
+	},
+	{
+		name:  "posterize",
+		flags: "--posterize",
+		enabled: func(config *Config) bool {
+			return config.posterize != 0
+		},
+		describe: func(config *Config) string {
+			return fmt.Sprintf("%d levels", config.posterize)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			destImg := toNRGBA(img)
+			posterize(destImg, rc.config.posterize)
+			return destImg, nil
+		},
+	},
+	{
+		name:  "pixelate",
+		flags: "--pixelate",
+		enabled: func(config *Config) bool {
+			return config.pixelate > 0
+		},
+		describe: func(config *Config) string {
+			desc := fmt.Sprintf("block %d", config.pixelate)
+			if r := config.pixelateRegion; r != nil {
+				desc += fmt.Sprintf(" region %d,%d,%d,%d", r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+			}
+			return desc
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			destImg := toNRGBA(img)
+			region := destImg.Rect
+			if rc.config.pixelateRegion != nil {
+				region = *rc.config.pixelateRegion
+			}
+			pixelate(destImg, rc.config.pixelate, region)
+			return destImg, nil
+		},
+	},
+	{
+		name:  "threshold",
+		flags: "--threshold",
+		enabled: func(config *Config) bool {
+			return config.threshold >= 0 || config.autoThreshold
+		},
+		describe: func(config *Config) string {
+			if config.autoThreshold {
+				return "auto (otsu)"
+			}
+			return strconv.Itoa(config.threshold)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			destImg := toNRGBA(img)
+			t := rc.config.threshold
+			if rc.config.autoThreshold {
+				t = otsuThreshold(computeHistogram(destImg).luma)
+				if rc.config.verbose {
+					log.Printf("auto threshold: %d", t)
+				}
+			}
+			threshold(destImg, t)
+			return destImg, nil
+		},
+	},
+	{
+		name:  "opacity",
+		flags: "--opacity",
+		enabled: func(config *Config) bool {
+			return config.opacity < 1
+		},
+		describe: func(config *Config) string {
+			return fmt.Sprintf("%g towards %s", config.opacity, hexString(config.bgColor))
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			destImg := toNRGBA(img)
+			fade(destImg, rc.config.bgColor, rc.config.opacity)
+			return destImg, nil
+		},
+	},
+	{
+		name:  "vignette",
+		flags: "--vignette",
+		enabled: func(config *Config) bool {
+			return config.vignette > 0
+		},
+		describe: func(config *Config) string {
+			return fmt.Sprintf("strength %g", config.vignette)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			destImg := toNRGBA(img)
+			vignette(destImg, rc.config.vignette)
+			return destImg, nil
+		},
+	},
+	{
+		name:  "trim",
+		flags: "--trim-transparent",
+		enabled: func(config *Config) bool {
+			return config.trimTransparent
+		},
+		describe: func(config *Config) string {
+			return "transparent margins"
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			content := opaqueBounds(img)
+			if content.Empty() {
+				return img, warn(rc.config, "image is fully transparent, nothing to trim")
+			}
+			return crop(img, content), nil
+		},
+	},
+	{
+		name:  "tile",
+		flags: "--tile",
+		enabled: func(config *Config) bool {
+			return !config.tile.isZero()
+		},
+		describe: func(config *Config) string {
+			return fmt.Sprintf("%dx%d", config.tile.width, config.tile.height)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			return tileImage(img, rc.config.tile), nil
+		},
+	},
+	{
+		name:  "round",
+		flags: "--radius",
+		enabled: func(config *Config) bool {
+			return config.radius != Corners{}
+		},
+		describe: func(config *Config) string {
+			r := config.radius
+			return fmt.Sprintf("%d,%d,%d,%d", r.topLeft, r.topRight, r.bottomRight, r.bottomLeft)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			return roundCorners(img, rc.config.radius), nil
+		},
+	},
+	{
+		// pad always runs: it flattens the source onto the background
+		// even when there is no padding.
+		name: "pad",
+		enabled: func(config *Config) bool {
+			return true
+		},
+		describe: func(config *Config) string {
+			p := config.padding
+			desc := fmt.Sprintf("%d,%d,%d,%d", p.Top, p.Right, p.Bottom, p.Left)
+			if config.square {
+				desc += " square"
+			}
+			if config.shadow != nil {
+				desc += fmt.Sprintf(
+					" shadow blur %g offset %d,%d", config.shadow.blur, config.shadow.offset.X, config.shadow.offset.Y,
+				)
+			}
+			return desc
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			bg := rc.bg
+			if _, ok := bg.(blurBackground); ok {
+				bg = blurBackground{src: img}
+			}
+			return composeCanvas(img, bg, rc.config), nil
+		},
+	},
+	{
+		name:    "datestamp",
+		flags:   "--datestamp",
+		overlay: true,
+		enabled: func(config *Config) bool {
+			return config.datestamp
+		},
+		describe: func(config *Config) string {
+			return config.datestampPos
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			return img, drawDatestamp(img.(draw.Image), rc.meta, rc.config)
+		},
+	},
+	{
+		name:    "caption",
+		flags:   "--text",
+		overlay: true,
+		enabled: func(config *Config) bool {
+			return config.text != ""
+		},
+		describe: func(config *Config) string {
+			return fmt.Sprintf("%q %s %gpt", config.text, config.textPos, config.textSize)
+		},
+		apply: func(img image.Image, rc *renderContext) (image.Image, error) {
+			return img, drawCaption(img.(draw.Image), rc.config)
+		},
+	},
+}
+
+// pipelineStages returns the stages to render with, in order: the --pipeline
+// order when one was given, the default order otherwise.
+func pipelineStages(config *Config) []stage {
+	if config.pipeline != nil {
+		return config.pipeline
+	}
+
+	return renderStages
+}
+
+// isPassthrough reports whether rendering an opaque image with the given
+// bounds would only copy its pixels: no stage besides pad is enabled and the
+// canvas gets no padding.
+func isPassthrough(bounds image.Rectangle, config *Config) bool {
+	for _, s := range pipelineStages(config) {
+		if s.name != "pad" && s.enabled(config) {
+			return false
+		}
+	}
+
+	return canvasPadding(bounds, config) == Padding{}
+}
+
+// parsePipeline orders the render stages as listed in a comma separated
+// --pipeline value. Every listed stage must be enabled by its flags and every
+// enabled stage must be listed, so a typo never silently drops a transform.
+// pad may be left out, in which case it runs right before the first overlay.
+func parsePipeline(pipelineStr string, config *Config) ([]stage, error) {
+	byName := make(map[string]stage, len(renderStages))
+	names := make([]string, 0, len(renderStages))
+	for _, s := range renderStages {
+		byName[s.name] = s
+		names = append(names, s.name)
+	}
+
+	var stages []stage
+	listed := make(map[string]bool)
+	for _, name := range strings.Split(pipelineStr, ",") {
+		name = strings.TrimSpace(name)
+
+		s, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid pipeline stage %q: expected one of %v", name, names)
+		}
+		if listed[name] {
+			return nil, fmt.Errorf("invalid pipeline: stage %s is listed twice", name)
+		}
+		if !s.enabled(config) {
+			return nil, fmt.Errorf("invalid pipeline: stage %s requires %s", name, s.flags)
+		}
+
+		listed[name] = true
+		stages = append(stages, s)
+	}
+
+	for _, s := range renderStages {
+		if !listed[s.name] && s.enabled(config) && s.name != "pad" {
+			return nil, fmt.Errorf("invalid pipeline: %s is set but stage %s is not listed", s.flags, s.name)
+		}
+	}
+
+	if !listed["pad"] {
+		at := len(stages)
+		for i, s := range stages {
+			if s.overlay {
+				at = i
+				break
+			}
+		}
+		stages = slices.Insert(stages, at, byName["pad"])
+	}
+
+	padded := false
+	for _, s := range stages {
+		if s.overlay && !padded {
+			return nil, fmt.Errorf("invalid pipeline: stage %s must come after pad", s.name)
+		}
+		padded = padded || s.name == "pad"
+	}
+
+	return stages, nil
+}
+
+// explainPipeline writes the numbered stages converting inputFile to
+// outputFile would run, from decode to encode, without running them.
+func explainPipeline(w io.Writer, inputFile string, outputFile string, autoFormat bool, config *Config) error {
+	steps := []string{"decode " + inputFormat(inputFile, config)}
+
+	for _, s := range pipelineStages(config) {
+		if s.enabled(config) {
+			steps = append(steps, s.name+" "+s.describe(config))
+		}
+	}
+
+	steps = append(steps, describeEncode(outputFile, autoFormat, config))
+
+	for i, step := range steps {
+		if _, err := fmt.Fprintf(w, "%d. %s\n", i+1, step); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func describeEncode(outputFile string, autoFormat bool, config *Config) string {
+	if autoFormat {
+		return "encode auto (png or jpeg)"
+	}
+
+	format := outputFormat(outputFile, config)
+	parts := []string{"encode", format}
+
+	switch format {
+	case "jpeg":
+		if config.autoQuality {
+			parts = append(parts, "q auto")
+		} else {
+			parts = append(parts, fmt.Sprintf("q%d", config.qualityFor("jpeg")))
+		}
+		if config.stripGPS {
+			parts = append(parts, "keep metadata without gps")
+		} else if config.keepMetadata {
+			parts = append(parts, "keep metadata")
+		}
+	case "png", "gif":
+		if config.palette != nil {
+			parts = append(parts, fmt.Sprintf("palette %d colors", len(config.palette)))
+		} else if config.quantize != "" {
+			parts = append(parts, "quantize "+config.quantize)
+		}
+	case "avif", "webp":
+		parts = append(parts, fmt.Sprintf("q%d", config.qualityFor(format)))
+	case "tiff":
+		parts = append(parts, "deflate")
+	case "raw":
+		parts = append(parts, fmt.Sprintf("%s %d-bit", config.rawOrder, config.rawDepth))
+	}
+
+	if config.dataURI {
+		parts = append(parts, "as data uri")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func hexString(c color.Color) string {
+	nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", nrgba.R, nrgba.G, nrgba.B)
+}
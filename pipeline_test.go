@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestDescribeEncodeAutoQuality(t *testing.T) {
+	tests := []struct {
+		outputFile string
+		want       string
+	}{
+		{"out.jpg", "encode jpeg q auto"},
+		{"out.webp", "encode webp q80"},
+		{"out.avif", "encode avif q60"},
+	}
+
+	config := &Config{quality: 0, autoQuality: true, avifQuality: -1}
+	for _, tt := range tests {
+		if got := describeEncode(tt.outputFile, false, config); got != tt.want {
+			t.Errorf("describeEncode(%q) = %q, want %q", tt.outputFile, got, tt.want)
+		}
+	}
+}
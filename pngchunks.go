@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"unicode/utf8"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngColorChunkTypes are the ancillary chunks that describe how PNG samples
+// map to colors. image/png ignores all of them.
+var pngColorChunkTypes = []string{"gAMA", "cHRM", "sRGB", "iCCP"}
+
+// pngColor holds the color chunks of a PNG source. A PNG without any is
+// assumed to be sRGB, like browsers do.
+type pngColor struct {
+	// chunks are the raw chunks, including length, type and CRC.
+	chunks [][]byte
+	// gamma is the file gamma from gAMA, where samples are linear light
+	// raised to gamma. It is 0 when absent or overridden by sRGB.
+	gamma float64
+}
+
+// readPNGColor collects the color chunks preceding the image data of a PNG
+// file, or returns nil if it has none.
+func readPNGColor(inputFile string) (*pngColor, error) {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(signature, pngSignature) {
+		return nil, errors.New("png: invalid signature")
+	}
+
+	info := &pngColor{}
+	srgb := false
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+
+		length := binary.BigEndian.Uint32(header[:4])
+		typ := string(header[4:])
+		if typ == "IDAT" || typ == "IEND" {
+			break
+		}
+
+		if !slices.Contains(pngColorChunkTypes, typ) {
+			if _, err := r.Discard(int(length) + 4); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		chunk := make([]byte, 8+int(length)+4)
+		copy(chunk, header[:])
+		if _, err := io.ReadFull(r, chunk[8:]); err != nil {
+			return nil, err
+		}
+		info.chunks = append(info.chunks, chunk)
+
+		switch {
+		case typ == "sRGB":
+			srgb = true
+		case typ == "gAMA" && length == 4:
+			info.gamma = float64(binary.BigEndian.Uint32(chunk[8:12])) / 100000
+		}
+	}
+
+	if len(info.chunks) == 0 {
+		return nil, nil
+	}
+
+	if srgb {
+		info.gamma = 0
+	}
+
+	return info, nil
+}
+
+// withPNGChunks wraps a PNG encoder so that chunks are inserted right after
+// IHDR, where the color chunks must appear.
+func withPNGChunks(encode encodeFunc, chunks [][]byte) encodeFunc {
+	return func(w io.Writer, img image.Image, config *Config) error {
+		var buf bytes.Buffer
+		if err := encode(&buf, img, config); err != nil {
+			return err
+		}
+
+		// The signature is followed by IHDR, whose data is 13 bytes long.
+		data := buf.Bytes()
+		ihdrEnd := len(pngSignature) + 8 + 13 + 4
+		if _, err := w.Write(data[:ihdrEnd]); err != nil {
+			return err
+		}
+
+		for _, chunk := range chunks {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+		}
+
+		_, err := w.Write(data[ihdrEnd:])
+		return err
+	}
+}
+
+// srgbGamma approximates the sRGB transfer curve as a pure power function.
+const srgbGamma = 1 / 2.2
+
+// toSRGB re-encodes the samples of img, stored with the file gamma of meta,
+// for sRGB. Outputs other than PNG carry no gamma information, so without
+// this a PNG with a non-sRGB gamma would shift in brightness. Images from
+// sources that are already sRGB are returned as is.
+func toSRGB(img image.Image, meta *Metadata) image.Image {
+	if meta.pngColor == nil || meta.pngColor.gamma == 0 {
+		return img
+	}
+
+	gamma := meta.pngColor.gamma
+	if math.Abs(gamma-srgbGamma) < 0.01 {
+		return img
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		linear := math.Pow(float64(i)/255, 1/gamma)
+		lut[i] = uint8(math.Round(math.Pow(linear, srgbGamma) * 255))
+	}
+
+	destImg := toNRGBA(img)
+	for i := 0; i < len(destImg.Pix); i += 4 {
+		destImg.Pix[i] = lut[destImg.Pix[i]]
+		destImg.Pix[i+1] = lut[destImg.Pix[i+1]]
+		destImg.Pix[i+2] = lut[destImg.Pix[i+2]]
+	}
+
+	return destImg
+}
+
+// pngTextChunk returns a chunk holding text under keyword: tEXt when text is
+// plain ASCII, otherwise an uncompressed iTXt, since tEXt is Latin-1 only.
+func pngTextChunk(keyword string, text string) []byte {
+	typ, data := "tEXt", keyword+"\x00"+text
+	for _, r := range text {
+		if r >= utf8.RuneSelf {
+			// No compression, language tag or translated keyword.
+			typ, data = "iTXt", keyword+"\x00\x00\x00\x00\x00"+text
+			break
+		}
+	}
+
+	return pngChunk(typ, []byte(data))
+}
+
+// pngChunk frames data as a chunk of type typ.
+func pngChunk(typ string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[:4], uint32(len(data)))
+	copy(chunk[4:8], typ)
+	chunk = append(chunk, data...)
+
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
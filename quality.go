@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/arthvm/image/convert"
+)
+
+const (
+	// autoQualitySamples bounds how many pixels the complexity estimate
+	// looks at, keeping --quality auto cheap on large images.
+	autoQualitySamples = 256 * 1024
+
+	autoQualityMin = 70
+	autoQualityMax = 92
+
+	// Mean luminance gradients at or below flatEnergy count as flat
+	// (quality autoQualityMin), at or above detailedEnergy as highly
+	// detailed (quality autoQualityMax).
+	flatEnergy     = 2.0
+	detailedEnergy = 20.0
+)
+
+// defaultQualities is the quality each lossy format is encoded at when
+// --quality is not given.
+var defaultQualities = map[string]int{
+	"jpeg": convert.DefaultQuality,
+	"avif": 60,
+	"webp": 80,
+}
+
+// qualityFor returns the quality to encode format at: --avif-quality for
+// AVIF, then --quality, then the format's entry in webQualities under
+// --web-optimized or else in defaultQualities. --quality auto only picks JPEG
+// qualities, so other formats get their default under it.
+func (c *Config) qualityFor(format string) int {
+	if format == "avif" && c.avifQuality >= 0 {
+		return c.avifQuality
+	}
+
+	if c.quality >= 0 && (!c.autoQuality || format == "jpeg") {
+		return c.quality
+	}
+
+	if quality, ok := webQualities[format]; ok && c.webOptimized {
+		return quality
+	}
+
+	return defaultQualities[format]
+}
+
+// usesQuality reports whether format is encoded at a --quality.
+func usesQuality(format string) bool {
+	_, ok := defaultQualities[format]
+	return ok
+}
+
+// errQualityRange is wrapped by parseQuality errors for integers outside 1 to
+// 100, which only matter for outputs that use the quality.
+var errQualityRange = errors.New("expected 1 to 100")
+
+// parseQuality parses the --quality value: either "auto" or an integer from 1
+// to 100. An empty value, meaning the flag was not given, parses as -1, as
+// does an out of range one along with an error wrapping errQualityRange.
+func parseQuality(qualityStr string) (int, bool, error) {
+	if qualityStr == "" {
+		return -1, false, nil
+	}
+
+	if strings.EqualFold(qualityStr, "auto") {
+		return 0, true, nil
+	}
+
+	quality, err := strconv.Atoi(qualityStr)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid quality %q: expected a number or auto", qualityStr)
+	}
+
+	if quality < 1 || quality > 100 {
+		return -1, false, fmt.Errorf("invalid quality %q: %w", qualityStr, errQualityRange)
+	}
+
+	return quality, false, nil
+}
+
+// autoJPEGQuality picks a JPEG quality from the image's complexity, measured
+// as the mean absolute luminance difference between neighbouring pixels over
+// a sampled grid. Flat images compress well at low quality without visible
+// artifacts, while detailed ones need a higher quality to stay sharp.
+func autoJPEGQuality(img image.Image) int {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		bounds := img.Bounds()
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	}
+
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+	step := max(1, int(math.Sqrt(float64(width*height)/autoQualitySamples)))
+
+	luma := func(x, y int) float64 {
+		i := y*rgba.Stride + x*4
+		return 0.299*float64(rgba.Pix[i]) + 0.587*float64(rgba.Pix[i+1]) + 0.114*float64(rgba.Pix[i+2])
+	}
+
+	var energy float64
+	var samples int
+	for y := 0; y < height-1; y += step {
+		for x := 0; x < width-1; x += step {
+			l := luma(x, y)
+			energy += math.Abs(l-luma(x+1, y)) + math.Abs(l-luma(x, y+1))
+			samples++
+		}
+	}
+
+	if samples == 0 {
+		return autoQualityMax
+	}
+
+	t := (energy/float64(samples) - flatEnergy) / (detailedEnergy - flatEnergy)
+	t = min(1, max(0, t))
+
+	return autoQualityMin + int(math.Round(t*(autoQualityMax-autoQualityMin)))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQualityFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		quality string
+		format  string
+		want    int
+	}{
+		{"default jpeg", "", "jpeg", 90},
+		{"default webp", "", "webp", 80},
+		{"explicit webp", "55", "webp", 55},
+		{"auto avif", "auto", "avif", 60},
+		{"auto webp", "auto", "webp", 80},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quality, autoQuality, err := parseQuality(tt.quality)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := &Config{quality: quality, autoQuality: autoQuality, avifQuality: -1}
+			if got := config.qualityFor(tt.format); got != tt.want {
+				t.Errorf("qualityFor(%q) with --quality %q = %d, want %d", tt.format, tt.quality, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQualityRange(t *testing.T) {
+	for _, in := range []string{"0", "101", "-5"} {
+		if quality, _, err := parseQuality(in); !errors.Is(err, errQualityRange) || quality != -1 {
+			t.Errorf("parseQuality(%q) = %d, %v, want -1 and a range error", in, quality, err)
+		}
+	}
+
+	for _, in := range []string{"1", "100"} {
+		if _, _, err := parseQuality(in); err != nil {
+			t.Errorf("parseQuality(%q) error = %v", in, err)
+		}
+	}
+}
+
+func TestJPEGQualitySize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8(x * y), A: 255})
+		}
+	}
+
+	size := func(quality int) int {
+		var buf bytes.Buffer
+		config := &Config{quality: quality, avifQuality: -1}
+		if err := encodeJPEG(&buf, img, config); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Len()
+	}
+
+	if low, high := size(10), size(95); high <= low {
+		t.Errorf("quality 95 encoded to %d bytes, not more than the %d of quality 10", high, low)
+	}
+}
+
+func TestQualityOutOfRange(t *testing.T) {
+	dir := t.TempDir()
+	writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 16, 16))
+
+	tests := []struct {
+		name     string
+		args     []string
+		want     int
+		wantWarn bool
+	}{
+		{"png output", []string{"-q", "150", "in.png", "out.png"}, 0, true},
+		{"gif output", []string{"-q", "0", "--to", "gif", "in.png", "out"}, 0, true},
+		{"jpeg output", []string{"-q", "150", "in.png", "out.jpg"}, exitUsage, false},
+		{"webp override", []string{"-q", "150", "--to", "webp", "in.png", "out2.png"}, exitUsage, false},
+		{"auto format", []string{"-q", "150", "--auto-format", "in.png", "auto"}, exitUsage, false},
+		{"not a number", []string{"-q", "high", "in.png", "out3.png"}, exitUsage, false},
+		{"strict png output", []string{"--strict", "-q", "150", "in.png", "out4.png"}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, out := runCommand(t, dir, tt.args...)
+			if code != tt.want {
+				t.Errorf("image %v exited with %d, want %d; output:\n%s", tt.args, code, tt.want, out)
+			}
+			if warned := strings.Contains(out, "ignoring --quality"); warned != tt.wantWarn {
+				t.Errorf("image %v warned about the quality %v, want %v; output:\n%s", tt.args, warned, tt.wantWarn, out)
+			}
+		})
+	}
+}
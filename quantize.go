@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxPaletteColors is the most colors a GIF or paletted PNG can index.
+const maxPaletteColors = 256
+
+// readPaletteFile reads a palette with one hex color per line. Blank lines are
+// ignored.
+func readPaletteFile(path string) (color.Palette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pal color.Palette
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		c, err := parseHexColor(text)
+		if err != nil {
+			return nil, fmt.Errorf("parse palette %s:%d: %w", path, line, err)
+		}
+		pal = append(pal, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(pal) == 0 {
+		return nil, fmt.Errorf("parse palette %s: no colors", path)
+	}
+	if len(pal) > maxPaletteColors {
+		return nil, fmt.Errorf("parse palette %s: %d colors, at most %d are supported", path, len(pal), maxPaletteColors)
+	}
+
+	return pal, nil
+}
+
+var quantizeMethods = []string{"median-cut", "octree", "kmeans"}
+
+func parseQuantizeMethod(method string) (string, error) {
+	if slices.Contains(quantizeMethods, method) {
+		return method, nil
+	}
+
+	return "", fmt.Errorf("invalid quantize method %q: expected one of %v", method, quantizeMethods)
+}
+
+// adaptivePalette builds a palette of at most n colors fitted to imgs with
+// the --quantize method, or median cut when none is set, from an even sample
+// of their opaque pixels. When
+// any of imgs has transparency, one of the n entries is fully transparent.
+func adaptivePalette(imgs []image.Image, n int, config *Config) color.Palette {
+	perImage := max(1, paletteSamples/len(imgs))
+
+	var samples [][3]float64
+	transparent := false
+	for _, img := range imgs {
+		samples = append(samples, samplePixels(img, perImage)...)
+		if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+			transparent = true
+		}
+	}
+
+	if transparent {
+		n--
+	}
+
+	var pal color.Palette
+	switch {
+	case len(samples) == 0:
+	case config.quantize == "octree":
+		pal = octreePalette(samples, n)
+	case config.quantize == "kmeans":
+		centroids, _ := kmeans(samples, min(n, len(samples)), config.seed)
+		for _, c := range centroids {
+			pal = append(pal, color.NRGBA{R: uint8(c[0] + 0.5), G: uint8(c[1] + 0.5), B: uint8(c[2] + 0.5), A: 255})
+		}
+	default:
+		pal = medianCut(samples, n)
+	}
+
+	if transparent || len(pal) == 0 {
+		pal = append(pal, color.NRGBA{})
+	}
+
+	return pal
+}
+
+// medianCut reduces samples to at most n representative colors. Starting
+// from a single box holding every sample, it repeatedly splits the box with
+// the widest channel range at the median of that channel, then averages the
+// samples of each box.
+func medianCut(samples [][3]float64, n int) color.Palette {
+	boxes := [][][3]float64{samples}
+
+	for len(boxes) < n {
+		widest, widestChannel, widestRange := -1, 0, 0.0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+
+			for ch := range 3 {
+				lo, hi := box[0][ch], box[0][ch]
+				for _, s := range box {
+					lo, hi = min(lo, s[ch]), max(hi, s[ch])
+				}
+				if hi-lo > widestRange {
+					widest, widestChannel, widestRange = i, ch, hi-lo
+				}
+			}
+		}
+
+		// Every box holds a single color.
+		if widest < 0 {
+			break
+		}
+
+		box := boxes[widest]
+		sort.Slice(box, func(a, b int) bool {
+			return box[a][widestChannel] < box[b][widestChannel]
+		})
+
+		half := len(box) / 2
+		boxes[widest] = box[:half]
+		boxes = append(boxes, box[half:])
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		var sum [3]float64
+		for _, s := range box {
+			sum[0] += s[0]
+			sum[1] += s[1]
+			sum[2] += s[2]
+		}
+
+		count := float64(len(box))
+		pal = append(pal, color.NRGBA{
+			R: uint8(sum[0]/count + 0.5),
+			G: uint8(sum[1]/count + 0.5),
+			B: uint8(sum[2]/count + 0.5),
+			A: 255,
+		})
+	}
+
+	return pal
+}
+
+// octreeNode is a node of the color octree built by octreePalette. Each level
+// splits the RGB cube by one more bit of every channel.
+type octreeNode struct {
+	children [8]*octreeNode
+	sum      [3]float64
+	count    int
+	leaf     bool
+}
+
+// octreePalette reduces samples to at most n colors by inserting them into
+// an octree eight levels deep, then repeatedly merging the children of the
+// deepest nodes into their parent until at most n leaves are left. Each leaf
+// becomes the average of the samples below it.
+func octreePalette(samples [][3]float64, n int) color.Palette {
+	const depth = 8
+
+	root := &octreeNode{}
+	var levels [depth][]*octreeNode
+	leaves := 0
+
+	for _, s := range samples {
+		r, g, b := uint8(s[0]), uint8(s[1]), uint8(s[2])
+
+		node := root
+		for level := range depth {
+			shift := depth - 1 - level
+			i := (r>>shift&1)<<2 | (g>>shift&1)<<1 | b>>shift&1
+
+			child := node.children[i]
+			if child == nil {
+				child = &octreeNode{leaf: level == depth-1}
+				node.children[i] = child
+				if child.leaf {
+					leaves++
+				} else {
+					levels[level] = append(levels[level], child)
+				}
+			}
+			node = child
+		}
+
+		node.sum[0] += s[0]
+		node.sum[1] += s[1]
+		node.sum[2] += s[2]
+		node.count++
+	}
+
+	// Level -1 is the root, which only needs merging for tiny palettes.
+	for level := depth - 2; level >= -1 && leaves > n; level-- {
+		nodes := []*octreeNode{root}
+		if level >= 0 {
+			nodes = levels[level]
+		}
+
+		for _, node := range nodes {
+			if leaves <= n {
+				break
+			}
+
+			merged := 0
+			for i, child := range node.children {
+				if child == nil {
+					continue
+				}
+				node.sum[0] += child.sum[0]
+				node.sum[1] += child.sum[1]
+				node.sum[2] += child.sum[2]
+				node.count += child.count
+				node.children[i] = nil
+				merged++
+			}
+			node.leaf = true
+			leaves -= merged - 1
+		}
+	}
+
+	var pal color.Palette
+	var collect func(node *octreeNode)
+	collect = func(node *octreeNode) {
+		if node.leaf {
+			count := float64(node.count)
+			pal = append(pal, color.NRGBA{
+				R: uint8(node.sum[0]/count + 0.5),
+				G: uint8(node.sum[1]/count + 0.5),
+				B: uint8(node.sum[2]/count + 0.5),
+				A: 255,
+			})
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				collect(child)
+			}
+		}
+	}
+	collect(root)
+
+	return pal
+}
+
+// quantize maps img onto pal, spreading the error with Floyd-Steinberg
+// dithering when dither is set and picking the nearest color otherwise.
+func quantize(img image.Image, pal color.Palette, dither bool) *image.Paletted {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), pal)
+
+	var drawer draw.Drawer = draw.Src
+	if dither {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(paletted, paletted.Bounds(), img, bounds.Min)
+
+	return paletted
+}
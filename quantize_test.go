@@ -0,0 +1,108 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPaletteFileLimitsOutputColors(t *testing.T) {
+	dir := t.TempDir()
+	inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 40, 30))
+	paletteFile := filepath.Join(dir, "palette.txt")
+	if err := os.WriteFile(paletteFile, []byte("#000000\n\nff0000\n#00ff00\n  2040c0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pal, err := readPaletteFile(paletteFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := map[color.RGBA]bool{}
+	for _, c := range pal {
+		allowed[color.RGBAModel.Convert(c).(color.RGBA)] = true
+	}
+	if len(allowed) != 4 {
+		t.Fatalf("palette file parsed to %d colors, want 4", len(allowed))
+	}
+
+	for _, output := range []string{"out.png", "out.gif"} {
+		for _, dither := range []bool{true, false} {
+			config := testConfig()
+			config.palette, config.dither = pal, dither
+			outputFile := filepath.Join(t.TempDir(), output)
+			if err := convertImage(inputFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			img := readTestImage(t, outputFile)
+			bounds := img.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					if c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA); !allowed[c] {
+						t.Fatalf("%s (dither %v): pixel (%d, %d) = %v is not in the palette", output, dither, x, y, c)
+					}
+				}
+			}
+		}
+	}
+}
+
+// uniqueColors counts the distinct colors of img.
+func uniqueColors(img image.Image) int {
+	seen := map[color.RGBA]bool{}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			seen[color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)] = true
+		}
+	}
+
+	return len(seen)
+}
+
+func TestQuantizeMethodColors(t *testing.T) {
+	// Five flat stripes, which every method should find exactly.
+	stripes := image.NewRGBA(image.Rect(0, 0, 50, 20))
+	for i, c := range []color.RGBA{
+		{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}, {R: 255, G: 255, A: 255}, {A: 255},
+	} {
+		draw.Draw(stripes, image.Rect(i*10, 0, i*10+10, 20), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		min, max int
+	}{
+		{"stripes", stripes, 5, 5},
+		{"photo", testJPEG(t, 120, 90), 64, maxPaletteColors},
+	}
+
+	if n := uniqueColors(tests[1].img); n <= maxPaletteColors {
+		t.Fatalf("test photo has only %d colors, nothing to quantize", n)
+	}
+
+	for _, method := range quantizeMethods {
+		for _, tt := range tests {
+			t.Run(method+" "+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), tt.img)
+
+				config := testConfig()
+				config.quantize, config.dither = method, false
+				outputFile := filepath.Join(dir, "out.png")
+				if err := convertImage(inputFile, outputFile, config); err != nil {
+					t.Fatal(err)
+				}
+
+				if n := uniqueColors(readTestImage(t, outputFile)); n < tt.min || n > tt.max {
+					t.Errorf("--quantize %s left %d unique colors, want %d to %d", method, n, tt.min, tt.max)
+				}
+			})
+		}
+	}
+}
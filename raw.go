@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"slices"
+	"strings"
+)
+
+// rawLayout is the geometry of headerless pixel data: width x height pixels
+// of channels interleaved 8-bit samples each, row by row.
+type rawLayout struct {
+	width    int
+	height   int
+	channels int
+}
+
+// rawChannels describes the accepted --raw-channels values.
+const rawChannels = "1 (gray), 3 (RGB) or 4 (RGBA)"
+
+func parseRawChannels(channels int) error {
+	switch channels {
+	case 1, 3, 4:
+		return nil
+	default:
+		return fmt.Errorf("invalid raw channel count %d: expected %s", channels, rawChannels)
+	}
+}
+
+// decode reads raw pixel data in the layout. One channel decodes to
+// image.Gray, three to an opaque image.RGBA and four to an image.RGBA, whose
+// samples are taken as alpha-premultiplied. The input must hold exactly the
+// bytes the layout calls for, which catches a wrong width or channel count
+// that would otherwise decode to a sheared image.
+func (l rawLayout) decode(r io.Reader) (image.Image, error) {
+	if l.width <= 0 || l.height <= 0 {
+		return nil, errors.New("raw input needs --raw-width and --raw-height")
+	}
+	if err := parseRawChannels(l.channels); err != nil {
+		return nil, err
+	}
+
+	want := l.width * l.height * l.channels
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != want {
+		return nil, fmt.Errorf(
+			"raw input size does not match %dx%d with %d channels: expected %d bytes, got %d",
+			l.width, l.height, l.channels, want, len(data),
+		)
+	}
+
+	rect := image.Rect(0, 0, l.width, l.height)
+	switch l.channels {
+	case 1:
+		return &image.Gray{Pix: data, Stride: l.width, Rect: rect}, nil
+	case 4:
+		return &image.RGBA{Pix: data, Stride: 4 * l.width, Rect: rect}, nil
+	}
+
+	img := image.NewRGBA(rect)
+	for i, j := 0, 0; i < len(data); i, j = i+3, j+4 {
+		copy(img.Pix[j:j+3], data[i:i+3])
+		img.Pix[j+3] = 0xff
+	}
+
+	return img, nil
+}
+
+// rawOrders lists the accepted --raw-order values: the channels of each
+// pixel in the order they are written.
+var rawOrders = []string{"rgba", "rgb", "bgra", "bgr", "argb", "gray"}
+
+func parseRawOrder(order string) (string, error) {
+	order = strings.ToLower(order)
+	if !slices.Contains(rawOrders, order) {
+		return "", fmt.Errorf("invalid raw channel order %q: expected one of %s", order, strings.Join(rawOrders, ", "))
+	}
+
+	return order, nil
+}
+
+// rawHasAlpha reports whether pixels written in order keep their alpha;
+// images written without it are flattened onto the background first.
+func rawHasAlpha(order string) bool {
+	return strings.Contains(order, "a")
+}
+
+// encodeRaw writes img as headerless pixel data in config.rawOrder, with
+// config.rawDepth bits per sample, 16-bit samples big-endian. Alpha stays
+// premultiplied, as --in-format raw expects it, so raw data round-trips.
+// Since nothing in the output records the geometry, it is logged for the
+// consumer.
+func encodeRaw(w io.Writer, img image.Image, config *Config) error {
+	bounds := img.Bounds()
+	src := image.NewRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Rect, img, bounds.Min, draw.Src)
+
+	log.Printf("raw output: %dx%d, %s, %d bits per sample", bounds.Dx(), bounds.Dy(), config.rawOrder, config.rawDepth)
+
+	bw := bufio.NewWriter(w)
+	put := func(v uint16) {
+		bw.WriteByte(byte(v >> 8))
+		if config.rawDepth == 16 {
+			bw.WriteByte(byte(v))
+		}
+	}
+
+	for y := range src.Rect.Dy() {
+		for x := range src.Rect.Dx() {
+			c := src.RGBA64At(x, y)
+			if config.rawOrder == "gray" {
+				put(color.Gray16Model.Convert(c).(color.Gray16).Y)
+				continue
+			}
+
+			for _, ch := range config.rawOrder {
+				switch ch {
+				case 'r':
+					put(c.R)
+				case 'g':
+					put(c.G)
+				case 'b':
+					put(c.B)
+				case 'a':
+					put(c.A)
+				}
+			}
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestRawRoundTrip(t *testing.T) {
+	opaque := testJPEG(t, 20, 10)
+
+	// Opaque pixels with a fully transparent hole, which premultiplied raw
+	// samples store exactly.
+	holed := toNRGBA(opaque)
+	for y := 3; y < 6; y++ {
+		for x := 4; x < 9; x++ {
+			holed.SetNRGBA(x, y, color.NRGBA{})
+		}
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 16, 4))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 4)
+	}
+
+	tests := []struct {
+		order    string
+		channels int
+		img      image.Image
+	}{
+		{"rgba", 4, holed},
+		{"rgb", 3, opaque},
+		{"gray", 1, gray},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			dir := t.TempDir()
+			inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), tt.img)
+			want := readTestImage(t, inputFile)
+
+			config := testConfig()
+			config.rawOrder, config.rawDepth = tt.order, 8
+			rawFile := filepath.Join(dir, "out.raw")
+			if err := convertImage(inputFile, rawFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			bounds := want.Bounds()
+			config = testConfig()
+			config.inFormat = "raw"
+			config.raw = rawLayout{width: bounds.Dx(), height: bounds.Dy(), channels: tt.channels}
+			outputFile := filepath.Join(dir, "out.png")
+			if err := convertImage(rawFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			got := readTestImage(t, outputFile)
+			if got.Bounds() != bounds {
+				t.Fatalf("round trip is %v, want %v", got.Bounds(), bounds)
+			}
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					g := color.NRGBAModel.Convert(got.At(x, y))
+					w := color.NRGBAModel.Convert(want.At(x, y))
+					if g != w {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+					}
+				}
+			}
+		})
+	}
+}
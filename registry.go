@@ -0,0 +1,40 @@
+package main
+
+import (
+	"image"
+	"io"
+	"slices"
+)
+
+// RegisterEncoder makes fn the encoder for format, replacing any previous
+// one, so that output files with the format's name as extension (or
+// --out-format) are written with it. The built-in encoders register the same
+// way. The registry is not guarded by a lock: register from init functions,
+// before any conversion starts.
+func RegisterEncoder(format string, fn func(w io.Writer, img image.Image, config *Config) error) {
+	encoders[format] = fn
+}
+
+// RegisterDecoder makes fn the decoder for format, with the same rules as
+// RegisterEncoder.
+func RegisterDecoder(format string, fn func(r io.Reader) (image.Image, error)) {
+	decoders[format] = fn
+}
+
+func isRegisteredFormat(format string) bool {
+	_, encodable := encoders[format]
+	_, decodable := decoders[format]
+
+	return encodable || decodable
+}
+
+// encoderNames lists the formats output can be written in, sorted.
+func encoderNames() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// streamingMinPixels is the source size above which downscaling switches to
+// the strip-based path, which filters the same but keeps much less in memory
+// at once. Below it the full-image scaler is cheap enough.
+const streamingMinPixels = 4096 * 4096
+
+type Size struct {
+	width  int
+	height int
+}
+
+func (s Size) isZero() bool {
+	return s.width == 0 && s.height == 0
+}
+
+var keepAspectModes = []string{"fit", "letterbox", "cover"}
+
+func parseKeepAspect(mode string) error {
+	if slices.Contains(keepAspectModes, mode) {
+		return nil
+	}
+
+	return fmt.Errorf("invalid keep-aspect mode %q: expected one of %v", mode, keepAspectModes)
+}
+
+// resizeTarget returns the size --resize or --scale should scale an image with
+// the given bounds to. With --keep-aspect the --resize box is shrunk to the
+// source's aspect ratio instead of distorting it, or grown to it in cover
+// mode; letterboxing back to the full box happens when padding. A box with
+// one dimension follows the source's aspect ratio. With --no-upscale a target
+// larger than the source is shrunk, keeping its aspect ratio, until it fits
+// within the native dimensions. With a < geometry, a source that already
+// reaches the box in either dimension keeps its size.
+func resizeTarget(bounds image.Rectangle, config *Config) Size {
+	size := config.resize
+	if config.onlyEnlarge && (size.width != 0 && bounds.Dx() >= size.width || size.height != 0 && bounds.Dy() >= size.height) {
+		return Size{width: bounds.Dx(), height: bounds.Dy()}
+	}
+	if size.isPartial() {
+		size = proportionalSize(bounds, size)
+	}
+	if config.keepAspect == "cover" && !size.isZero() {
+		size = coverSize(bounds, size)
+	} else if config.keepAspect != "" && !size.isZero() {
+		size = fitSize(bounds, size)
+	}
+	if config.scale > 0 {
+		size = Size{
+			width:  max(1, int(float64(bounds.Dx())*config.scale+0.5)),
+			height: max(1, int(float64(bounds.Dy())*config.scale+0.5)),
+		}
+	}
+
+	if size.isZero() || !config.noUpscale {
+		return size
+	}
+
+	if size.width <= bounds.Dx() && size.height <= bounds.Dy() {
+		return size
+	}
+
+	scale := min(
+		float64(bounds.Dx())/float64(size.width),
+		float64(bounds.Dy())/float64(size.height),
+	)
+
+	return Size{
+		width:  max(1, int(float64(size.width)*scale+0.5)),
+		height: max(1, int(float64(size.height)*scale+0.5)),
+	}
+}
+
+func resizeImage(img image.Image, size Size) image.Image {
+	bounds := img.Bounds()
+	if size.isZero() || (bounds.Dx() == size.width && bounds.Dy() == size.height) {
+		return img
+	}
+
+	downscale := size.width <= bounds.Dx() && size.height <= bounds.Dy()
+	if downscale && bounds.Dx()*bounds.Dy() >= streamingMinPixels {
+		return downscaleStrips(img, size)
+	}
+
+	destImg := image.NewRGBA(image.Rect(0, 0, size.width, size.height))
+	xdraw.CatmullRom.Scale(destImg, destImg.Bounds(), img, bounds, draw.Src, nil)
+
+	return destImg
+}
+
+// downscaleStrips shrinks img with the same Catmull-Rom filter as the
+// full-quality scaler, but applies it one direction at a time: each source
+// row is converted and filtered horizontally once, and only the rows the
+// vertical filter still needs are kept. Besides the decoded source, peak
+// memory is the destination image plus one source row and about 4×(source
+// height / destination height) filtered rows, so a huge input never needs a
+// second full-resolution buffer.
+func downscaleStrips(img image.Image, size Size) *image.RGBA {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	columns := catmullRomTaps(srcWidth, size.width)
+	rows := catmullRomTaps(srcHeight, size.height)
+
+	window := 0
+	for _, t := range rows {
+		window = max(window, len(t.weights))
+	}
+
+	destImg := image.NewRGBA(image.Rect(0, 0, size.width, size.height))
+	row := image.NewRGBA(image.Rect(0, 0, srcWidth, 1))
+	filtered := make([][]float32, window)
+	for i := range filtered {
+		filtered[i] = make([]float32, size.width*4)
+	}
+	acc := make([]float32, size.width*4)
+
+	next := 0
+	for dy, t := range rows {
+		// Filter the source rows this destination row needs, reusing the
+		// slot of a row no later destination row looks at.
+		for ; next < t.start+len(t.weights); next++ {
+			draw.Draw(row, row.Bounds(), img, image.Pt(bounds.Min.X, bounds.Min.Y+next), draw.Src)
+
+			out := filtered[next%window]
+			for dx, c := range columns {
+				var r, g, b, a float32
+				for k, w := range c.weights {
+					px := row.Pix[(c.start+k)*4:]
+					r += w * float32(px[0])
+					g += w * float32(px[1])
+					b += w * float32(px[2])
+					a += w * float32(px[3])
+				}
+				out[dx*4], out[dx*4+1], out[dx*4+2], out[dx*4+3] = r, g, b, a
+			}
+		}
+
+		clear(acc)
+		for k, w := range t.weights {
+			for i, v := range filtered[(t.start+k)%window] {
+				acc[i] += w * v
+			}
+		}
+
+		// The filter's negative lobes can overshoot, and premultiplied
+		// colors must stay within their alpha.
+		out := destImg.Pix[dy*destImg.Stride : dy*destImg.Stride+size.width*4]
+		for dx := range size.width {
+			a := clampByte(acc[dx*4+3], 255)
+			out[dx*4+3] = a
+			for c := range 3 {
+				out[dx*4+c] = clampByte(acc[dx*4+c], a)
+			}
+		}
+	}
+
+	return destImg
+}
+
+// filterTaps are the weights of consecutive source pixels, from start, that
+// make up one destination pixel.
+type filterTaps struct {
+	start   int
+	weights []float32
+}
+
+// catmullRomTaps returns the taps of a Catmull-Rom filter shrinking srcLen
+// pixels to dstLen, widened by the scale factor as x/image/draw does when
+// downscaling. Taps outside the source are dropped and the rest normalized.
+func catmullRomTaps(srcLen int, dstLen int) []filterTaps {
+	scale := max(1, float64(srcLen)/float64(dstLen))
+	support := 2 * scale
+
+	taps := make([]filterTaps, dstLen)
+	for d := range taps {
+		center := (float64(d) + 0.5) * float64(srcLen) / float64(dstLen)
+		first := max(0, int(math.Floor(center-support)))
+		last := min(srcLen-1, int(math.Ceil(center+support)))
+
+		weights := make([]float32, 0, last-first+1)
+		var sum float64
+		for i := first; i <= last; i++ {
+			w := catmullRom(math.Abs(float64(i)+0.5-center) / scale)
+			weights = append(weights, float32(w))
+			sum += w
+		}
+		for i := range weights {
+			weights[i] /= float32(sum)
+		}
+
+		taps[d] = filterTaps{start: first, weights: weights}
+	}
+
+	return taps
+}
+
+// catmullRom is the Catmull-Rom cubic at distance t from the center.
+func catmullRom(t float64) float64 {
+	switch {
+	case t < 1:
+		return (1.5*t-2.5)*t*t + 1
+	case t < 2:
+		return ((-0.5*t+2.5)*t-4)*t + 2
+	default:
+		return 0
+	}
+}
+
+func clampByte(v float32, limit uint8) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= float32(limit) {
+		return limit
+	}
+
+	return uint8(v + 0.5)
+}
+
+// tileImage repeats img from its top-left corner across a canvas of the given
+// size. Tiles crossing the right or bottom edge are cropped, as is a source
+// larger than the canvas.
+func tileImage(img image.Image, size Size) image.Image {
+	if size.isZero() {
+		return img
+	}
+
+	bounds := img.Bounds()
+	destImg := image.NewRGBA(image.Rect(0, 0, size.width, size.height))
+
+	for y := 0; y < size.height; y += bounds.Dy() {
+		for x := 0; x < size.width; x += bounds.Dx() {
+			tile := image.Rect(x, y, x+bounds.Dx(), y+bounds.Dy())
+			draw.Draw(destImg, tile, img, bounds.Min, draw.Src)
+		}
+	}
+
+	return destImg
+}
+
+// coverImage scales img, keeping its aspect ratio, until it covers a canvas
+// of the given size and crops the overflow evenly from both sides.
+func coverImage(img image.Image, size Size) image.Image {
+	bounds := img.Bounds()
+	scale := max(
+		float64(size.width)/float64(bounds.Dx()),
+		float64(size.height)/float64(bounds.Dy()),
+	)
+
+	scaled := resizeImage(img, Size{
+		width:  max(size.width, int(float64(bounds.Dx())*scale+0.5)),
+		height: max(size.height, int(float64(bounds.Dy())*scale+0.5)),
+	})
+
+	scaledBounds := scaled.Bounds()
+	offset := image.Pt(
+		scaledBounds.Min.X+(scaledBounds.Dx()-size.width)/2,
+		scaledBounds.Min.Y+(scaledBounds.Dy()-size.height)/2,
+	)
+
+	destImg := image.NewRGBA(image.Rect(0, 0, size.width, size.height))
+	draw.Draw(destImg, destImg.Bounds(), scaled, offset, draw.Src)
+
+	return destImg
+}
+
+// coverSize returns the smallest size with the aspect ratio of bounds that
+// covers box.
+func coverSize(bounds image.Rectangle, box Size) Size {
+	scale := max(
+		float64(box.width)/float64(bounds.Dx()),
+		float64(box.height)/float64(bounds.Dy()),
+	)
+
+	return Size{
+		width:  max(box.width, int(float64(bounds.Dx())*scale+0.5)),
+		height: max(box.height, int(float64(bounds.Dy())*scale+0.5)),
+	}
+}
+
+// geometry is a parsed --resize value.
+type geometry struct {
+	size Size
+	// scale is set instead of size by a percentage.
+	scale float64
+	// keepAspect, noUpscale and onlyEnlarge are what the modifier implies
+	// for the matching Config fields.
+	keepAspect  string
+	noUpscale   bool
+	onlyEnlarge bool
+	// modifier reports whether the value had an ImageMagick modifier.
+	modifier bool
+}
+
+// parseGeometry parses a --resize value written like an ImageMagick
+// geometry: WIDTHxHEIGHT, optionally followed by ! to force the exact size,
+// > to only shrink the image to fit within it, < to only enlarge it to fit
+// or ^ to fill it, or a
+// percentage such as 50% to scale by. Unlike ImageMagick, a bare
+// WIDTHxHEIGHT keeps its long-standing meaning here and resizes to exactly
+// that size; --keep-aspect=fit is what ImageMagick does by default.
+func parseGeometry(geometryStr string) (geometry, error) {
+	if percentStr, ok := strings.CutSuffix(geometryStr, "%"); ok {
+		percent, err := strconv.ParseFloat(percentStr, 64)
+		if err != nil || percent <= 0 || math.IsInf(percent, 0) {
+			return geometry{}, fmt.Errorf("invalid geometry %q: expected a positive percentage", geometryStr)
+		}
+
+		return geometry{scale: percent / 100, modifier: true}, nil
+	}
+
+	var g geometry
+	switch last := geometryStr[max(0, len(geometryStr)-1):]; last {
+	case "!":
+		g.modifier = true
+	case ">":
+		g.keepAspect, g.noUpscale, g.modifier = "fit", true, true
+	case "<":
+		g.keepAspect, g.onlyEnlarge, g.modifier = "fit", true, true
+	case "^":
+		g.keepAspect, g.modifier = "cover", true
+	}
+	if g.modifier {
+		geometryStr = geometryStr[:len(geometryStr)-1]
+	}
+
+	// A single dimension, WIDTHx or xHEIGHT, leaves the other to follow the
+	// aspect ratio, which every modifier but ! already implies.
+	if widthStr, ok := strings.CutSuffix(strings.ToLower(geometryStr), "x"); ok {
+		width, err := strconv.Atoi(widthStr)
+		if err != nil || width <= 0 {
+			return geometry{}, fmt.Errorf("invalid geometry %q: width must be a positive number", geometryStr)
+		}
+		g.size, g.keepAspect = Size{width: width}, ""
+		return g, nil
+	}
+	if heightStr, ok := strings.CutPrefix(strings.ToLower(geometryStr), "x"); ok {
+		height, err := strconv.Atoi(heightStr)
+		if err != nil || height <= 0 {
+			return geometry{}, fmt.Errorf("invalid geometry %q: height must be a positive number", geometryStr)
+		}
+		g.size, g.keepAspect = Size{height: height}, ""
+		return g, nil
+	}
+
+	width, height, err := parseDimensions(geometryStr)
+	if err != nil {
+		return geometry{}, err
+	}
+	g.size = Size{width: width, height: height}
+
+	return g, nil
+}
+
+// isPartial reports whether s gives only one dimension, the other to be
+// derived from the aspect ratio, see proportionalSize.
+func (s Size) isPartial() bool {
+	return (s.width == 0) != (s.height == 0)
+}
+
+// proportionalSize fills in the missing dimension of a partial size from the
+// aspect ratio of bounds.
+func proportionalSize(bounds image.Rectangle, size Size) Size {
+	if size.width == 0 {
+		size.width = max(1, int(float64(bounds.Dx())*float64(size.height)/float64(bounds.Dy())+0.5))
+	} else if size.height == 0 {
+		size.height = max(1, int(float64(bounds.Dy())*float64(size.width)/float64(bounds.Dx())+0.5))
+	}
+
+	return size
+}
+
+// String formats s as WIDTHxHEIGHT, leaving out a missing dimension.
+func (s Size) String() string {
+	dim := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+
+	return dim(s.width) + "x" + dim(s.height)
+}
+
+// fitSize returns the largest size with the aspect ratio of bounds that fits
+// within box.
+func fitSize(bounds image.Rectangle, box Size) Size {
+	scale := min(
+		float64(box.width)/float64(bounds.Dx()),
+		float64(box.height)/float64(bounds.Dy()),
+	)
+
+	return Size{
+		width:  max(1, int(float64(bounds.Dx())*scale+0.5)),
+		height: max(1, int(float64(bounds.Dy())*scale+0.5)),
+	}
+}
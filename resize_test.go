@@ -0,0 +1,221 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// testPattern returns an image with hard edges and alpha, which shows up
+// filters that differ.
+func testPattern(width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			c := color.NRGBA{R: uint8(x * 7), G: uint8(y * 3), B: uint8((x/16 + y/16) % 2 * 255), A: uint8(128 + x%128)}
+			img.Set(x, y, c)
+		}
+	}
+
+	return img
+}
+
+func TestDownscaleStripsMatchesCatmullRom(t *testing.T) {
+	src := testPattern(999, 601)
+
+	for _, size := range []Size{{100, 60}, {500, 600}, {17, 3}} {
+		want := image.NewRGBA(image.Rect(0, 0, size.width, size.height))
+		xdraw.CatmullRom.Scale(want, want.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+		got := downscaleStrips(src, size)
+
+		var diff, worst int
+		for i := range got.Pix {
+			d := abs(int(got.Pix[i]) - int(want.Pix[i]))
+			diff += d
+			worst = max(worst, d)
+		}
+		if mean := float64(diff) / float64(len(got.Pix)); mean > 0.5 || worst > 8 {
+			t.Errorf("%v: strips differ from the full scaler by %.2f on average and %d at worst", size, mean, worst)
+		}
+	}
+}
+
+// BenchmarkDownscale compares the memory the strip path and the full-image
+// scaler allocate; B/op is close to their peak use.
+func BenchmarkDownscale(b *testing.B) {
+	src := testPattern(4096, 4096)
+	size := Size{width: 512, height: 512}
+
+	b.Run("strips", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			downscaleStrips(src, size)
+		}
+	})
+
+	b.Run("full", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			destImg := image.NewRGBA(image.Rect(0, 0, size.width, size.height))
+			xdraw.CatmullRom.Scale(destImg, destImg.Bounds(), src, src.Bounds(), draw.Src, nil)
+		}
+	})
+}
+
+func TestParseGeometry(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    geometry
+		wantErr string
+	}{
+		{in: "800x600", want: geometry{size: Size{width: 800, height: 600}}},
+		{in: "800X600", want: geometry{size: Size{width: 800, height: 600}}},
+		{in: "800x", want: geometry{size: Size{width: 800}}},
+		{in: "x600", want: geometry{size: Size{height: 600}}},
+		{in: "800x600!", want: geometry{size: Size{width: 800, height: 600}, modifier: true}},
+		{in: "800x600>", want: geometry{size: Size{width: 800, height: 600}, keepAspect: "fit", noUpscale: true, modifier: true}},
+		{in: "800x>", want: geometry{size: Size{width: 800}, noUpscale: true, modifier: true}},
+		{in: "800x600<", want: geometry{size: Size{width: 800, height: 600}, keepAspect: "fit", onlyEnlarge: true, modifier: true}},
+		{in: "800x600^", want: geometry{size: Size{width: 800, height: 600}, keepAspect: "cover", modifier: true}},
+		{in: "50%", want: geometry{scale: 0.5, modifier: true}},
+		{in: "250%", want: geometry{scale: 2.5, modifier: true}},
+		{in: "12.5%", want: geometry{scale: 0.125, modifier: true}},
+		{in: "0x600", wantErr: "must be positive"},
+		{in: "800x0!", wantErr: "must be positive"},
+		{in: "0%", wantErr: "positive percentage"},
+		{in: "0x", wantErr: "width must be a positive number"},
+		{in: "x0", wantErr: "height must be a positive number"},
+		{in: "-800x600", wantErr: "must be positive"},
+		{in: "x-5", wantErr: "height must be a positive number"},
+		{in: "-50%", wantErr: "positive percentage"},
+		{in: "", wantErr: "expected WIDTHxHEIGHT"},
+		{in: "big", wantErr: "expected WIDTHxHEIGHT"},
+		{in: "800x600x", wantErr: "width must be a positive number"},
+		{in: "wide%", wantErr: "positive percentage"},
+		{in: "800xtall", wantErr: "parse height"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseGeometry(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseGeometry(%q) error = %v, want one containing %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseGeometry(%q) error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseGeometry(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResizeTargetModifiers(t *testing.T) {
+	tests := []struct {
+		geometry string
+		bounds   image.Rectangle
+		want     Size
+	}{
+		{"400x300>", image.Rect(0, 0, 800, 400), Size{width: 400, height: 200}},
+		{"400x300>", image.Rect(0, 0, 200, 100), Size{width: 200, height: 100}},
+		{"400x300<", image.Rect(0, 0, 200, 100), Size{width: 400, height: 200}},
+		{"400x300<", image.Rect(0, 0, 800, 400), Size{width: 800, height: 400}},
+		{"400x300<", image.Rect(0, 0, 100, 300), Size{width: 100, height: 300}},
+		{"400x<", image.Rect(0, 0, 200, 100), Size{width: 400, height: 200}},
+		{"400x300^", image.Rect(0, 0, 800, 400), Size{width: 600, height: 300}},
+		{"400x300!", image.Rect(0, 0, 800, 400), Size{width: 400, height: 300}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.geometry+" "+tt.bounds.Size().String(), func(t *testing.T) {
+			g, err := parseGeometry(tt.geometry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := testConfig()
+			config.resize, config.keepAspect, config.noUpscale, config.onlyEnlarge = g.size, g.keepAspect, g.noUpscale, g.onlyEnlarge
+			if got := resizeTarget(tt.bounds, config); got != tt.want {
+				t.Errorf("resizeTarget(%v) = %v, want %v", tt.bounds, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoUpscale(t *testing.T) {
+	bounds := image.Rect(0, 0, 100, 50)
+
+	tests := []struct {
+		name       string
+		resize     Size
+		keepAspect string
+		scale      float64
+		want       Size
+	}{
+		{"box of the same aspect", Size{width: 400, height: 200}, "", 0, Size{width: 100, height: 50}},
+		{"square box", Size{width: 400, height: 400}, "", 0, Size{width: 50, height: 50}},
+		{"square box kept in aspect", Size{width: 400, height: 400}, "fit", 0, Size{width: 100, height: 50}},
+		{"width only", Size{width: 300}, "", 0, Size{width: 100, height: 50}},
+		{"taller only", Size{width: 80, height: 200}, "", 0, Size{width: 20, height: 50}},
+		{"scale", Size{}, "", 3, Size{width: 100, height: 50}},
+		{"smaller box", Size{width: 60, height: 30}, "", 0, Size{width: 60, height: 30}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.resize, config.keepAspect, config.scale, config.noUpscale = tt.resize, tt.keepAspect, tt.scale, true
+			if got := resizeTarget(bounds, config); got != tt.want {
+				t.Errorf("resizeTarget(100x50) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepAspectMismatch(t *testing.T) {
+	landscape := image.Rect(0, 0, 800, 400)
+	portrait := image.Rect(0, 0, 400, 800)
+
+	tests := []struct {
+		name        string
+		bounds      image.Rectangle
+		resize      Size
+		keepAspect  string
+		want        Size
+		wantPadding Padding
+	}{
+		{"landscape in portrait box fit", landscape, Size{width: 200, height: 300}, "fit", Size{width: 200, height: 100}, Padding{}},
+		{"landscape in portrait box letterbox", landscape, Size{width: 200, height: 300}, "letterbox", Size{width: 200, height: 100}, Padding{Top: 100, Bottom: 100}},
+		{"landscape in portrait box cover", landscape, Size{width: 200, height: 300}, "cover", Size{width: 600, height: 300}, Padding{}},
+		{"portrait in landscape box fit", portrait, Size{width: 300, height: 200}, "fit", Size{width: 100, height: 200}, Padding{}},
+		{"portrait in landscape box letterbox", portrait, Size{width: 301, height: 200}, "letterbox", Size{width: 100, height: 200}, Padding{Left: 100, Right: 101}},
+		{"portrait in landscape box cover", portrait, Size{width: 300, height: 200}, "cover", Size{width: 300, height: 600}, Padding{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.resize, config.keepAspect = tt.resize, tt.keepAspect
+
+			got := resizeTarget(tt.bounds, config)
+			if got != tt.want {
+				t.Fatalf("resizeTarget(%v) = %v, want %v", tt.bounds, got, tt.want)
+			}
+
+			resized := image.Rect(0, 0, got.width, got.height)
+			if padding := canvasPadding(resized, config); padding != tt.wantPadding {
+				t.Errorf("canvasPadding(%v) = %+v, want %+v", resized, padding, tt.wantPadding)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// rotatedSize returns the size of the canvas that fits a width x height
+// image rotated by degrees.
+func rotatedSize(width int, height int, degrees float64) Size {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	w, h := float64(width), float64(height)
+
+	// The epsilon keeps rounding errors from growing the canvas at exact
+	// angles such as 90 degrees.
+	return Size{
+		width:  max(1, int(math.Ceil(math.Abs(w*cos)+math.Abs(h*sin)-1e-9))),
+		height: max(1, int(math.Ceil(math.Abs(w*sin)+math.Abs(h*cos)-1e-9))),
+	}
+}
+
+// rotate turns img clockwise by degrees around its center. The canvas grows
+// to fit the whole rotated image, or keeps the source size when crop is set.
+// Every destination pixel is mapped back onto the source and sampled
+// bilinearly; the exposed corners are left transparent for the pad stage to
+// fill with the background.
+func rotate(img image.Image, degrees float64, crop bool) *image.NRGBA {
+	src := toNRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+
+	size := Size{width: width, height: height}
+	if !crop {
+		size = rotatedSize(width, height, degrees)
+	}
+	destImg := image.NewNRGBA(image.Rect(0, 0, size.width, size.height))
+
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	cx, cy := float64(width)/2, float64(height)/2
+	dcx, dcy := float64(size.width)/2, float64(size.height)/2
+
+	for y := range size.height {
+		dy := float64(y) + 0.5 - dcy
+		for x := range size.width {
+			dx := float64(x) + 0.5 - dcx
+
+			// Source coordinates relative to pixel centers.
+			sx := dx*cos + dy*sin + cx - 0.5
+			sy := -dx*sin + dy*cos + cy - 0.5
+
+			c := sampleBilinear(src, sx, sy)
+			copy(destImg.Pix[destImg.PixOffset(x, y):], c[:])
+		}
+	}
+
+	return destImg
+}
+
+// sampleBilinear interpolates src at (x, y) in pixel center coordinates.
+// Pixels outside src count as transparent, which antialiases the edges of a
+// rotated image. Colors are weighted by alpha so they don't bleed from
+// transparent pixels.
+func sampleBilinear(src *image.NRGBA, x float64, y float64) [4]uint8 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+
+	var sum [4]float64
+	for _, p := range [4]struct {
+		x, y   int
+		weight float64
+	}{
+		{int(x0), int(y0), (1 - fx) * (1 - fy)},
+		{int(x0) + 1, int(y0), fx * (1 - fy)},
+		{int(x0), int(y0) + 1, (1 - fx) * fy},
+		{int(x0) + 1, int(y0) + 1, fx * fy},
+	} {
+		if p.weight == 0 || !(image.Point{p.x, p.y}.In(src.Rect)) {
+			continue
+		}
+
+		i := src.PixOffset(p.x, p.y)
+		alpha := float64(src.Pix[i+3]) * p.weight
+		sum[0] += float64(src.Pix[i]) * alpha
+		sum[1] += float64(src.Pix[i+1]) * alpha
+		sum[2] += float64(src.Pix[i+2]) * alpha
+		sum[3] += alpha
+	}
+
+	if sum[3] == 0 {
+		return [4]uint8{}
+	}
+
+	return [4]uint8{
+		uint8(sum[0]/sum[3] + 0.5),
+		uint8(sum[1]/sum[3] + 0.5),
+		uint8(sum[2]/sum[3] + 0.5),
+		uint8(sum[3] + 0.5),
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"testing"
+)
+
+func TestRotatedSize(t *testing.T) {
+	tests := []struct {
+		width, height int
+		degrees       float64
+		want          Size
+	}{
+		// (100 + 50) * cos 45° is 106.07.
+		{100, 50, 45, Size{width: 107, height: 107}},
+		{100, 100, 45, Size{width: 142, height: 142}},
+		{100, 50, -45, Size{width: 107, height: 107}},
+		{100, 50, 135, Size{width: 107, height: 107}},
+		{100, 50, 90, Size{width: 50, height: 100}},
+		{100, 50, 180, Size{width: 100, height: 50}},
+		{100, 50, 0, Size{width: 100, height: 50}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%dx%d by %g", tt.width, tt.height, tt.degrees), func(t *testing.T) {
+			if got := rotatedSize(tt.width, tt.height, tt.degrees); got != tt.want {
+				t.Errorf("rotatedSize(%d, %d, %g) = %v, want %v", tt.width, tt.height, tt.degrees, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotate45(t *testing.T) {
+	tests := []struct {
+		crop bool
+		want image.Point
+	}{
+		{false, image.Pt(107, 107)},
+		{true, image.Pt(100, 50)},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("crop=%v", tt.crop), func(t *testing.T) {
+			out := rotate(testJPEG(t, 100, 50), 45, tt.crop)
+			if got := out.Rect.Size(); got != tt.want {
+				t.Fatalf("rotated to %v, want %v", got, tt.want)
+			}
+
+			if a := out.NRGBAAt(0, 0).A; a != 0 {
+				t.Errorf("exposed corner alpha = %d, want 0", a)
+			}
+			if a := out.NRGBAAt(tt.want.X/2, tt.want.Y/2).A; a != 0xff {
+				t.Errorf("center alpha = %d, want 255", a)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxShadowBlur bounds --shadow-blur; wider shadows look no different
+	// from a faint uniform tint.
+	maxShadowBlur = 100
+
+	// boxBlurMinSigma is the deviation from which gaussianBlur approximates
+	// the Gaussian with box blurs. The exact kernel samples 6σ neighbours
+	// per pixel, which gets slow on large canvases.
+	boxBlurMinSigma = 16
+)
+
+// Shadow describes the --shadow drop shadow.
+type Shadow struct {
+	color   color.Color
+	opacity float64
+	// blur is the standard deviation of the Gaussian blur, in pixels.
+	blur   float64
+	offset image.Point
+}
+
+// parseOffset parses an "X,Y" pixel offset.
+func parseOffset(offsetStr string) (image.Point, error) {
+	xStr, yStr, ok := strings.Cut(offsetStr, ",")
+	if !ok {
+		return image.Point{}, fmt.Errorf("invalid offset %q: expected X,Y", offsetStr)
+	}
+
+	x, err := strconv.Atoi(strings.TrimSpace(xStr))
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid offset %q: x %q is not an integer", offsetStr, xStr)
+	}
+
+	y, err := strconv.Atoi(strings.TrimSpace(yStr))
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid offset %q: y %q is not an integer", offsetStr, yStr)
+	}
+
+	return image.Pt(x, y), nil
+}
+
+// drawShadow draws a blurred, offset silhouette of img's alpha onto destImg,
+// as if img were about to be drawn at rect. The shadow is clipped to
+// destImg, so it needs padding on the offset side to be visible.
+func drawShadow(destImg draw.Image, img image.Image, rect image.Rectangle, shadow *Shadow) {
+	canvas := destImg.Bounds()
+	mask := image.NewAlpha(canvas)
+	draw.Draw(mask, rect.Add(shadow.offset), img, img.Bounds().Min, draw.Src)
+
+	gaussianBlur(mask, shadow.blur)
+
+	for i, a := range mask.Pix {
+		mask.Pix[i] = uint8(float64(a)*shadow.opacity + 0.5)
+	}
+
+	draw.DrawMask(destImg, canvas, image.NewUniform(shadow.color), image.Point{}, mask, canvas.Min, draw.Over)
+}
+
+// gaussianKernel returns normalized weights for a Gaussian with the given
+// standard deviation, covering three deviations on each side.
+func gaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+
+	var sum float64
+	for i := range kernel {
+		x := float64(i - radius)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	return kernel
+}
+
+// gaussianBlur blurs mask in place with a separable Gaussian blur, or from
+// boxBlurMinSigma on with three box blurs that approximate it at a cost
+// independent of sigma. Pixels beyond the edges count as transparent.
+func gaussianBlur(mask *image.Alpha, sigma float64) {
+	if sigma <= 0 {
+		return
+	}
+	if sigma >= boxBlurMinSigma {
+		boxBlur(mask, sigma)
+		return
+	}
+
+	kernel := gaussianKernel(sigma)
+	radius := len(kernel) / 2
+	width, height := mask.Rect.Dx(), mask.Rect.Dy()
+	tmp := make([]float64, width*height)
+
+	for y := range height {
+		row := mask.Pix[y*mask.Stride:]
+		for x := range width {
+			var sum float64
+			for k, weight := range kernel {
+				if sx := x + k - radius; sx >= 0 && sx < width {
+					sum += weight * float64(row[sx])
+				}
+			}
+			tmp[y*width+x] = sum
+		}
+	}
+
+	for y := range height {
+		for x := range width {
+			var sum float64
+			for k, weight := range kernel {
+				if sy := y + k - radius; sy >= 0 && sy < height {
+					sum += weight * tmp[sy*width+x]
+				}
+			}
+			mask.Pix[y*mask.Stride+x] = uint8(min(255, sum+0.5))
+		}
+	}
+}
+
+// boxBlur approximates a Gaussian blur of mask with three successive box
+// blurs, each a running sum over its window.
+func boxBlur(mask *image.Alpha, sigma float64) {
+	width, height := mask.Rect.Dx(), mask.Rect.Dy()
+	buf := make([]float64, width*height)
+	tmp := make([]float64, width*height)
+	for y := range height {
+		for x := range width {
+			buf[y*width+x] = float64(mask.Pix[y*mask.Stride+x])
+		}
+	}
+
+	for _, size := range gaussianBoxSizes(sigma) {
+		boxPass(buf, tmp, height, width, 1, width, size)
+		boxPass(tmp, buf, width, height, width, 1, size)
+	}
+
+	for y := range height {
+		for x := range width {
+			mask.Pix[y*mask.Stride+x] = uint8(min(255, buf[y*width+x]+0.5))
+		}
+	}
+}
+
+// gaussianBoxSizes returns the odd widths of three box blurs whose
+// combined variance is closest to sigma².
+func gaussianBoxSizes(sigma float64) [3]int {
+	ideal := math.Sqrt(4*sigma*sigma + 1)
+	lower := int(math.Floor(ideal))
+	if lower%2 == 0 {
+		lower--
+	}
+
+	l := float64(lower)
+	smaller := int(math.Round((12*sigma*sigma - 3*l*l - 12*l - 9) / (-4*l - 4)))
+
+	var sizes [3]int
+	for i := range sizes {
+		sizes[i] = lower
+		if i >= smaller {
+			sizes[i] = lower + 2
+		}
+	}
+
+	return sizes
+}
+
+// boxPass averages lines of src into dst over a window of size samples
+// centered on each one. step is the distance between samples of a line and
+// stride the distance between lines, so one function blurs rows and
+// columns alike.
+func boxPass(src []float64, dst []float64, lines int, length int, step int, stride int, size int) {
+	radius := size / 2
+	for line := range lines {
+		base := line * stride
+		var sum float64
+		for i := range min(radius, length) {
+			sum += src[base+i*step]
+		}
+
+		for i := range length {
+			if j := i + radius; j < length {
+				sum += src[base+j*step]
+			}
+			if j := i - radius - 1; j >= 0 {
+				sum -= src[base+j*step]
+			}
+			dst[base+i*step] = sum / float64(size)
+		}
+	}
+}
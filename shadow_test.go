@@ -0,0 +1,104 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoxBlurApproximatesGaussian(t *testing.T) {
+	for _, sigma := range []float64{boxBlurMinSigma, 40} {
+		exact := image.NewAlpha(image.Rect(0, 0, 300, 200))
+		for y := 60; y < 140; y++ {
+			for x := 90; x < 210; x++ {
+				exact.Pix[exact.PixOffset(x, y)] = 255
+			}
+		}
+		approx := image.NewAlpha(exact.Rect)
+		copy(approx.Pix, exact.Pix)
+
+		exactGaussian(exact, sigma)
+		boxBlur(approx, sigma)
+
+		var worst int
+		for i := range exact.Pix {
+			worst = max(worst, abs(int(exact.Pix[i])-int(approx.Pix[i])))
+		}
+		if worst > 8 {
+			t.Errorf("sigma %g: box blur differs from the Gaussian by up to %d", sigma, worst)
+		}
+	}
+}
+
+// exactGaussian applies gaussianKernel separably, whatever the sigma.
+func exactGaussian(mask *image.Alpha, sigma float64) {
+	kernel := gaussianKernel(sigma)
+	radius := len(kernel) / 2
+	width, height := mask.Rect.Dx(), mask.Rect.Dy()
+	tmp := make([]float64, width*height)
+
+	for y := range height {
+		for x := range width {
+			var sum float64
+			for k, weight := range kernel {
+				if sx := x + k - radius; sx >= 0 && sx < width {
+					sum += weight * float64(mask.Pix[y*mask.Stride+sx])
+				}
+			}
+			tmp[y*width+x] = sum
+		}
+	}
+
+	for y := range height {
+		for x := range width {
+			var sum float64
+			for k, weight := range kernel {
+				if sy := y + k - radius; sy >= 0 && sy < height {
+					sum += weight * tmp[sy*width+x]
+				}
+			}
+			mask.Pix[y*mask.Stride+x] = uint8(min(255, sum+0.5))
+		}
+	}
+}
+
+func TestShadowLandsInPadding(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset image.Point
+		// shadowed lies in the padding under the shadow, clear in the padding
+		// beside it.
+		shadowed, clear image.Point
+	}{
+		{"down right", image.Pt(10, 10), image.Pt(65, 65), image.Pt(65, 25)},
+		{"up left", image.Pt(-10, -10), image.Pt(15, 15), image.Pt(55, 15)},
+		{"right only", image.Pt(12, 0), image.Pt(68, 40), image.Pt(40, 68)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 40, 40))
+
+			config := testConfig()
+			config.padding = Padding{Top: 20, Right: 20, Bottom: 20, Left: 20}
+			config.shadow = &Shadow{color: color.Black, opacity: 1, blur: 1, offset: tt.offset}
+			outputFile := filepath.Join(dir, "out.png")
+			if err := convertImage(inputFile, outputFile, config); err != nil {
+				t.Fatal(err)
+			}
+
+			out := readTestImage(t, outputFile)
+			if got := out.Bounds().Size(); got != image.Pt(80, 80) {
+				t.Fatalf("output is %v, want 80x80", got)
+			}
+			if r, g, b, a := out.At(tt.shadowed.X, tt.shadowed.Y).RGBA(); a < 0xf000 || r|g|b > 0x1000 {
+				t.Errorf("pixel %v under the shadow = %v, want opaque black", tt.shadowed, out.At(tt.shadowed.X, tt.shadowed.Y))
+			}
+			if _, _, _, a := out.At(tt.clear.X, tt.clear.Y).RGBA(); a > 0x1000 {
+				t.Errorf("pixel %v beside the shadow has alpha %d, want transparent padding", tt.clear, a)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"image"
+)
+
+// smartCrop scales img, keeping its aspect ratio, until it covers size and
+// then crops the window of that size holding the most edge energy, rather
+// than the centered one coverImage keeps. Of equally busy windows the one
+// closest to the center wins. It also returns the window's offset.
+func smartCrop(img image.Image, size Size) (*image.NRGBA, image.Point) {
+	src := toNRGBA(resizeImage(img, coverSize(img.Bounds(), size)))
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+
+	// integral[y*(width+1)+x] sums the energy of the pixels above and left
+	// of (x, y), so any window's total takes four lookups.
+	stride := width + 1
+	integral := make([]int64, stride*(height+1))
+	for y := range height {
+		var rowSum int64
+		for x := range width {
+			rowSum += int64(edgeEnergy(src, x, y))
+			integral[(y+1)*stride+x+1] = integral[y*stride+x+1] + rowSum
+		}
+	}
+
+	center := image.Pt((width-size.width)/2, (height-size.height)/2)
+	best, bestEnergy := center, int64(-1)
+	for y := 0; y+size.height <= height; y++ {
+		for x := 0; x+size.width <= width; x++ {
+			energy := integral[(y+size.height)*stride+x+size.width] - integral[y*stride+x+size.width] -
+				integral[(y+size.height)*stride+x] + integral[y*stride+x]
+
+			pt := image.Pt(x, y)
+			if energy > bestEnergy || energy == bestEnergy && centerDistance(pt, center) < centerDistance(best, center) {
+				best, bestEnergy = pt, energy
+			}
+		}
+	}
+
+	return toNRGBA(src.SubImage(image.Rectangle{Min: best, Max: best.Add(image.Pt(size.width, size.height))})), best
+}
+
+// edgeEnergy is the luminance gradient at (x, y) of img: the absolute
+// difference to the right and lower neighbours, weighted by opacity so
+// transparent areas count as empty.
+func edgeEnergy(img *image.NRGBA, x int, y int) int {
+	i := img.PixOffset(x, y)
+	l := int(luma(img.Pix[i], img.Pix[i+1], img.Pix[i+2]))
+
+	var energy int
+	if x+1 < img.Rect.Dx() {
+		j := i + 4
+		energy += abs(l - int(luma(img.Pix[j], img.Pix[j+1], img.Pix[j+2])))
+	}
+	if y+1 < img.Rect.Dy() {
+		j := i + img.Stride
+		energy += abs(l - int(luma(img.Pix[j], img.Pix[j+1], img.Pix[j+2])))
+	}
+
+	return energy * int(img.Pix[i+3]) / 255
+}
+
+func centerDistance(pt image.Point, center image.Point) int {
+	return abs(pt.X-center.X) + abs(pt.Y-center.Y)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// windowEnergy sums the edge energy of img within r.
+func windowEnergy(img *image.NRGBA, r image.Rectangle) int {
+	var energy int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			energy += edgeEnergy(img, x, y)
+		}
+	}
+
+	return energy
+}
+
+func TestSmartCropFindsOffCenterSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject image.Rectangle
+		size    Size
+	}{
+		{"left", image.Rect(10, 30, 50, 70), Size{width: 60, height: 100}},
+		{"right", image.Rect(160, 20, 190, 60), Size{width: 60, height: 100}},
+		{"top", image.Rect(5, 2, 35, 22), Size{width: 200, height: 40}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A flat gray field with a checkered subject away from the center.
+			// It already covers size, so the window slides over it unscaled.
+			img := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+			for y := range 100 {
+				for x := range 200 {
+					c := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+					if (image.Point{x, y}).In(tt.subject) && (x/4+y/4)%2 == 0 {
+						c = color.NRGBA{R: 250, G: 250, B: 250, A: 255}
+					}
+					img.SetNRGBA(x, y, c)
+				}
+			}
+
+			cropped, offset := smartCrop(img, tt.size)
+			if got := cropped.Bounds().Size(); got != image.Pt(tt.size.width, tt.size.height) {
+				t.Fatalf("crop is %v, want %v", got, tt.size)
+			}
+
+			window := image.Rectangle{Min: offset, Max: offset.Add(image.Pt(tt.size.width, tt.size.height))}
+			if !tt.subject.In(window) {
+				t.Errorf("smart crop %v misses the subject at %v", window, tt.subject)
+			}
+
+			center := image.Pt((200-tt.size.width)/2, (100-tt.size.height)/2)
+			centered := image.Rectangle{Min: center, Max: center.Add(image.Pt(tt.size.width, tt.size.height))}
+			smartEnergy, centerEnergy := windowEnergy(img, window), windowEnergy(img, centered)
+			if smartEnergy <= centerEnergy {
+				t.Errorf("smart crop holds %d energy, no more than the center crop's %d", smartEnergy, centerEnergy)
+			}
+		})
+	}
+}
+
+func TestSmartCropPrefersCenterOnFlatImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 120, 80))
+	if _, offset := smartCrop(img, Size{width: 40, height: 80}); offset != image.Pt(40, 0) {
+		t.Errorf("smart crop of a flat image at %v, want the center crop at (40,0)", offset)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// maxSplitTiles bounds the number of files a split writes, so a tiny
+// --split-cell doesn't quietly fill a directory with millions of images.
+const maxSplitTiles = 10000
+
+// splitLayout returns the grid and cell size that cut an image with the
+// given bounds into either grid.width x grid.height cells or cells of the
+// given size; exactly one of grid and cell is set. Grids of more than
+// maxSplitTiles cells are rejected.
+func splitLayout(bounds image.Rectangle, grid Size, cell Size) (Size, Size, error) {
+	if !grid.isZero() {
+		cell = Size{width: bounds.Dx() / grid.width, height: bounds.Dy() / grid.height}
+		if cell.width == 0 || cell.height == 0 {
+			return Size{}, Size{}, fmt.Errorf(
+				"cannot split a %dx%d image into a %dx%d grid",
+				bounds.Dx(), bounds.Dy(), grid.width, grid.height,
+			)
+		}
+
+		return grid, cell, nil
+	}
+
+	grid = Size{width: bounds.Dx() / cell.width, height: bounds.Dy() / cell.height}
+	if grid.width == 0 || grid.height == 0 {
+		return Size{}, Size{}, fmt.Errorf(
+			"cannot split a %dx%d image into %dx%d cells",
+			bounds.Dx(), bounds.Dy(), cell.width, cell.height,
+		)
+	}
+
+	if tiles := grid.width * grid.height; tiles > maxSplitTiles {
+		return Size{}, Size{}, fmt.Errorf(
+			"splitting a %dx%d image into %dx%d cells would write %d files, more than the limit of %d",
+			bounds.Dx(), bounds.Dy(), cell.width, cell.height, tiles, maxSplitTiles,
+		)
+	}
+
+	return grid, cell, nil
+}
+
+// splitOutputName expands the {r} and {c} placeholders of template to the
+// zero-based row and column of a cell.
+func splitOutputName(template string, row int, col int) string {
+	return strings.NewReplacer("{r}", strconv.Itoa(row), "{c}", strconv.Itoa(col)).Replace(template)
+}
+
+// splitImage cuts inputFile into a grid of cells, the inverse of a contact
+// sheet, and renders each one to the file template names for its row and
+// column. Pixels left over at the right and bottom when the image doesn't
+// divide evenly are cropped with a warning. It returns the number of files
+// written.
+func splitImage(inputFile string, template string, grid Size, cell Size, config *Config) (int, error) {
+	srcImg, err := readImage(inputFile, config)
+	if err != nil {
+		return 0, err
+	}
+
+	meta := readMetadata(inputFile, config)
+	srcImg = toSRGB(srcImg, meta)
+
+	bounds := srcImg.Bounds()
+	grid, cell, err = splitLayout(bounds, grid, cell)
+	if err != nil {
+		return 0, err
+	}
+
+	if grid.width > 1 && !strings.Contains(template, "{c}") {
+		return 0, fmt.Errorf("invalid output name %q: must contain {c} to tell columns apart", template)
+	}
+	if grid.height > 1 && !strings.Contains(template, "{r}") {
+		return 0, fmt.Errorf("invalid output name %q: must contain {r} to tell rows apart", template)
+	}
+
+	if extraX, extraY := bounds.Dx()-grid.width*cell.width, bounds.Dy()-grid.height*cell.height; extraX > 0 || extraY > 0 {
+		if err := warn(config, "%s does not divide evenly, cropping %d columns and %d rows of pixels", config.displayName(inputFile), extraX, extraY); err != nil {
+			return 0, err
+		}
+	}
+
+	written := 0
+	for row := range grid.height {
+		for col := range grid.width {
+			outputFile := splitOutputName(template, row, col)
+
+			bg := pngBackground(config)
+			if format := outputFormat(outputFile, config); format == "jpeg" || format == "gif" {
+				bg = config.background
+			}
+
+			origin := bounds.Min.Add(image.Pt(col*cell.width, row*cell.height))
+			cellImg := crop(srcImg, image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cell.width, cell.height))})
+
+			destImg, err := renderImage(cellImg, meta, bg, config)
+			if err != nil {
+				return written, fmt.Errorf("%s: %w", outputFile, err)
+			}
+
+			if err := writeImage(outputFile, destImg, meta, config); err != nil {
+				return written, err
+			}
+			written++
+		}
+	}
+
+	return written, nil
+}
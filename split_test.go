@@ -0,0 +1,21 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestSplitLayoutLimit(t *testing.T) {
+	bounds := image.Rect(0, 0, 1000, 1000)
+	if _, _, err := splitLayout(bounds, Size{}, Size{width: 1, height: 1}); err == nil {
+		t.Error("splitting into a million cells was allowed")
+	}
+
+	grid, _, err := splitLayout(bounds, Size{}, Size{width: 10, height: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grid != (Size{width: 100, height: 100}) {
+		t.Errorf("grid = %v, want 100x100", grid)
+	}
+}
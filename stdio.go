@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// spoolStdin copies standard input to a temporary file under --tmpdir, since
+// decoding and reading metadata each open the input by name. Without
+// --in-format the format is sniffed from the data. Warnings call the copy
+// "standard input" rather than its path. The returned directory holds the
+// file and is for the caller to remove.
+func spoolStdin(config *Config) (string, string, error) {
+	dir, err := os.MkdirTemp(config.tmpDir, "image-stdin-*")
+	if err != nil {
+		return "", "", err
+	}
+
+	inputFile := filepath.Join(dir, "stdin")
+	f, err := os.Create(inputFile)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	_, err = io.Copy(f, os.Stdin)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	if config.inFormat == "" {
+		config.inFormat = sniffFile(inputFile)
+		if config.inFormat == "unknown" {
+			os.RemoveAll(dir)
+			return "", "", errors.New("cannot tell the format of standard input, pass --in-format")
+		}
+	}
+
+	config.inputName = "standard input"
+
+	return inputFile, dir, nil
+}
+
+// convertToStdout converts inputFile into a temporary file in the
+// --out-format format and copies the result to standard output.
+func convertToStdout(inputFile string, config *Config) error {
+	dir, err := os.MkdirTemp(config.tmpDir, "image-stdout-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	outputFile := filepath.Join(dir, "stdout")
+	if err := convertImage(inputFile, outputFile, config); err != nil {
+		return err
+	}
+
+	f, err := os.Open(outputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(os.Stdout, f); err != nil {
+		return &outputError{err: err}
+	}
+
+	return nil
+}
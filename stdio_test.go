@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStandardInputAndOutput(t *testing.T) {
+	dir := t.TempDir()
+	inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 24, 16))
+	pngData, err := os.ReadFile(inputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := bytes.Repeat([]byte{255, 0, 0}, 4*3)
+
+	tests := []struct {
+		name  string
+		stdin []byte
+		args  []string
+		// output is the file the image is written to, or "" for stdout.
+		output     string
+		wantFormat string
+		wantSize   image.Point
+	}{
+		{"pipe", pngData, []string{"--to", "jpeg"}, "", "jpeg", image.Pt(24, 16)},
+		{"dashes", pngData, []string{"--to", "gif", "-", "-"}, "", "gif", image.Pt(24, 16)},
+		{"stdin to file", pngData, []string{"-", "out.jpg"}, "out.jpg", "jpeg", image.Pt(24, 16)},
+		{"file to stdout", nil, []string{"--to", "png", "in.png", "-"}, "", "png", image.Pt(24, 16)},
+		{"raw stdin with --from", raw, []string{"--from", "raw", "--raw-width", "4", "--raw-height", "3", "--raw-channels", "3", "-", "raw.png"}, "raw.png", "png", image.Pt(4, 3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			args := append([]string{"--tmpdir", tmpDir}, tt.args...)
+			code, stdout, stderr := pipeCommand(t, dir, tt.stdin, args...)
+			if code != 0 {
+				t.Fatalf("image %v exited with %d: %s", tt.args, code, stderr)
+			}
+
+			data := stdout
+			if tt.output != "" {
+				if data, err = os.ReadFile(filepath.Join(dir, tt.output)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decoding the output: %v", err)
+			}
+			if format != tt.wantFormat || cfg.Width != tt.wantSize.X || cfg.Height != tt.wantSize.Y {
+				t.Errorf("output is a %dx%d %s, want a %dx%d %s", cfg.Width, cfg.Height, format, tt.wantSize.X, tt.wantSize.Y, tt.wantFormat)
+			}
+
+			// Standard input is spooled under --tmpdir, and removed again.
+			if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+				t.Errorf("%d temporary files left in --tmpdir", len(entries))
+			}
+		})
+	}
+}
+
+func TestStandardInputErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 8, 8))
+
+	tests := []struct {
+		name     string
+		stdin    []byte
+		args     []string
+		wantCode int
+		wantErr  string
+	}{
+		{"unrecognized stdin without --from", []byte("not an image"), []string{"-", "out.png"}, 1, "pass --in-format"},
+		{"stdout without a format", nil, []string{"in.png", "-"}, exitUsage, "requires --out-format"},
+		{"stdout with --only-if-smaller", nil, []string{"--to", "png", "--only-if-smaller", "in.png", "-"}, exitUsage, "cannot be used when writing to standard output"},
+		{"stdin with --preserve-mtime", nil, []string{"--preserve-mtime", "-", "out.png"}, exitUsage, "cannot be used when reading standard input"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := pipeCommand(t, dir, tt.stdin, tt.args...)
+			if code != tt.wantCode || !strings.Contains(stderr, tt.wantErr) {
+				t.Errorf("image %v exited with %d: %s, want %d and an error containing %q", tt.args, code, stderr, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDataURIToStdout(t *testing.T) {
+	dir := t.TempDir()
+	inputFile := writeTestImage(t, filepath.Join(dir, "in.png"), testJPEG(t, 12, 10))
+	pngData, err := os.ReadFile(inputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		stdin  []byte
+		args   []string
+		prefix string
+	}{
+		{"file keeps its format", nil, []string{"--data-uri", "in.png"}, "data:image/png;base64,"},
+		{"file with --to", nil, []string{"--data-uri", "--to", "jpeg", "in.png"}, "data:image/jpeg;base64,"},
+		{"stdin", pngData, []string{"--data-uri", "-"}, "data:image/png;base64,"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := pipeCommand(t, dir, tt.stdin, tt.args...)
+			if code != 0 {
+				t.Fatalf("image %v exited with %d: %s", tt.args, code, stderr)
+			}
+
+			encoded, ok := strings.CutPrefix(strings.TrimSpace(string(stdout)), tt.prefix)
+			if !ok {
+				t.Fatalf("stdout %.40q does not start with %q", stdout, tt.prefix)
+			}
+			data, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || cfg.Width != 12 || cfg.Height != 10 {
+				t.Errorf("data URI holds a %dx%d image (%v), want 12x10", cfg.Width, cfg.Height, err)
+			}
+		})
+	}
+}
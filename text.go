@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	textMargin     = 8
+	textBoxPadding = 4
+)
+
+var datestampPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right"}
+
+var textPositions = []string{
+	"top-left", "top-center", "top-right",
+	"middle-left", "center", "middle-right",
+	"bottom-left", "bottom-center", "bottom-right",
+}
+
+func parseDatestampPosition(pos string) (string, error) {
+	if slices.Contains(datestampPositions, pos) {
+		return pos, nil
+	}
+
+	return "", fmt.Errorf("invalid datestamp position %q: expected one of %v", pos, datestampPositions)
+}
+
+func parseTextPosition(pos string) (string, error) {
+	if slices.Contains(textPositions, pos) {
+		return pos, nil
+	}
+
+	return "", fmt.Errorf("invalid text position %q: expected one of %v", pos, textPositions)
+}
+
+// loadFontFile returns a face for the TrueType/OpenType font at path.
+func loadFontFile(path string, size float64) (font.Face, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	face, err := parseFontFace(data, size)
+	if err != nil {
+		return nil, fmt.Errorf("parse font %s: %w", path, err)
+	}
+
+	return face, nil
+}
+
+// loadFontFace returns a face for the font at path, or for the bundled Go
+// Regular font when path is empty. A font that cannot be loaded falls back to
+// the bundled one, and if that fails too, to a fixed bitmap font that ignores
+// size.
+func loadFontFace(path string, size float64, config *Config) (font.Face, error) {
+	if path != "" {
+		face, err := loadFontFile(path, size)
+		if err == nil {
+			return face, nil
+		}
+
+		if err := warn(config, "%v, using the built-in font", err); err != nil {
+			return nil, err
+		}
+	}
+
+	face, err := parseFontFace(goregular.TTF, size)
+	if err != nil {
+		return basicfont.Face7x13, nil
+	}
+
+	return face, nil
+}
+
+func parseFontFace(data []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// drawText draws a single line of text anchored at pos within destImg,
+// optionally on top of a solid box to keep it legible on busy images.
+func drawText(destImg draw.Image, text string, pos string, face font.Face, textColor color.Color, boxColor color.Color) {
+	drawer := &font.Drawer{
+		Dst:  destImg,
+		Src:  image.NewUniform(textColor),
+		Face: face,
+	}
+
+	bounds := destImg.Bounds()
+	width := drawer.MeasureString(text).Ceil()
+	ascent := face.Metrics().Ascent.Ceil()
+	descent := face.Metrics().Descent.Ceil()
+	height := ascent + descent
+
+	var x int
+	switch {
+	case strings.HasSuffix(pos, "left"):
+		x = bounds.Min.X + textMargin
+	case strings.HasSuffix(pos, "right"):
+		x = bounds.Max.X - textMargin - width
+	default:
+		x = bounds.Min.X + (bounds.Dx()-width)/2
+	}
+
+	var y int
+	switch {
+	case strings.HasPrefix(pos, "top"):
+		y = bounds.Min.Y + textMargin
+	case strings.HasPrefix(pos, "bottom"):
+		y = bounds.Max.Y - textMargin - height
+	default:
+		y = bounds.Min.Y + (bounds.Dy()-height)/2
+	}
+
+	if boxColor != nil {
+		box := image.Rect(x, y, x+width, y+height).Inset(-textBoxPadding)
+		draw.Draw(destImg, box, image.NewUniform(boxColor), image.Point{}, draw.Over)
+	}
+
+	drawer.Dot = fixed.P(x, y+ascent)
+	drawer.DrawString(text)
+}
+
+// drawDatestamp writes the EXIF DateTimeOriginal of the source into a corner
+// of destImg. Sources without a capture date are left untouched.
+func drawDatestamp(destImg draw.Image, meta *Metadata, config *Config) error {
+	if !config.datestamp {
+		return nil
+	}
+
+	if meta.exif == nil {
+		return warn(config, "no EXIF metadata to take the datestamp from")
+	}
+
+	date, ok := meta.exif.dateTimeOriginal()
+	if !ok {
+		return warn(config, "no EXIF capture date to take the datestamp from")
+	}
+
+	// "2006:01:02 15:04:05" reads better as "2006-01-02 15:04:05".
+	date = strings.Replace(date, ":", "-", 2)
+
+	drawText(destImg, date, config.datestampPos, basicfont.Face7x13, config.datestampColor, nil)
+
+	return nil
+}
+
+// drawCaption draws the --text caption onto destImg.
+func drawCaption(destImg draw.Image, config *Config) error {
+	if config.text == "" {
+		return nil
+	}
+
+	face, err := loadFontFace(config.textFont, config.textSize, config)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	drawText(destImg, config.text, config.textPos, face, config.textColor, config.textBox)
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+)
+
+// thumbnailSize is the box generated thumbnails fit in, matching the 160x120
+// thumbnails cameras embed.
+var thumbnailSize = Size{width: 160, height: 120}
+
+// extractThumbnail writes the EXIF thumbnail of inputFile to thumbFile as is,
+// without decoding the full image. Inputs without one get a thumbnail
+// generated by downscaling instead. It reports whether the embedded
+// thumbnail was used.
+func extractThumbnail(inputFile string, thumbFile string, config *Config) (bool, error) {
+	meta := readMetadata(inputFile, config)
+	if meta.exif != nil {
+		if thumb, ok := meta.exif.thumbnail(); ok {
+			return true, writeOutput(thumbFile, config, func(w io.Writer) error {
+				_, err := w.Write(thumb)
+				return err
+			})
+		}
+	}
+
+	srcImg, err := readImage(inputFile, config)
+	if err != nil {
+		return false, err
+	}
+
+	thumb := resizeImage(srcImg, fitSize(srcImg.Bounds(), thumbnailSize))
+
+	return false, writeOutput(thumbFile, config, func(w io.Writer) error {
+		return encodeJPEG(w, thumb, config)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractThumbnail(t *testing.T) {
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, testJPEG(t, 32, 24), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	withThumb := newExifBuilder()
+	offset := withThumb.blob(thumb.Bytes())
+	ifd1 := withThumb.ifd([]testTag{
+		longTag(exifTagThumbnailOffset, offset),
+		longTag(exifTagThumbnailLength, uint32(thumb.Len())),
+	}, 0)
+	withoutThumb := newExifBuilder()
+
+	tests := []struct {
+		name      string
+		exif      []byte
+		embedded  bool
+		wantW     int
+		wantH     int
+		wantBytes []byte
+	}{
+		{"embedded", withThumb.finish(withThumb.ifd([]testTag{asciiTag(exifTagMake, "Gopher")}, ifd1)), true, 32, 24, thumb.Bytes()},
+		{"generated", withoutThumb.finish(withoutThumb.ifd([]testTag{asciiTag(exifTagMake, "Gopher")}, 0)), false, 160, 80, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inputFile := writeJPEGWithExif(t, dir, "in.jpg", testJPEG(t, 400, 200), tt.exif)
+			thumbFile := filepath.Join(dir, "thumb.jpg")
+
+			embedded, err := extractThumbnail(inputFile, thumbFile, testConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if embedded != tt.embedded {
+				t.Errorf("extractThumbnail used the embedded thumbnail = %v, want %v", embedded, tt.embedded)
+			}
+
+			data, err := os.ReadFile(thumbFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantBytes != nil && !bytes.Equal(data, tt.wantBytes) {
+				t.Error("thumbnail differs from the one embedded in the EXIF data")
+			}
+			config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != tt.wantW || config.Height != tt.wantH {
+				t.Errorf("thumbnail is %dx%d, want %dx%d", config.Width, config.Height, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
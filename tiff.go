@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+// convertPages renders every input with the shared config and writes them as
+// successive pages of a single TIFF document.
+func convertPages(inputFiles []string, outputFile string, config *Config) error {
+	if format := outputFormat(outputFile, config); format != "tiff" {
+		return fmt.Errorf("multiple inputs require a tiff or gif output, got %s", format)
+	}
+
+	pages := make([]image.Image, 0, len(inputFiles))
+	for _, inputFile := range inputFiles {
+		srcImg, err := readImage(inputFile, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inputFile, err)
+		}
+
+		meta := readMetadata(inputFile, config)
+		page, err := renderImage(toSRGB(srcImg, meta), meta, config.background, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inputFile, err)
+		}
+		pages = append(pages, page)
+	}
+
+	return writeOutput(outputFile, config, func(w io.Writer) error {
+		return encodeMultiPageTIFF(w, pages)
+	})
+}
+
+const (
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+)
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value uint32
+}
+
+// encodeMultiPageTIFF writes pages as a little-endian TIFF with one IFD per
+// page. Each page is stored as a single deflate-compressed strip of 8-bit RGBA
+// samples with unassociated alpha.
+func encodeMultiPageTIFF(w io.Writer, pages []image.Image) error {
+	strips := make([][]byte, len(pages))
+	for i, page := range pages {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(toNRGBA(page).Pix); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if buf.Len()%2 != 0 {
+			buf.WriteByte(0)
+		}
+		strips[i] = buf.Bytes()
+	}
+
+	const (
+		numEntries = 16
+		ifdSize    = 2 + numEntries*12 + 4
+		extraSize  = 8 + 8 + 8
+	)
+
+	var out bytes.Buffer
+	le := binary.LittleEndian
+
+	out.WriteString("II")
+	binary.Write(&out, le, uint16(42))
+	binary.Write(&out, le, uint32(8+len(strips[0])))
+
+	for i, page := range pages {
+		bounds := page.Bounds()
+
+		stripOffset := uint32(out.Len())
+		out.Write(strips[i])
+
+		ifdOffset := uint32(out.Len())
+		extraOffset := ifdOffset + ifdSize
+
+		var next uint32
+		if i+1 < len(pages) {
+			next = extraOffset + extraSize + uint32(len(strips[i+1]))
+		}
+
+		entries := []tiffEntry{
+			{254, tiffLong, 1, 2},
+			{256, tiffLong, 1, uint32(bounds.Dx())},
+			{257, tiffLong, 1, uint32(bounds.Dy())},
+			{258, tiffShort, 4, extraOffset},
+			{259, tiffShort, 1, 8},
+			{262, tiffShort, 1, 2},
+			{273, tiffLong, 1, stripOffset},
+			{277, tiffShort, 1, 4},
+			{278, tiffLong, 1, uint32(bounds.Dy())},
+			{279, tiffLong, 1, uint32(len(strips[i]))},
+			{282, tiffRational, 1, extraOffset + 8},
+			{283, tiffRational, 1, extraOffset + 16},
+			{284, tiffShort, 1, 1},
+			{296, tiffShort, 1, 2},
+			{297, tiffShort, 2, uint32(i) | uint32(len(pages))<<16},
+			{338, tiffShort, 1, 2},
+		}
+
+		binary.Write(&out, le, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(&out, le, e)
+		}
+		binary.Write(&out, le, next)
+
+		binary.Write(&out, le, [4]uint16{8, 8, 8, 8})
+		binary.Write(&out, le, [2]uint32{72, 1})
+		binary.Write(&out, le, [2]uint32{72, 1})
+	}
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
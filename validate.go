@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+)
+
+// validation describes an input that decoded successfully.
+type validation struct {
+	File       string `json:"file"`
+	Format     string `json:"format"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	ColorModel string `json:"colorModel"`
+	HasAlpha   bool   `json:"hasAlpha"`
+}
+
+// colorModelName names the color model of img by its concrete type, since
+// color.Model values carry no name of their own.
+func colorModelName(img image.Image) string {
+	switch img.(type) {
+	case *image.RGBA:
+		return "rgba"
+	case *image.RGBA64:
+		return "rgba64"
+	case *image.NRGBA:
+		return "nrgba"
+	case *image.NRGBA64:
+		return "nrgba64"
+	case *image.Gray:
+		return "gray"
+	case *image.Gray16:
+		return "gray16"
+	case *image.YCbCr:
+		return "ycbcr"
+	case *image.CMYK:
+		return "cmyk"
+	case *image.Paletted:
+		return "paletted"
+	default:
+		return fmt.Sprintf("%T", img)
+	}
+}
+
+// validateImage fully decodes inputFile, which catches truncated or damaged
+// files that image.DecodeConfig would accept since it only reads the header.
+// Unsupported formats and corrupt files are reported as distinct errors.
+func validateImage(inputFile string, config *Config) (*validation, error) {
+	img, format, err := decodeFully(inputFile, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return describeImage(inputFile, format, img), nil
+}
+
+// decodeFully decodes inputFile and returns it along with its format,
+// reporting decoding failures as a corrupt file.
+func decodeFully(inputFile string, config *Config) (image.Image, string, error) {
+	format := inputFormat(inputFile, config)
+
+	decode, err := decoderFor(format, config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	img, err := decode(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("corrupt %s file %s: %w", format, inputFile, err)
+	}
+
+	return img, format, nil
+}
+
+func describeImage(inputFile string, format string, img image.Image) *validation {
+	// Every standard image type reports whether it is fully opaque.
+	hasAlpha := false
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		hasAlpha = !o.Opaque()
+	}
+
+	bounds := img.Bounds()
+
+	return &validation{
+		File:       inputFile,
+		Format:     format,
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+		ColorModel: colorModelName(img),
+		HasAlpha:   hasAlpha,
+	}
+}
+
+func printValidation(inputFile string, asJSON bool, config *Config) error {
+	result, err := validateImage(inputFile, config)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	fmt.Printf(
+		"%s: valid %s, %dx%d, %s, alpha: %t\n",
+		result.File, result.Format, result.Width, result.Height, result.ColorModel, result.HasAlpha,
+	)
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+)
+
+// warn reports a condition that degrades the output without making it
+// unusable. It logs and returns nil, unless --strict turns warnings into
+// errors, in which case it returns the message as an error to fail with.
+//
+// The conditions warned about are:
+//   - --scale enlarging the image without --no-upscale
+//   - transparency flattened onto the background for JPEG or GIF output
+//   - --datestamp on an input without an EXIF capture date
+//   - a --font that cannot be loaded, falling back to the built-in font
+//   - that EXIF metadata cannot be carried over to the output
+//   - a --data-uri larger than dataURIWarnSize
+//   - --trim-transparent on an image without any visible pixels
+//   - an input whose extension names another format than its content
+//   - a truncated JPEG kept as a partial image under --allow-partial
+//   - --rotate-lossless falling back to re-encoding the JPEG
+//   - a --split grid that doesn't divide the image evenly
+func warn(config *Config, format string, args ...any) error {
+	if config.strict {
+		return fmt.Errorf(format, args...)
+	}
+
+	log.Printf("warning: "+format, args...)
+	return nil
+}
+
+// displayName returns the name to report inputFile by: the path itself, or
+// what it stands for when it is a copy, such as "standard input" for the
+// spooled copy of piped data.
+func (c *Config) displayName(inputFile string) string {
+	if c.inputName != "" {
+		return c.inputName
+	}
+
+	return inputFile
+}
+
+// warnFlatten warns when img has transparency that is about to be flattened
+// onto the background of an output format without alpha.
+func warnFlatten(img image.Image, inputFile string, config *Config) error {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return nil
+	}
+
+	return warn(config, "%s has transparency, flattening it onto the background", config.displayName(inputFile))
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+
+	"golang.org/x/image/riff"
+	"golang.org/x/image/webp"
+)
+
+var (
+	fccWEBP = riff.FourCC{'W', 'E', 'B', 'P'}
+	fccVP8X = riff.FourCC{'V', 'P', '8', 'X'}
+	fccANMF = riff.FourCC{'A', 'N', 'M', 'F'}
+	fccALPH = riff.FourCC{'A', 'L', 'P', 'H'}
+	fccVP8  = riff.FourCC{'V', 'P', '8', ' '}
+	fccVP8L = riff.FourCC{'V', 'P', '8', 'L'}
+)
+
+const (
+	webpAnimationFlag = 1 << 1
+	webpAlphaFlag     = 1 << 4
+)
+
+// maxWebPCanvasPixels bounds the canvas an animated WebP may declare. VP8X
+// allows up to 2^24 pixels on a side, and the canvas is allocated, along with
+// a copy per frame, before any frame data is read.
+const maxWebPCanvasPixels = 1 << 26
+
+// animFrame is one frame of an animation along with how long it is shown, in
+// milliseconds.
+type animFrame struct {
+	img   image.Image
+	delay int
+}
+
+// decodeWebP decodes a WebP image. Animated files decode to their first
+// frame; readWebPFrames returns all of them.
+func decodeWebP(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAnimatedWebP(data) {
+		return webp.Decode(bytes.NewReader(data))
+	}
+
+	frames, err := decodeWebPFrames(data, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return frames[0].img, nil
+}
+
+// isAnimatedWebP reports whether data starts like a WebP file with the
+// animation flag set in its VP8X header.
+func isAnimatedWebP(data []byte) bool {
+	return len(data) >= 21 &&
+		string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP" && string(data[12:16]) == "VP8X" &&
+		data[20]&webpAnimationFlag != 0
+}
+
+// isAnimatedWebPFile reports whether inputFile is an animated WebP.
+func isAnimatedWebPFile(inputFile string) bool {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 21)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+
+	return isAnimatedWebP(header)
+}
+
+// readWebPFrames decodes every frame of the WebP inputFile, which may be
+// animated or a still image with a single frame shown for delay.
+func readWebPFrames(inputFile string, delay int) ([]animFrame, error) {
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAnimatedWebP(data) {
+		img, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return []animFrame{{img: img, delay: delay}}, nil
+	}
+
+	return decodeWebPFrames(data, 0)
+}
+
+// decodeWebPFrames decodes the ANMF chunks of an animated WebP, stopping
+// after limit frames unless limit is 0. golang.org/x/image/webp only reads
+// still images, so each frame's bitstream is rewrapped in a container of its
+// own, decoded, and composited onto the canvas following the frame's
+// blending and disposal methods. The canvas starts out transparent, which
+// the format allows in place of the background color hint.
+func decodeWebPFrames(data []byte, limit int) ([]animFrame, error) {
+	formType, chunks, err := riff.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if formType != fccWEBP {
+		return nil, errors.New("webp: invalid format")
+	}
+
+	var canvas *image.NRGBA
+	var frames []animFrame
+	var dispose image.Rectangle
+
+	for limit == 0 || len(frames) < limit {
+		chunkID, _, chunkData, err := chunks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		chunk, err := io.ReadAll(chunkData)
+		if err != nil {
+			return nil, err
+		}
+
+		switch chunkID {
+		case fccVP8X:
+			if len(chunk) < 10 {
+				return nil, errors.New("webp: invalid VP8X chunk")
+			}
+			width, height := int(uint24(chunk[4:]))+1, int(uint24(chunk[7:]))+1
+			if width*height > maxWebPCanvasPixels {
+				return nil, fmt.Errorf("webp: %dx%d canvas is larger than %d pixels", width, height, maxWebPCanvasPixels)
+			}
+			canvas = image.NewNRGBA(image.Rect(0, 0, width, height))
+
+		case fccANMF:
+			if canvas == nil || len(chunk) < 16 {
+				return nil, errors.New("webp: invalid ANMF chunk")
+			}
+
+			x, y := 2*int(uint24(chunk[0:])), 2*int(uint24(chunk[3:]))
+			width, height := int(uint24(chunk[6:]))+1, int(uint24(chunk[9:]))+1
+			duration := int(uint24(chunk[12:]))
+			blend := chunk[15]&0x02 == 0
+			disposeToBackground := chunk[15]&0x01 != 0
+
+			rect := image.Rect(x, y, x+width, y+height)
+			if !rect.In(canvas.Rect) {
+				return nil, fmt.Errorf("webp: frame %d at %v lies outside the %dx%d canvas", len(frames)+1, rect, canvas.Rect.Dx(), canvas.Rect.Dy())
+			}
+
+			frameImg, err := decodeWebPFrame(chunk[16:], width, height)
+			if err != nil {
+				return nil, fmt.Errorf("webp: frame %d: %w", len(frames)+1, err)
+			}
+
+			// The previous frame's disposal applies before this one is drawn.
+			draw.Draw(canvas, dispose, image.Transparent, image.Point{}, draw.Src)
+
+			op := draw.Src
+			if blend {
+				op = draw.Over
+			}
+			draw.Draw(canvas, rect, frameImg, frameImg.Bounds().Min, op)
+
+			dispose = image.Rectangle{}
+			if disposeToBackground {
+				dispose = rect
+			}
+
+			snapshot := image.NewNRGBA(canvas.Rect)
+			copy(snapshot.Pix, canvas.Pix)
+			frames = append(frames, animFrame{img: snapshot, delay: duration})
+		}
+	}
+
+	if len(frames) == 0 {
+		return nil, errors.New("webp: animation has no frames")
+	}
+
+	return frames, nil
+}
+
+// decodeWebPFrame decodes the frame data of an ANMF chunk: an optional ALPH
+// chunk followed by a VP8 or VP8L one.
+func decodeWebPFrame(frameData []byte, width int, height int) (image.Image, error) {
+	var alph, bitstream []byte
+	for len(frameData) >= 8 {
+		size := int(binary.LittleEndian.Uint32(frameData[4:8]))
+		end := 8 + size + size&1
+		if 8+size > len(frameData) {
+			return nil, errors.New("truncated chunk")
+		}
+
+		switch riff.FourCC(frameData[:4]) {
+		case fccALPH:
+			alph = frameData[:8+size]
+		case fccVP8, fccVP8L:
+			bitstream = frameData[:8+size]
+		}
+
+		frameData = frameData[min(end, len(frameData)):]
+	}
+
+	if bitstream == nil {
+		return nil, errors.New("no image data")
+	}
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if alph != nil && riff.FourCC(bitstream[:4]) == fccVP8 {
+		vp8x := make([]byte, 18)
+		copy(vp8x, "VP8X")
+		binary.LittleEndian.PutUint32(vp8x[4:], 10)
+		vp8x[8] = webpAlphaFlag
+		putUint24(vp8x[12:], uint32(width-1))
+		putUint24(vp8x[15:], uint32(height-1))
+		body.Write(vp8x)
+		writePaddedChunk(&body, alph)
+	}
+	writePaddedChunk(&body, bitstream)
+
+	file := make([]byte, 8, 8+body.Len())
+	copy(file, "RIFF")
+	binary.LittleEndian.PutUint32(file[4:], uint32(body.Len()))
+
+	return webp.Decode(bytes.NewReader(append(file, body.Bytes()...)))
+}
+
+func writePaddedChunk(buf *bytes.Buffer, chunk []byte) {
+	buf.Write(chunk)
+	if len(chunk)%2 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
@@ -0,0 +1,30 @@
+//go:build webp
+
+package main
+
+import (
+	"image"
+	"io"
+
+	"github.com/gen2brain/webp"
+)
+
+// webpEncodeSupported reports whether this binary was built with the WebP
+// encoder.
+const webpEncodeSupported = true
+
+func init() {
+	RegisterEncoder("webp", encodeWebP)
+}
+
+// encodeWebP writes lossy WebP at the --quality setting, keeping alpha.
+func encodeWebP(w io.Writer, img image.Image, config *Config) error {
+	return webp.Encode(w, img, webpOptions(config))
+}
+
+func webpOptions(config *Config) webp.Options {
+	return webp.Options{
+		Quality: config.qualityFor("webp"),
+		Method:  webp.DefaultMethod,
+	}
+}
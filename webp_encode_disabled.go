@@ -0,0 +1,8 @@
+//go:build !webp
+
+package main
+
+// webpEncodeSupported reports whether this binary was built with the WebP
+// encoder. WebP input is always supported, but the encoder embeds libwebp
+// translated to Go, so it is only compiled in with the webp build tag.
+const webpEncodeSupported = false
@@ -0,0 +1,12 @@
+//go:build webp
+
+package main
+
+import "testing"
+
+func TestWebPOptionsAutoQuality(t *testing.T) {
+	config := &Config{quality: 0, autoQuality: true, avifQuality: -1}
+	if got := webpOptions(config).Quality; got == 0 {
+		t.Errorf("webp quality under --quality auto = 0, want %d", defaultQualities["webp"])
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// animatedWebP returns an animated WebP with a width x height canvas and one
+// ANMF chunk per frame rectangle, each {x, y, width, height}. The frames
+// carry no image data, which is never reached when the layout is rejected.
+func animatedWebP(width, height int, frames ...[4]int) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+
+	vp8x := make([]byte, 18)
+	copy(vp8x, "VP8X")
+	binary.LittleEndian.PutUint32(vp8x[4:], 10)
+	vp8x[8] = webpAnimationFlag
+	putUint24(vp8x[12:], uint32(width-1))
+	putUint24(vp8x[15:], uint32(height-1))
+	body.Write(vp8x)
+
+	for _, f := range frames {
+		anmf := make([]byte, 24)
+		copy(anmf, "ANMF")
+		binary.LittleEndian.PutUint32(anmf[4:], 16)
+		putUint24(anmf[8:], uint32(f[0]/2))
+		putUint24(anmf[11:], uint32(f[1]/2))
+		putUint24(anmf[14:], uint32(f[2]-1))
+		putUint24(anmf[17:], uint32(f[3]-1))
+		putUint24(anmf[20:], 100)
+		body.Write(anmf)
+	}
+
+	file := []byte("RIFF")
+	file = binary.LittleEndian.AppendUint32(file, uint32(body.Len()))
+	return append(file, body.Bytes()...)
+}
+
+func TestDecodeWebPFramesLayout(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		errMsg string
+	}{
+		{"huge canvas", animatedWebP(1<<24, 1<<24), "larger than"},
+		{"frame past the right edge", animatedWebP(100, 100, [4]int{60, 0, 50, 50}), "outside"},
+		{"frame past the bottom edge", animatedWebP(100, 100, [4]int{0, 0, 100, 101}), "outside"},
+		{"frame far outside", animatedWebP(16, 16, [4]int{1 << 24, 1 << 24, 1 << 24, 1 << 24}), "outside"},
+		{"frame inside", animatedWebP(100, 100, [4]int{50, 50, 50, 50}), "no image data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeWebPFrames(tt.data, 0)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("decodeWebPFrames() error = %v, want one containing %q", err, tt.errMsg)
+			}
+		})
+	}
+}